@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAnswerCacheTTL is how long answers are cached when
+// ANSWER_CACHE_TTL is unset.
+const DefaultAnswerCacheTTL = 5 * time.Minute
+
+// AnswerCache keeps the most recent model answers in memory, so a repeated
+// query about an unchanged table is answered without calling the model. It
+// holds at most size answers, evicting the least recently used, and forgets
+// each answer ttl after it was added. It is safe for concurrent use, and a
+// nil *AnswerCache caches nothing.
+type AnswerCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of *cachedAnswer, most recently used first
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cachedAnswer struct {
+	key      string
+	response Response
+	expires  time.Time
+}
+
+// NewAnswerCache holds up to size answers for ttl each.
+func NewAnswerCache(size int, ttl time.Duration) *AnswerCache {
+	return &AnswerCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get returns the answer cached under key, if it has not expired.
+func (a *AnswerCache) Get(key string) (Response, bool) {
+	if a == nil {
+		return Response{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	elem, ok := a.entries[key]
+	if !ok {
+		return Response{}, false
+	}
+	entry := elem.Value.(*cachedAnswer)
+	if !a.now().Before(entry.expires) {
+		a.order.Remove(elem)
+		delete(a.entries, key)
+		return Response{}, false
+	}
+	a.order.MoveToFront(elem)
+	return entry.response, true
+}
+
+// Add caches response under key, replacing any answer already there.
+func (a *AnswerCache) Add(key string, response Response) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	expires := a.now().Add(a.ttl)
+	if elem, ok := a.entries[key]; ok {
+		elem.Value = &cachedAnswer{key: key, response: response, expires: expires}
+		a.order.MoveToFront(elem)
+		return
+	}
+	a.entries[key] = a.order.PushFront(&cachedAnswer{key: key, response: response, expires: expires})
+	for a.order.Len() > a.size {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.entries, oldest.Value.(*cachedAnswer).key)
+	}
+}
+
+// Len returns the number of cached answers, including expired ones not yet
+// evicted.
+func (a *AnswerCache) Len() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.order.Len()
+}
+
+// answerKey hashes what the answer to inputs depends on: the model, the
+// query with its whitespace collapsed, and the table's columns in the order
+// they are sent, with their cells.
+func answerKey(model string, inputs Inputs) string {
+	h := sha256.New()
+	writeField(h, model)
+	writeField(h, strings.Join(strings.Fields(inputs.Query), " "))
+	for _, column := range inputs.ColumnOrder() {
+		cells := inputs.Table[column]
+		writeField(h, column)
+		writeField(h, strconv.Itoa(len(cells)))
+		for _, cell := range cells {
+			writeField(h, cell)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes s to h prefixed with its length, so that different
+// sequences of fields never hash the same bytes.
+func writeField(h hash.Hash, s string) {
+	h.Write([]byte(strconv.Itoa(len(s))))
+	h.Write([]byte{':'})
+	h.Write([]byte(s))
+}
@@ -0,0 +1,126 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("AnswerCache", func() {
+	response := main.Response{Answer: "TV", Coordinates: [][]int{{0, 0}}, Cells: []string{"TV"}, Aggregator: "NONE"}
+
+	It("returns cached answers until they expire", func() {
+		cache := main.NewAnswerCache(10, 20*time.Millisecond)
+		_, ok := cache.Get("key")
+		Expect(ok).Should(BeFalse())
+
+		cache.Add("key", response)
+		cached, ok := cache.Get("key")
+		Expect(ok).Should(BeTrue())
+		Expect(cached).Should(Equal(response))
+
+		Eventually(func() bool {
+			_, ok := cache.Get("key")
+			return ok
+		}).Should(BeFalse())
+		Expect(cache.Len()).Should(BeZero())
+	})
+
+	It("evicts the least recently used answer when full", func() {
+		cache := main.NewAnswerCache(2, time.Hour)
+		cache.Add("a", response)
+		cache.Add("b", response)
+		_, _ = cache.Get("a")
+		cache.Add("c", response)
+
+		Expect(cache.Len()).Should(Equal(2))
+		_, ok := cache.Get("b")
+		Expect(ok).Should(BeFalse())
+		_, ok = cache.Get("a")
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("caches nothing when nil", func() {
+		var cache *main.AnswerCache
+		cache.Add("key", response)
+		_, ok := cache.Get("key")
+		Expect(ok).Should(BeFalse())
+	})
+
+	Context("in the server", func() {
+		var (
+			model  *fakeModel
+			router http.Handler
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: response}
+			server := &main.Server{
+				Model:   model,
+				Answers: main.NewAnswerCache(10, time.Hour),
+				Metrics: main.NewMetrics(prometheus.NewRegistry()),
+			}
+			router = server.Router()
+		})
+
+		post := func(path, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			return rec
+		}
+		scrape := func() string {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			return rec.Body.String()
+		}
+
+		It("answers a repeated query about the same table from the cache", func() {
+			first := post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`)
+			Expect(first.Code).Should(Equal(http.StatusOK))
+			second := post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "  Which   appliance? "}`)
+			Expect(second.Code).Should(Equal(http.StatusOK))
+
+			Expect(second.Body.String()).Should(Equal(first.Body.String()))
+			Expect(model.received).Should(HaveLen(1))
+			Expect(scrape()).Should(And(
+				ContainSubstring("answer_cache_hits_total 1"),
+				ContainSubstring("answer_cache_misses_total 1"),
+			))
+		})
+
+		It("asks the model again when the table or query changes", func() {
+			Expect(post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`).Code).Should(Equal(http.StatusOK))
+			Expect(post("/ask-json", `{"table": {"Appliance": ["Fridge"]}, "query": "Which appliance?"}`).Code).Should(Equal(http.StatusOK))
+			Expect(post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which room?"}`).Code).Should(Equal(http.StatusOK))
+
+			Expect(model.received).Should(HaveLen(3))
+			Expect(scrape()).Should(ContainSubstring("answer_cache_misses_total 3"))
+		})
+
+		It("skips the cache with ?no_cache=true", func() {
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+			Expect(post("/ask-json", body).Code).Should(Equal(http.StatusOK))
+			Expect(post("/ask-json?no_cache=true", body).Code).Should(Equal(http.StatusOK))
+
+			Expect(model.received).Should(HaveLen(2))
+			Expect(scrape()).Should(ContainSubstring("answer_cache_hits_total 0"))
+		})
+
+		It("does not cache failed answers", func() {
+			model.err = &main.UpstreamError{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+			Expect(post("/ask-json", body).Code).Should(Equal(http.StatusBadGateway))
+
+			model.err = nil
+			Expect(post("/ask-json", body).Code).Should(Equal(http.StatusOK))
+			Expect(model.received).Should(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader is the request header, besides "Authorization: Bearer", that
+// carries an API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys guards the endpoints that call the model, which cost money, with
+// a set of shared keys. Clients send a key as "Authorization: Bearer <key>"
+// or in the X-API-Key header. Several keys can be valid at once, so a key can
+// be rotated without downtime: add the new key, move the clients over, then
+// remove the old one. A nil *APIKeys lets every request through.
+type APIKeys struct {
+	// hashes are the SHA-256 sums of the keys, so comparisons take the same
+	// time whatever the length of the key sent.
+	hashes [][sha256.Size]byte
+}
+
+// NewAPIKeys accepts any of keys.
+func NewAPIKeys(keys []string) *APIKeys {
+	a := &APIKeys{}
+	for _, key := range keys {
+		a.hashes = append(a.hashes, sha256.Sum256([]byte(key)))
+	}
+	return a
+}
+
+// Valid reports whether key is one of the accepted keys. It compares key
+// against every accepted key in constant time, so neither the timing nor the
+// position of a match leaks which bytes were right.
+func (a *APIKeys) Valid(key string) bool {
+	if key == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(key))
+	valid := 0
+	for _, hash := range a.hashes {
+		valid |= subtle.ConstantTimeCompare(sum[:], hash[:])
+	}
+	return valid == 1
+}
+
+// middleware rejects requests without a valid key with 401.
+func (a *APIKeys) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			return
+		}
+		key, ok := requestAPIKey(c)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorJSON(c, CodeUnauthorized, "Missing API key, expected an Authorization: Bearer header or "+APIKeyHeader))
+			return
+		}
+		if !a.Valid(key) {
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorJSON(c, CodeUnauthorized, "Invalid API key"))
+		}
+	}
+}
+
+// requestAPIKey returns the key sent with the request, if any.
+func requestAPIKey(c *gin.Context) (string, bool) {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		scheme, key, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		key = strings.TrimSpace(key)
+		return key, key != ""
+	}
+	key := strings.TrimSpace(c.GetHeader(APIKeyHeader))
+	return key, key != ""
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("APIKeys", func() {
+	It("accepts any of its keys", func() {
+		keys := main.NewAPIKeys([]string{"old-key", "new-key"})
+		Expect(keys.Valid("old-key")).Should(BeTrue())
+		Expect(keys.Valid("new-key")).Should(BeTrue())
+		Expect(keys.Valid("new-ke")).Should(BeFalse())
+		Expect(keys.Valid("new-key2")).Should(BeFalse())
+		Expect(keys.Valid("")).Should(BeFalse())
+	})
+
+	Context("in the server", func() {
+		var (
+			model  *fakeModel
+			router http.Handler
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "TV"}}
+			server := &main.Server{Model: model, APIKeys: main.NewAPIKeys([]string{"old-key", "new-key"})}
+			router = server.Router()
+		})
+
+		ask := func(header, value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`))
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("answers requests with a valid key", func() {
+			Expect(ask("Authorization", "Bearer old-key").Code).Should(Equal(http.StatusOK))
+			Expect(ask("Authorization", "bearer new-key").Code).Should(Equal(http.StatusOK))
+			Expect(ask(main.APIKeyHeader, "new-key").Code).Should(Equal(http.StatusOK))
+			Expect(model.received).Should(HaveLen(3))
+		})
+
+		It("rejects requests with an invalid key", func() {
+			for _, header := range [][2]string{
+				{"Authorization", "Bearer wrong-key"},
+				{"Authorization", "Basic b2xkLWtleQ=="},
+				{main.APIKeyHeader, "wrong-key"},
+			} {
+				rec := ask(header[0], header[1])
+				Expect(rec.Code).Should(Equal(http.StatusUnauthorized), header[1])
+				Expect(rec.Header().Get("WWW-Authenticate")).Should(HavePrefix("Bearer"))
+
+				var body main.ErrorResponse
+				Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+				Expect(body.Error.Code).Should(Equal(main.CodeUnauthorized))
+			}
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("rejects requests without a key", func() {
+			rec := ask("", "")
+			Expect(rec.Code).Should(Equal(http.StatusUnauthorized))
+			Expect(rec.Body.String()).Should(ContainSubstring("Missing API key"))
+
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tables", nil))
+			Expect(rec.Code).Should(Equal(http.StatusUnauthorized))
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("leaves health checks open", func() {
+			for _, path := range []string{"/healthz", "/version"} {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				Expect(rec.Code).Should(Equal(http.StatusOK), path)
+			}
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"a21hc3NpZ25tZW50/tableqa"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultBatchConcurrency is used when Server.BatchConcurrency is zero.
+	DefaultBatchConcurrency = 4
+	// MaxBatchQueries bounds the number of queries in one /ask-batch request.
+	MaxBatchQueries = 50
+)
+
+// BatchResult is the outcome of one query in a batch: either Response or
+// Error is set, or in a dry run Payload, the body that would be sent to the
+// model for the query. Response is the body /ask would answer the query
+// with, given the same query parameters.
+type BatchResult struct {
+	Response interface{}     `json:"response,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Error    *ErrorDetail    `json:"error,omitempty"`
+}
+
+// handleAskBatch answers several queries against one table, the inline
+// "table" if given or the server's CSV otherwise. Queries are sent to the
+// model concurrently and the results are returned in query order; a failed
+// query does not fail the others. The query parameters of /ask apply to
+// every query, except ?debug and ?preview, which are rejected, and answers
+// are always JSON.
+func (s *Server) handleAskBatch(c *gin.Context) {
+	if c.NegotiateFormat(gin.MIMEJSON) == "" {
+		c.JSON(http.StatusNotAcceptable, errorJSON(c, CodeUnsupportedMedia, fmt.Sprintf("Unsupported Accept %q, expected %s", c.GetHeader("Accept"), gin.MIMEJSON)))
+		return
+	}
+
+	var jsonData struct {
+		Queries []string            `json:"queries"`
+		Table   map[string][]string `json:"table"`
+		Model   string              `json:"model"`
+	}
+	if !s.bindJSON(c, &jsonData) {
+		return
+	}
+	if len(jsonData.Queries) == 0 || len(jsonData.Queries) > MaxBatchQueries {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("A batch must contain between 1 and %d queries", MaxBatchQueries)))
+		return
+	}
+
+	table, headers, ok := s.tableOrData(c, jsonData.Table)
+	if !ok {
+		return
+	}
+	opts, ok := newAskOptions(c, jsonData.Model)
+	if !ok {
+		return
+	}
+	if opts.debug || opts.preview > 0 {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, "debug and preview are not supported by /ask-batch"))
+		return
+	}
+	var truncation *Truncation
+	if rows := tableqa.TableRows(table); opts.maxRows > 0 && rows > opts.maxRows {
+		table = TruncateTable(table, opts.maxRows)
+		truncation = &Truncation{Truncated: true, OriginalRows: rows}
+	}
+	if !s.checkRequest(c, table, opts.model) {
+		return
+	}
+	index, ok := s.indexColumn(c, table, opts)
+	if !ok {
+		return
+	}
+	if s.DryRun || opts.dryRun {
+		s.writeBatchDryRun(c, Inputs{Table: table, Columns: headers}, jsonData.Queries, opts)
+		return
+	}
+	if !s.checkToken(c) {
+		return
+	}
+
+	workers := s.BatchConcurrency
+	if workers <= 0 {
+		workers = DefaultBatchConcurrency
+	}
+
+	results := make([]BatchResult, len(jsonData.Queries))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jsonData.Queries); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				query, err := ValidateQuery(jsonData.Queries[i], s.maxQueryLength())
+				if err != nil {
+					results[i] = BatchResult{Error: &ErrorDetail{Code: CodeInvalidQuery, Message: err.Error(), Field: fmt.Sprintf("queries[%d]", i)}}
+					continue
+				}
+				inputs := Inputs{Table: table, Query: query, Columns: headers}
+				response, err := s.callModel(c.Request.Context(), inputs, opts)
+				if err != nil {
+					results[i] = BatchResult{Error: &ErrorDetail{Code: upstreamCode(err), Message: "Error connecting to AI model: " + tableqa.RedactToken(err.Error(), s.Token)}}
+					continue
+				}
+				response.Candidates = response.CandidateAnswers()
+				body, err := answerBody(inputs, response, opts, index, truncation, nil, nil)
+				if err != nil {
+					results[i] = BatchResult{Error: &ErrorDetail{Code: CodeInvalidUpstreamResponse, Message: err.Error()}}
+					continue
+				}
+				results[i] = BatchResult{Response: body}
+			}
+		}()
+	}
+	for i := range jsonData.Queries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// writeBatchDryRun responds with the body that would be sent to the model for
+// each of queries about the table in inputs, without sending any.
+func (s *Server) writeBatchDryRun(c *gin.Context, inputs Inputs, queries []string, opts askOptions) {
+	connector, url, ok := s.dryRunConnector(c, opts)
+	if !ok {
+		return
+	}
+
+	results := make([]BatchResult, len(queries))
+	for i, query := range queries {
+		query, err := ValidateQuery(query, s.maxQueryLength())
+		if err != nil {
+			results[i] = BatchResult{Error: &ErrorDetail{Code: CodeInvalidQuery, Message: err.Error(), Field: fmt.Sprintf("queries[%d]", i)}}
+			continue
+		}
+		inputs.Query = query
+		payload, err := connector.BuildPayload(inputs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(c, CodeInternal, fmt.Sprintf("Error building payload: %v", err)))
+			return
+		}
+		results[i] = BatchResult{Payload: payload}
+	}
+
+	c.Header("X-Dry-Run", "true")
+	c.Header("X-Model-URL", url)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// tableOrData returns table prepared for the model, or the server's CSV with
+// its headers when table is nil. It writes an error response and returns
+// false when the CSV cannot be read.
+func (s *Server) tableOrData(c *gin.Context, table map[string][]string) (map[string][]string, []string, bool) {
+	var headers []string
+	if table == nil {
+		if s.Data == nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(c, CodeDataUnavailable, "No CSV file is configured"))
+			return nil, nil, false
+		}
+		var err error
+		table, headers, err = s.Data.Get()
+		if err != nil {
+			s.Metrics.csvParseFailed()
+			writeDataError(c, "CSV file", err)
+			return nil, nil, false
+		}
+	}
+	return s.prepareTable(table), headers, true
+}
@@ -0,0 +1,134 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("POST /ask-batch", func() {
+	var (
+		server *main.Server
+		calls  atomic.Int32
+	)
+
+	BeforeEach(func() {
+		calls.Store(0)
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			var inputs main.Inputs
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &inputs)
+			if strings.Contains(inputs.Query, "fail") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(main.Response{Answer: "answer to " + inputs.Query})
+		}))
+		DeferCleanup(model.Close)
+
+		server = &main.Server{Connector: newServerConnector(model), Token: "token", BatchConcurrency: 2}
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-batch", strings.NewReader(body)))
+		return rec
+	}
+
+	It("answers each query in order and reports failures per query", func() {
+		rec := post(`{"table": {"Appliance": ["TV", "Lamp"]}, "queries": ["first", "please fail", "third"]}`)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+
+		var body struct {
+			Results []struct {
+				Response *main.Response    `json:"response"`
+				Error    *main.ErrorDetail `json:"error"`
+			} `json:"results"`
+		}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+		Expect(body.Results).Should(HaveLen(3))
+
+		Expect(body.Results[0].Error).Should(BeNil())
+		Expect(body.Results[0].Response.Answer).Should(Equal("answer to first"))
+
+		Expect(body.Results[1].Response).Should(BeNil())
+		Expect(body.Results[1].Error.Code).Should(Equal(main.CodeUpstreamUnavailable))
+		Expect(body.Results[1].Error.Message).Should(ContainSubstring("400"))
+
+		Expect(body.Results[2].Error).Should(BeNil())
+		Expect(body.Results[2].Response.Answer).Should(Equal("answer to third"))
+	})
+
+	It("returns the payloads instead of calling the model in a dry run", func() {
+		check := func(rec *httptest.ResponseRecorder) {
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Header().Get("X-Dry-Run")).Should(Equal("true"))
+			Expect(rec.Body.String()).Should(MatchJSON(`{"results": [
+				{"payload": {"table": {"Appliance": ["TV"]}, "query": "first"}},
+				{"error": {"code": "invalid_query", "message": "query must not be empty", "field": "queries[1]"}}
+			]}`))
+		}
+		body := `{"table": {"Appliance": ["TV"]}, "queries": ["first", " "]}`
+
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-batch?dry_run=true", strings.NewReader(body)))
+		check(rec)
+
+		server.DryRun = true
+		check(post(body))
+		Expect(calls.Load()).Should(BeZero())
+	})
+
+	It("applies the query options of /ask to every query", func() {
+		rec := httptest.NewRecorder()
+		body := `{"table": {"ID": ["a", "b", "c"], "Appliance": ["TV", "Lamp", "Fan"]}, "queries": ["first", "second"]}`
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-batch?verbose=true&clean=true&max_rows=2&index_column=ID", strings.NewReader(body)))
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+
+		var results struct {
+			Results []struct {
+				Response map[string]interface{} `json:"response"`
+			} `json:"results"`
+		}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &results)).Should(Succeed())
+		Expect(results.Results).Should(HaveLen(2))
+		for i, query := range []string{"first", "second"} {
+			response := results.Results[i].Response
+			Expect(response).Should(HaveKeyWithValue("clean_answer", "answer to "+query))
+			Expect(response).Should(HaveKeyWithValue("resolved_cells", BeEmpty()))
+			Expect(response).Should(HaveKeyWithValue("index_column", "ID"))
+			Expect(response).Should(HaveKeyWithValue("truncated", true))
+			Expect(response).Should(HaveKeyWithValue("original_rows", BeNumerically("==", 3)))
+		}
+	})
+
+	It("rejects the options it cannot apply", func() {
+		body := `{"table": {"Appliance": ["TV"]}, "queries": ["first"]}`
+		for _, path := range []string{"/ask-batch?debug=true", "/ask-batch?preview=1", "/ask-batch?max_rows=none", "/ask-batch?index_column=Room"} {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest), path)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/ask-batch", strings.NewReader(body))
+		req.Header.Set("Accept", "text/csv")
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusNotAcceptable))
+		Expect(calls.Load()).Should(BeZero())
+	})
+
+	It("rejects an empty batch", func() {
+		rec := post(`{"table": {"Appliance": ["TV"]}, "queries": []}`)
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long a CircuitBreaker stays open when
+// CIRCUIT_BREAKER_COOLDOWN is unset.
+const DefaultBreakerCooldown = 30 * time.Second
+
+// BreakerState is the state of a CircuitBreaker, exported as the value of
+// the circuit_breaker_state gauge.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets one probe call through after the cooldown; its
+	// outcome closes or reopens the breaker.
+	BreakerHalfOpen
+	// BreakerOpen fails calls immediately.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// CircuitOpenError is returned instead of calling the model while the
+// breaker is open.
+type CircuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "model calls are suspended after repeated upstream failures"
+}
+
+// RetryAfter reports how long until the breaker lets a probe through, or
+// zero when a probe is already running.
+func (e *CircuitOpenError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// CircuitBreaker stops calling the model while it is down. After threshold
+// consecutive outages it opens and fails calls at once for cooldown, then
+// lets a single probe through: success closes it, another outage reopens it.
+// Only outages count: timeouts, connection errors, 5xx statuses and loading
+// models, not errors caused by the request. It is safe for concurrent use,
+// and a nil *CircuitBreaker allows everything.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// NewCircuitBreaker opens after threshold consecutive outages and stays open
+// for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow returns a *CircuitOpenError if a call must not be made now. When it
+// returns nil, the caller must report the call's outcome to Done.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return &CircuitOpenError{retryAfter: b.openedAt.Add(b.cooldown).Sub(b.now())}
+	case BreakerHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{}
+		}
+		b.state, b.probing = BreakerHalfOpen, true
+	}
+	return nil
+}
+
+// Done records the outcome of a call that Allow let through.
+func (b *CircuitBreaker) Done(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := b.probing
+	b.probing = false
+	switch {
+	case isOutage(err):
+		b.failures++
+		if probe || b.failures >= b.threshold {
+			b.state, b.openedAt = BreakerOpen, b.now()
+		}
+	case errors.Is(err, context.Canceled):
+		// the client gave up, which says nothing about the model
+	default:
+		b.state, b.failures = BreakerClosed, 0
+	}
+}
+
+// State returns the breaker's current state.
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+// currentState is the state with an elapsed cooldown taken into account.
+// b.mu must be held.
+func (b *CircuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.cooldown)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// isOutage reports whether err means the model is unavailable rather than
+// that the request was wrong.
+func isOutage(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+
+	var (
+		loading  *ModelLoadingError
+		upstream *UpstreamError
+		urlErr   *url.Error
+	)
+	if errors.As(err, &upstream) {
+		return upstream.StatusCode >= 500
+	}
+	return errors.As(err, &loading) || errors.As(err, &urlErr)
+}
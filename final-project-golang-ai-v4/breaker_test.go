@@ -0,0 +1,124 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	outage := &main.UpstreamError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+
+	fail := func(breaker *main.CircuitBreaker, times int, err error) {
+		for i := 0; i < times; i++ {
+			Expect(breaker.Allow()).Should(Succeed())
+			breaker.Done(err)
+		}
+	}
+
+	It("opens after consecutive outages and rejects calls during the cooldown", func() {
+		breaker := main.NewCircuitBreaker(3, time.Hour)
+		fail(breaker, 2, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerClosed))
+
+		fail(breaker, 1, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerOpen))
+
+		var open *main.CircuitOpenError
+		Expect(errors.As(breaker.Allow(), &open)).Should(BeTrue())
+		Expect(open.RetryAfter()).Should(BeNumerically("~", time.Hour, time.Minute))
+	})
+
+	It("only counts consecutive outages", func() {
+		breaker := main.NewCircuitBreaker(2, time.Hour)
+		fail(breaker, 1, outage)
+		fail(breaker, 1, nil)
+		fail(breaker, 1, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerClosed))
+	})
+
+	It("ignores errors caused by the request", func() {
+		breaker := main.NewCircuitBreaker(1, time.Hour)
+		fail(breaker, 1, &main.UpstreamError{StatusCode: http.StatusBadRequest})
+		fail(breaker, 1, &main.AuthError{StatusCode: http.StatusUnauthorized})
+		fail(breaker, 1, context.Canceled)
+		Expect(breaker.State()).Should(Equal(main.BreakerClosed))
+
+		fail(breaker, 1, context.DeadlineExceeded)
+		Expect(breaker.State()).Should(Equal(main.BreakerOpen))
+	})
+
+	It("lets one probe through when half-open and closes when it succeeds", func() {
+		breaker := main.NewCircuitBreaker(1, 20*time.Millisecond)
+		fail(breaker, 1, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerOpen))
+		Eventually(breaker.State).Should(Equal(main.BreakerHalfOpen))
+
+		Expect(breaker.Allow()).Should(Succeed())
+		Expect(breaker.Allow()).Should(MatchError(&main.CircuitOpenError{}))
+		breaker.Done(nil)
+
+		Expect(breaker.State()).Should(Equal(main.BreakerClosed))
+		Expect(breaker.Allow()).Should(Succeed())
+	})
+
+	It("reopens when the probe fails", func() {
+		breaker := main.NewCircuitBreaker(1, 20*time.Millisecond)
+		fail(breaker, 1, outage)
+		Eventually(breaker.State).Should(Equal(main.BreakerHalfOpen))
+
+		fail(breaker, 1, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerOpen))
+	})
+
+	It("allows everything when nil", func() {
+		var breaker *main.CircuitBreaker
+		fail(breaker, 5, outage)
+		Expect(breaker.State()).Should(Equal(main.BreakerClosed))
+	})
+
+	It("answers 503 without calling the model while open and reports its state", func() {
+		model := &fakeModel{err: outage}
+		server := &main.Server{
+			Model:   model,
+			Breaker: main.NewCircuitBreaker(2, 50*time.Millisecond),
+			Metrics: main.NewMetrics(prometheus.NewRegistry()),
+		}
+		router := server.Router()
+
+		post := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`)))
+			return rec
+		}
+		scrape := func() string {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			return rec.Body.String()
+		}
+
+		Expect(post().Code).Should(Equal(http.StatusBadGateway))
+		Expect(post().Code).Should(Equal(http.StatusBadGateway))
+		Expect(scrape()).Should(ContainSubstring("circuit_breaker_state 2"))
+
+		rec := post()
+		Expect(rec.Code).Should(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("Retry-After")).Should(Equal("1"))
+		Expect(model.received).Should(HaveLen(2))
+		Expect(scrape()).Should(ContainSubstring("circuit_breaker_rejections_total 1"))
+
+		Eventually(scrape).Should(ContainSubstring("circuit_breaker_state 1"))
+		model.err = nil
+		Expect(post().Code).Should(Equal(http.StatusOK))
+		Expect(scrape()).Should(ContainSubstring("circuit_breaker_state 0"))
+	})
+})
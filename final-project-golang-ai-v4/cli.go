@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"a21hc3NpZ25tZW50/tableqa"
+)
+
+// RunQuery implements the "query" subcommand: it reads the CSV named by -csv
+// ("-" for stdin), asks -query about it and prints the model's Response as
+// JSON to stdout. It returns the process exit code.
+func RunQuery(args []string, connector *AIModelConnector, token string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	csvPath := flags.String("csv", "data-series.csv", `CSV file to query, optionally gzipped, or "-" to read stdin`)
+	query := flags.String("query", "", "question to ask about the table")
+	model := flags.String("model", "", "Hugging Face model ID (default "+DefaultModel+")")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *query == "" {
+		fmt.Fprintln(stderr, "query: -query is required")
+		flags.Usage()
+		return 2
+	}
+
+	var (
+		rowData []byte
+		err     error
+	)
+	if *csvPath == "-" {
+		rowData, err = ioutil.ReadAll(stdin)
+	} else {
+		rowData, err = os.ReadFile(*csvPath)
+	}
+	if err == nil {
+		rowData, err = Decompress(rowData, 0)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading CSV file: %v\n", err)
+		return 1
+	}
+
+	table, headers, err := CsvToSliceOrdered(string(rowData))
+	if err != nil {
+		fmt.Fprintf(stderr, "Error converting CSV to slice: %v\n", err)
+		return 1
+	}
+
+	if *model != "" {
+		if err := ValidateModel(*model); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		c := *connector
+		c.Model = *model
+		connector = &c
+	}
+
+	response, _, err := connector.ConnectAIModelWithRetry(context.Background(), Inputs{Table: table, Query: *query, Columns: headers}, token, DefaultRetryPolicy)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error connecting to AI model: %s\n", tableqa.RedactToken(err.Error(), token))
+		return 1
+	}
+
+	if err := json.NewEncoder(stdout).Encode(response); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RunQuery", func() {
+	var (
+		received main.Inputs
+		model    *httptest.Server
+		stdout   *bytes.Buffer
+		stderr   *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		received = main.Inputs{}
+		model = newModelServer(`{"answer": "Lamp", "coordinates": [[0, 0]], "cells": ["Lamp"], "aggregator": "NONE"}`, &received)
+		DeferCleanup(model.Close)
+		stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	})
+
+	It("prints the answer for a CSV file as JSON", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+
+		code := main.RunQuery([]string{"-csv", path, "-query", "What is in the bedroom?"}, newServerConnector(model), "token", nil, stdout, stderr)
+		Expect(code).Should(Equal(0), stderr.String())
+
+		var response main.Response
+		Expect(json.Unmarshal(stdout.Bytes(), &response)).Should(Succeed())
+		Expect(response.Answer).Should(Equal("Lamp"))
+		Expect(received.Query).Should(Equal("What is in the bedroom?"))
+	})
+
+	It("reads the CSV from stdin when the path is -", func() {
+		stdin := strings.NewReader("Appliance,Room\nTV,Living Room\n")
+
+		code := main.RunQuery([]string{"-csv", "-", "-query", "Which appliance?"}, newServerConnector(model), "token", stdin, stdout, stderr)
+		Expect(code).Should(Equal(0), stderr.String())
+		Expect(received.Table).Should(Equal(map[string][]string{"Appliance": {"TV"}, "Room": {"Living Room"}}))
+	})
+
+	It("exits non-zero on errors", func() {
+		code := main.RunQuery([]string{"-csv", "-"}, newServerConnector(model), "token", strings.NewReader("a\n1\n"), stdout, stderr)
+		Expect(code).Should(Equal(2))
+
+		code = main.RunQuery([]string{"-csv", "-", "-query", "q"}, newServerConnector(model), "token", strings.NewReader("a,b\n1"), stdout, stderr)
+		Expect(code).Should(Equal(1))
+		Expect(stdout.Len()).Should(BeZero())
+	})
+})
@@ -0,0 +1,53 @@
+package main_test
+
+import (
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InferColumnTypes", func() {
+	It("classifies integer, numeric, date and text columns", func() {
+		types := main.InferColumnTypes(map[string][]string{
+			"Count":              {"1", "2", "1,000"},
+			"Energy_Consumption": {"1.2", "0.8", "2"},
+			"Date":               {"2022-01-01", "2022-01-02", "2022-01-03"},
+			"Time":               {"00:00", "01:00", "23:30"},
+			"Appliance":          {"TV", "Lamp", "Refrigerator"},
+		})
+
+		Expect(types["Count"]).Should(Equal(main.ColumnType{Kind: main.KindInteger, Confidence: 1}))
+		Expect(types["Energy_Consumption"]).Should(Equal(main.ColumnType{Kind: main.KindNumeric, Confidence: 1}))
+		Expect(types["Date"]).Should(Equal(main.ColumnType{Kind: main.KindDate, Confidence: 1}))
+		Expect(types["Time"].Kind).Should(Equal(main.KindDate))
+		Expect(types["Appliance"]).Should(Equal(main.ColumnType{Kind: main.KindText, Confidence: 1}))
+	})
+
+	It("reports the dominant type of a column with outliers", func() {
+		types := main.InferColumnTypes(map[string][]string{
+			"Reading": {"1.5", "2.5", "3", "4.5", "5", "6.5", "7", "8.5", "9", "n/a"},
+		})
+
+		Expect(types["Reading"].Kind).Should(Equal(main.KindNumeric))
+		Expect(types["Reading"].Confidence).Should(BeNumerically("~", 0.9))
+	})
+
+	It("falls back to text for evenly mixed columns", func() {
+		types := main.InferColumnTypes(map[string][]string{
+			"Mixed": {"1", "two", "3", "four"},
+		})
+
+		Expect(types["Mixed"]).Should(Equal(main.ColumnType{Kind: main.KindText, Confidence: 0.5}))
+	})
+
+	It("ignores empty cells", func() {
+		types := main.InferColumnTypes(map[string][]string{
+			"Sparse": {"", "1", " ", "2"},
+			"Empty":  {"", ""},
+		})
+
+		Expect(types["Sparse"]).Should(Equal(main.ColumnType{Kind: main.KindInteger, Confidence: 1}))
+		Expect(types["Empty"]).Should(Equal(main.ColumnType{Kind: main.KindText}))
+	})
+})
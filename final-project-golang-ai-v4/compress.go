@@ -0,0 +1,8 @@
+package main
+
+// gzipContentTypes are the upload content types of gzip-compressed files,
+// which are decompressed and read as CSV.
+var gzipContentTypes = map[string]bool{
+	"application/gzip":   true,
+	"application/x-gzip": true,
+}
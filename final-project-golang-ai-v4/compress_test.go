@@ -0,0 +1,92 @@
+package main_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func gzipBytes(data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	Expect(gz.Close()).Should(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("gzipped CSV", func() {
+	const csv = "Appliance,Energy_Consumption\nFridge,1.2\nTV,0.8\n"
+
+	It("decompresses gzip data and passes plain data through", func() {
+		Expect(main.Decompress(gzipBytes(csv), 0)).Should(Equal([]byte(csv)))
+		Expect(main.Decompress([]byte(csv), 0)).Should(Equal([]byte(csv)))
+	})
+
+	It("bounds the decompressed size", func() {
+		_, err := main.Decompress(gzipBytes(strings.Repeat("x", 1000)), 100)
+		Expect(errors.Is(err, main.ErrDecompressedTooLarge)).Should(BeTrue())
+
+		_, err = main.Decompress(append([]byte{0x1f, 0x8b}, "garbage"...), 0)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("loads a .csv.gz data file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv.gz")
+		Expect(os.WriteFile(path, gzipBytes(csv), 0o600)).Should(Succeed())
+
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		table, headers, err := data.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers).Should(Equal([]string{"Appliance", "Energy_Consumption"}))
+		Expect(table["Energy_Consumption"]).Should(Equal([]string{"1.2", "0.8"}))
+	})
+
+	It("loads .csv.gz files into the table registry by name", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "energy.csv.gz"), gzipBytes(csv), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "rooms.csv"), []byte("Room\nKitchen\n"), 0o600)).Should(Succeed())
+
+		tables, err := main.LoadTableDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tables.Names()).Should(Equal([]string{"energy", "rooms"}))
+
+		Expect(os.WriteFile(filepath.Join(dir, "energy.csv"), []byte(csv), 0o600)).Should(Succeed())
+		_, err = main.LoadTableDir(dir)
+		Expect(err).Should(MatchError(ContainSubstring(`table "energy" is already loaded`)))
+	})
+
+	It("answers uploads of gzipped CSV", func() {
+		model := &fakeModel{response: main.Response{Answer: "TV"}}
+		server := &main.Server{Model: model}
+
+		for _, contentType := range []string{"application/gzip", "text/csv"} {
+			req := newMultipartRequest("/ask-upload", contentType, string(gzipBytes(csv)), map[string]string{"query": "Which appliance?"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK), contentType)
+		}
+		Expect(model.received[0].Table["Appliance"]).Should(Equal([]string{"Fridge", "TV"}))
+	})
+
+	It("rejects uploads that decompress past the upload limit", func() {
+		server := &main.Server{Model: &fakeModel{}, MaxUploadSize: 4096}
+		big := "a\n" + strings.Repeat("1\n", 10000)
+		req := newMultipartRequest("/ask-upload", "application/gzip", string(gzipBytes(big)), map[string]string{"query": "q"})
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+
+		Expect(rec.Code).Should(Equal(http.StatusRequestEntityTooLarge))
+	})
+})
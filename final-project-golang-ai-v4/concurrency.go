@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUpstreamQueueTimeout is how long a model call waits for a free slot
+// when UPSTREAM_QUEUE_TIMEOUT is unset.
+const DefaultUpstreamQueueTimeout = 10 * time.Second
+
+// UpstreamBusyError is returned instead of calling the model when every
+// slot of the ConcurrencyLimiter stayed taken.
+type UpstreamBusyError struct {
+	retryAfter time.Duration
+}
+
+func (e *UpstreamBusyError) Error() string {
+	return "too many model calls in flight, try again shortly"
+}
+
+// RetryAfter suggests when to try again. It is jittered, so clients turned
+// away together do not all come back at the same moment.
+func (e *UpstreamBusyError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// ConcurrencyLimiter bounds the model calls in flight at once, so a traffic
+// spike does not run into the inference API's account-wide rate limits. A
+// call over the limit waits up to queueTimeout for a slot, or not at all
+// when queueTimeout is zero, and then fails with *UpstreamBusyError. A call
+// holds its slot through its retries. It is safe for concurrent use, and a
+// nil *ConcurrencyLimiter allows everything.
+type ConcurrencyLimiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+	waiting      atomic.Int64
+}
+
+// NewConcurrencyLimiter allows max calls at once, queueing others for up to
+// queueTimeout.
+func NewConcurrencyLimiter(max int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{slots: make(chan struct{}, max), queueTimeout: queueTimeout}
+}
+
+// Acquire takes a slot, waiting for one as configured or until ctx is done.
+// When it returns nil, the caller must give the slot back with Release.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		return l.busy()
+	}
+
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return l.busy()
+	}
+}
+
+// Release gives back a slot taken by Acquire.
+func (l *ConcurrencyLimiter) Release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// InFlight returns the number of calls holding a slot.
+func (l *ConcurrencyLimiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
+
+// Waiting returns the number of calls queued for a slot.
+func (l *ConcurrencyLimiter) Waiting() int {
+	if l == nil {
+		return 0
+	}
+	return int(l.waiting.Load())
+}
+
+// busy suggests retrying after one to two queue timeouts, and at least a
+// second.
+func (l *ConcurrencyLimiter) busy() *UpstreamBusyError {
+	base := l.queueTimeout
+	if base < time.Second {
+		base = time.Second
+	}
+	return &UpstreamBusyError{retryAfter: base + time.Duration(rand.Int63n(int64(base)+1))}
+}
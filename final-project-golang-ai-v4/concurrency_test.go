@@ -0,0 +1,173 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("ConcurrencyLimiter", func() {
+	It("turns calls away once its slots are taken", func() {
+		limiter := main.NewConcurrencyLimiter(2, 0)
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+		Expect(limiter.InFlight()).Should(Equal(2))
+
+		err := limiter.Acquire(context.Background())
+		var busy *main.UpstreamBusyError
+		Expect(err).Should(BeAssignableToTypeOf(busy))
+		Expect(err.(*main.UpstreamBusyError).RetryAfter()).Should(BeNumerically("~", 1500*time.Millisecond, 500*time.Millisecond))
+
+		limiter.Release()
+		Expect(limiter.InFlight()).Should(Equal(1))
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+	})
+
+	It("queues calls until a slot frees up or the wait times out", func() {
+		limiter := main.NewConcurrencyLimiter(1, time.Second)
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+
+		acquired := make(chan error)
+		go func() { acquired <- limiter.Acquire(context.Background()) }()
+		Eventually(limiter.Waiting).Should(Equal(1))
+		limiter.Release()
+		Eventually(acquired).Should(Receive(BeNil()))
+		Expect(limiter.Waiting()).Should(BeZero())
+
+		limiter = main.NewConcurrencyLimiter(1, 20*time.Millisecond)
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+		var busy *main.UpstreamBusyError
+		Expect(limiter.Acquire(context.Background())).Should(BeAssignableToTypeOf(busy))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(main.NewConcurrencyLimiter(1, time.Hour).Acquire(ctx)).Should(Succeed())
+		Expect(limiter.Acquire(ctx)).Should(MatchError(context.Canceled))
+	})
+
+	It("allows everything when nil", func() {
+		var limiter *main.ConcurrencyLimiter
+		Expect(limiter.Acquire(context.Background())).Should(Succeed())
+		limiter.Release()
+		Expect(limiter.InFlight()).Should(BeZero())
+	})
+
+	Context("in the server", func() {
+		var (
+			release  chan struct{}
+			inFlight atomic.Int32
+			maxSeen  atomic.Int32
+			model    *httptest.Server
+		)
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			inFlight.Store(0)
+			maxSeen.Store(0)
+			model = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					seen := maxSeen.Load()
+					if n <= seen || maxSeen.CompareAndSwap(seen, n) {
+						break
+					}
+				}
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				w.Write([]byte(`{"answer": "TV"}`))
+			}))
+		})
+
+		AfterEach(func() {
+			model.Close()
+		})
+
+		ask := func(server *main.Server, query string) *httptest.ResponseRecorder {
+			body := `{"table": {"Appliance": ["TV"]}, "query": "` + query + `"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			return rec
+		}
+
+		It("never has more calls in flight than the limit", func() {
+			server := &main.Server{
+				Connector:   newServerConnector(model),
+				Token:       "token",
+				Concurrency: main.NewConcurrencyLimiter(3, time.Minute),
+				Metrics:     main.NewMetrics(prometheus.NewRegistry()),
+			}
+
+			var wg sync.WaitGroup
+			codes := make([]int, 12)
+			for i := range codes {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					codes[i] = ask(server, "Which appliance "+strconv.Itoa(i)+"?").Code
+				}(i)
+			}
+
+			Eventually(func() int32 { return inFlight.Load() }).Should(Equal(int32(3)))
+			Eventually(server.Concurrency.Waiting).Should(Equal(9))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			Expect(rec.Body.String()).Should(ContainSubstring("upstream_requests_in_flight 3"))
+			Expect(rec.Body.String()).Should(ContainSubstring("upstream_requests_queued 9"))
+
+			for range codes {
+				release <- struct{}{}
+			}
+			wg.Wait()
+
+			Expect(maxSeen.Load()).Should(Equal(int32(3)))
+			for _, code := range codes {
+				Expect(code).Should(Equal(http.StatusOK))
+			}
+			Expect(server.Concurrency.InFlight()).Should(BeZero())
+		})
+
+		It("answers 503 to calls over the limit when it does not queue", func() {
+			server := &main.Server{
+				Connector:   newServerConnector(model),
+				Token:       "token",
+				Concurrency: main.NewConcurrencyLimiter(1, 0),
+				Metrics:     main.NewMetrics(prometheus.NewRegistry()),
+			}
+
+			done := make(chan int)
+			go func() { done <- ask(server, "Which appliance?").Code }()
+			Eventually(func() int32 { return inFlight.Load() }).Should(Equal(int32(1)))
+
+			rec := ask(server, "Which room?")
+			Expect(rec.Code).Should(Equal(http.StatusServiceUnavailable))
+			Expect(rec.Header().Get("Retry-After")).Should(BeElementOf("1", "2"))
+			var body main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+			Expect(body.Error.Code).Should(Equal(main.CodeUpstreamBusy))
+
+			release <- struct{}{}
+			Eventually(done).Should(Receive(Equal(http.StatusOK)))
+
+			rec = httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			Expect(rec.Body.String()).Should(ContainSubstring("upstream_concurrency_rejections_total 1"))
+			Expect(rec.Body.String()).Should(ContainSubstring("upstream_requests_in_flight 0"))
+		})
+	})
+})
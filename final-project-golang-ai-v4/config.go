@@ -0,0 +1,559 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything the server reads from the environment. LoadConfig
+// fills it in once at startup, applying defaults and validating every value,
+// so the rest of the program never calls os.Getenv.
+type Config struct {
+	// Token is HUGGINGFACE_TOKEN. Without it, and unless Mock is set, the
+	// server starts but /readyz reports it and queries answer 503.
+	Token string
+	// Mock is MOCK_AI=true: queries are answered by MockModel.
+	Mock bool
+	// Model is HF_MODEL; empty means DefaultModel.
+	Model string
+	// Fallbacks is HF_FALLBACK_MODELS, a comma-separated list of models
+	// tried in order when Model is unavailable.
+	Fallbacks []string
+	// Task is the kind of model named by HF_TASK, a Hugging Face pipeline
+	// tag such as "text-generation"; unset means TableQATask.
+	Task ModelTask
+	// APIBase is HF_API_BASE; empty means DefaultAPIBase.
+	APIBase string
+	// Timeout is AI_REQUEST_TIMEOUT, DefaultRequestTimeout when unset.
+	Timeout time.Duration
+	// DisableCache is HF_USE_CACHE=false.
+	DisableCache bool
+	// ForwardRequestID is FORWARD_REQUEST_ID=true.
+	ForwardRequestID bool
+	// MaxResponseSize is MAX_RESPONSE_BYTES; zero means the default.
+	MaxResponseSize int64
+
+	// ListenAddr is built from BIND_ADDR and PORT (8080 when unset).
+	ListenAddr string
+	// LogLevel is LOG_LEVEL.
+	LogLevel string
+	// DataPath and IndexPath are the absolute forms of DATA_CSV_PATH and
+	// INDEX_HTML_PATH, which default to data-series.csv and index.html.
+	DataPath  string
+	IndexPath string
+	// TablesDir is the absolute form of TABLES_DIR, or empty when unset.
+	TablesDir string
+	// DataRefreshInterval is DATA_REFRESH_INTERVAL, how often the data file
+	// is checked for changes in the background; when unset, it is checked on
+	// each request instead.
+	DataRefreshInterval time.Duration
+	// DataDir is the absolute form of DATA_DIR, the directory whose CSV
+	// files /ask queries by "file", or empty when unset.
+	DataDir string
+
+	// RateLimitRPS and RateLimitBurst are RATE_LIMIT_RPS and
+	// RATE_LIMIT_BURST. A zero rate disables rate limiting; the burst
+	// defaults to the rate rounded up.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// BreakerThreshold is CIRCUIT_BREAKER_THRESHOLD, the consecutive
+	// upstream outages that open the circuit breaker; zero disables it.
+	// BreakerCooldown is CIRCUIT_BREAKER_COOLDOWN, DefaultBreakerCooldown
+	// when unset.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// MaxUpstreamConcurrency is UPSTREAM_MAX_CONCURRENCY, the most model
+	// calls in flight at once; zero leaves them unbounded.
+	// UpstreamQueueTimeout is UPSTREAM_QUEUE_TIMEOUT, how long a call over
+	// the limit waits for a slot before failing with 503, where "0" fails it
+	// at once; it is DefaultUpstreamQueueTimeout when unset.
+	MaxUpstreamConcurrency int
+	UpstreamQueueTimeout   time.Duration
+	// AnswerCacheSize is ANSWER_CACHE_SIZE, the number of answers kept in
+	// memory; zero disables the answer cache. AnswerCacheTTL is
+	// ANSWER_CACHE_TTL, DefaultAnswerCacheTTL when unset.
+	AnswerCacheSize int
+	AnswerCacheTTL  time.Duration
+	// SessionTables is SESSION_TABLES, the number of tables uploaded with
+	// POST /tables kept in memory; zero disables uploading tables.
+	// SessionTableTTL is SESSION_TABLE_TTL, DefaultSessionTableTTL when
+	// unset.
+	SessionTables   int
+	SessionTableTTL time.Duration
+	// CORSOrigins, CORSMethods and CORSHeaders are the comma-separated
+	// CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS and CORS_ALLOWED_HEADERS.
+	// Without origins only same-origin requests are possible; methods and
+	// headers default to DefaultCORSMethods and DefaultCORSHeaders.
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+
+	// APIKeys is API_KEYS, the comma-separated keys that Server.APIKeys
+	// accepts; without keys every endpoint is open. Browsers on other origins
+	// also need Authorization or X-API-Key in CORS_ALLOWED_HEADERS.
+	APIKeys []string
+	// TrustedProxies is TRUSTED_PROXIES, the comma-separated addresses and
+	// CIDR ranges of the reverse proxies in front of the server, trusted to
+	// report the client's address; without any, none are.
+	TrustedProxies []string
+
+	// CSVURLHosts and CSVURLSchemes are the comma-separated
+	// CSV_URL_ALLOWED_HOSTS and CSV_URL_ALLOWED_SCHEMES that a "csv_url" may
+	// use; without hosts, /ask does not fetch URLs, and the schemes default
+	// to https. CSVURLTimeout is CSV_URL_TIMEOUT, DefaultCSVURLTimeout when
+	// unset.
+	CSVURLHosts   []string
+	CSVURLSchemes []string
+	CSVURLTimeout time.Duration
+
+	// Warmup is WARMUP=true: on startup a dummy query loads the model in
+	// the background.
+	Warmup bool
+	// Debug is DEBUG_RESPONSES=true, which allows ?debug=true.
+	Debug bool
+	// RedactQueries is LOG_REDACT_QUERIES=true.
+	RedactQueries bool
+	// DryRun is DRY_RUN=true.
+	DryRun bool
+	// Normalize is set by NORMALIZE_CELLS=true, with EMPTY_CELL_PLACEHOLDER.
+	Normalize *NormalizeOptions
+	// Round is set by ROUND_NUMERIC_CELLS, the number of digits kept after
+	// the decimal point in numeric columns.
+	Round *RoundOptions
+	// IndexColumn is INDEX_COLUMN.
+	IndexColumn string
+	// TableLimits holds MAX_TABLE_ROWS, MAX_TABLE_COLUMNS and
+	// MAX_TABLE_CELLS, each the matching field of DefaultTableLimits when
+	// unset; "0" disables that check.
+	TableLimits TableLimits
+	// MaxBodySize, MaxUploadSize and MaxQueryLength are MAX_BODY_BYTES,
+	// MAX_UPLOAD_BYTES and MAX_QUERY_LENGTH; zero means the default.
+	MaxBodySize    int64
+	MaxUploadSize  int64
+	MaxQueryLength int
+	// RequestDeadline is REQUEST_DEADLINE; zero means none.
+	RequestDeadline time.Duration
+	// ShutdownGracePeriod is SHUTDOWN_GRACE_PERIOD, DefaultShutdownGracePeriod
+	// when unset.
+	ShutdownGracePeriod time.Duration
+}
+
+// LoadConfig reads the configuration with getenv, usually os.Getenv. It
+// returns an error naming the first variable that is invalid. Files are not
+// opened; see CheckFiles.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	var (
+		cfg Config
+		err error
+	)
+
+	cfg.Mock = getenv("MOCK_AI") == "true"
+	cfg.Token = getenv("HUGGINGFACE_TOKEN")
+	if cfg.Model = getenv("HF_MODEL"); cfg.Model != "" {
+		if err := ValidateModel(cfg.Model); err != nil {
+			return Config{}, fmt.Errorf("invalid HF_MODEL: %v", err)
+		}
+	}
+	for _, model := range listFromEnv(getenv, "HF_FALLBACK_MODELS") {
+		if err := ValidateModel(model); err != nil {
+			return Config{}, fmt.Errorf("invalid HF_FALLBACK_MODELS: %v", err)
+		}
+		cfg.Fallbacks = append(cfg.Fallbacks, model)
+	}
+	if cfg.Task, err = TaskByName(getenv("HF_TASK")); err != nil {
+		return Config{}, fmt.Errorf("invalid HF_TASK: %v", err)
+	}
+	if cfg.APIBase = getenv("HF_API_BASE"); cfg.APIBase != "" {
+		if err := ValidateAPIBase(cfg.APIBase); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.Timeout, err = durationFromEnv(getenv, "AI_REQUEST_TIMEOUT", DefaultRequestTimeout); err != nil {
+		return Config{}, err
+	}
+	cfg.DisableCache = getenv("HF_USE_CACHE") == "false"
+	cfg.ForwardRequestID = getenv("FORWARD_REQUEST_ID") == "true"
+	if cfg.MaxResponseSize, err = byteSizeFromEnv(getenv, "MAX_RESPONSE_BYTES"); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.ListenAddr, err = listenAddr(getenv); err != nil {
+		return Config{}, err
+	}
+	cfg.LogLevel = getenv("LOG_LEVEL")
+	if _, err := NewLogger(io.Discard, cfg.LogLevel); err != nil {
+		return Config{}, err
+	}
+	if cfg.DataPath, err = absPath(getenv, "DATA_CSV_PATH", "data-series.csv"); err != nil {
+		return Config{}, err
+	}
+	if cfg.IndexPath, err = absPath(getenv, "INDEX_HTML_PATH", "index.html"); err != nil {
+		return Config{}, err
+	}
+	if cfg.DataRefreshInterval, err = durationFromEnv(getenv, "DATA_REFRESH_INTERVAL", 0); err != nil {
+		return Config{}, err
+	}
+	if getenv("TABLES_DIR") != "" {
+		if cfg.TablesDir, err = absPath(getenv, "TABLES_DIR", ""); err != nil {
+			return Config{}, err
+		}
+	}
+	if getenv("DATA_DIR") != "" {
+		if cfg.DataDir, err = absPath(getenv, "DATA_DIR", ""); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if cfg.RateLimitRPS, cfg.RateLimitBurst, err = rateLimitFromEnv(getenv); err != nil {
+		return Config{}, err
+	}
+
+	if value := getenv("CIRCUIT_BREAKER_THRESHOLD"); value != "" {
+		if cfg.BreakerThreshold, err = strconv.Atoi(value); err != nil || cfg.BreakerThreshold < 0 {
+			return Config{}, fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD %q", value)
+		}
+	}
+	if cfg.BreakerCooldown, err = durationFromEnv(getenv, "CIRCUIT_BREAKER_COOLDOWN", DefaultBreakerCooldown); err != nil {
+		return Config{}, err
+	}
+
+	if value := getenv("UPSTREAM_MAX_CONCURRENCY"); value != "" {
+		if cfg.MaxUpstreamConcurrency, err = strconv.Atoi(value); err != nil || cfg.MaxUpstreamConcurrency < 0 {
+			return Config{}, fmt.Errorf("invalid UPSTREAM_MAX_CONCURRENCY %q", value)
+		}
+	}
+	cfg.UpstreamQueueTimeout = DefaultUpstreamQueueTimeout
+	if value := getenv("UPSTREAM_QUEUE_TIMEOUT"); value != "" {
+		if cfg.UpstreamQueueTimeout, err = time.ParseDuration(value); err != nil || cfg.UpstreamQueueTimeout < 0 {
+			return Config{}, fmt.Errorf("invalid UPSTREAM_QUEUE_TIMEOUT %q", value)
+		}
+	}
+
+	if value := getenv("ANSWER_CACHE_SIZE"); value != "" {
+		if cfg.AnswerCacheSize, err = strconv.Atoi(value); err != nil || cfg.AnswerCacheSize < 0 {
+			return Config{}, fmt.Errorf("invalid ANSWER_CACHE_SIZE %q", value)
+		}
+	}
+	if cfg.AnswerCacheTTL, err = durationFromEnv(getenv, "ANSWER_CACHE_TTL", DefaultAnswerCacheTTL); err != nil {
+		return Config{}, err
+	}
+
+	if value := getenv("SESSION_TABLES"); value != "" {
+		if cfg.SessionTables, err = strconv.Atoi(value); err != nil || cfg.SessionTables < 0 {
+			return Config{}, fmt.Errorf("invalid SESSION_TABLES %q", value)
+		}
+	}
+	if cfg.SessionTableTTL, err = durationFromEnv(getenv, "SESSION_TABLE_TTL", DefaultSessionTableTTL); err != nil {
+		return Config{}, err
+	}
+
+	for _, origin := range listFromEnv(getenv, "CORS_ALLOWED_ORIGINS") {
+		if err := ValidateOrigin(origin); err != nil {
+			return Config{}, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS: %v", err)
+		}
+		cfg.CORSOrigins = append(cfg.CORSOrigins, origin)
+	}
+	cfg.CORSMethods = DefaultCORSMethods
+	if methods := listFromEnv(getenv, "CORS_ALLOWED_METHODS"); methods != nil {
+		for i, method := range methods {
+			methods[i] = strings.ToUpper(method)
+		}
+		cfg.CORSMethods = methods
+	}
+	if cfg.CORSHeaders = listFromEnv(getenv, "CORS_ALLOWED_HEADERS"); cfg.CORSHeaders == nil {
+		cfg.CORSHeaders = DefaultCORSHeaders
+	}
+	cfg.APIKeys = listFromEnv(getenv, "API_KEYS")
+	cfg.TrustedProxies = listFromEnv(getenv, "TRUSTED_PROXIES")
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return Config{}, fmt.Errorf("invalid TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	cfg.CSVURLHosts = listFromEnv(getenv, "CSV_URL_ALLOWED_HOSTS")
+	cfg.CSVURLSchemes = listFromEnv(getenv, "CSV_URL_ALLOWED_SCHEMES")
+	for _, scheme := range cfg.CSVURLSchemes {
+		if !strings.EqualFold(scheme, "http") && !strings.EqualFold(scheme, "https") {
+			return Config{}, fmt.Errorf("invalid CSV_URL_ALLOWED_SCHEMES: unsupported scheme %q", scheme)
+		}
+	}
+	if cfg.CSVURLTimeout, err = durationFromEnv(getenv, "CSV_URL_TIMEOUT", DefaultCSVURLTimeout); err != nil {
+		return Config{}, err
+	}
+
+	cfg.RedactQueries = getenv("LOG_REDACT_QUERIES") == "true"
+	cfg.DryRun = getenv("DRY_RUN") == "true"
+	cfg.Debug = getenv("DEBUG_RESPONSES") == "true"
+	cfg.Warmup = getenv("WARMUP") == "true"
+	if getenv("NORMALIZE_CELLS") == "true" {
+		cfg.Normalize = &NormalizeOptions{EmptyPlaceholder: getenv("EMPTY_CELL_PLACEHOLDER")}
+	}
+	if value := getenv("ROUND_NUMERIC_CELLS"); value != "" {
+		precision, err := strconv.Atoi(value)
+		if err != nil || precision < 0 {
+			return Config{}, fmt.Errorf("invalid ROUND_NUMERIC_CELLS %q: must be a number of digits", value)
+		}
+		cfg.Round = &RoundOptions{Precision: precision}
+	}
+	cfg.IndexColumn = getenv("INDEX_COLUMN")
+	cfg.TableLimits = DefaultTableLimits
+	for _, limit := range []struct {
+		name  string
+		value *int
+	}{
+		{"MAX_TABLE_ROWS", &cfg.TableLimits.MaxRows},
+		{"MAX_TABLE_COLUMNS", &cfg.TableLimits.MaxColumns},
+		{"MAX_TABLE_CELLS", &cfg.TableLimits.MaxCells},
+	} {
+		if value := getenv(limit.name); value != "" {
+			if *limit.value, err = strconv.Atoi(value); err != nil || *limit.value < 0 {
+				return Config{}, fmt.Errorf("invalid %s %q", limit.name, value)
+			}
+		}
+	}
+	if cfg.MaxBodySize, err = byteSizeFromEnv(getenv, "MAX_BODY_BYTES"); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxUploadSize, err = byteSizeFromEnv(getenv, "MAX_UPLOAD_BYTES"); err != nil {
+		return Config{}, err
+	}
+	if value := getenv("MAX_QUERY_LENGTH"); value != "" {
+		if cfg.MaxQueryLength, err = strconv.Atoi(value); err != nil || cfg.MaxQueryLength < 1 {
+			return Config{}, fmt.Errorf("invalid MAX_QUERY_LENGTH %q", value)
+		}
+	}
+	if cfg.RequestDeadline, err = durationFromEnv(getenv, "REQUEST_DEADLINE", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.ShutdownGracePeriod, err = durationFromEnv(getenv, "SHUTDOWN_GRACE_PERIOD", DefaultShutdownGracePeriod); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// CheckFiles reports an error if the data file, the HTML page, the tables
+// directory or the data directory is missing.
+func (c Config) CheckFiles() error {
+	for _, file := range []struct{ name, path string }{
+		{"DATA_CSV_PATH", c.DataPath},
+		{"INDEX_HTML_PATH", c.IndexPath},
+		{"TABLES_DIR", c.TablesDir},
+		{"DATA_DIR", c.DataDir},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("invalid %s: %v", file.name, err)
+		}
+	}
+	return nil
+}
+
+// Connector returns a connector for the configured model and API.
+func (c Config) Connector() *AIModelConnector {
+	connector := NewAIModelConnector(c.Timeout)
+	connector.Model = c.Model
+	connector.Fallbacks = c.Fallbacks
+	connector.BaseURL = c.APIBase
+	connector.DisableCache = c.DisableCache
+	connector.ForwardRequestID = c.ForwardRequestID
+	connector.MaxResponseSize = c.MaxResponseSize
+	connector.Task = c.Task
+	return connector
+}
+
+// Server returns a server for the configuration answering queries about
+// data and tables, which may be nil. Its Logger and Metrics are left unset.
+func (c Config) Server(data *TableCache, tables *TableRegistry) *Server {
+	limits := c.TableLimits
+	server := &Server{
+		Connector: c.Connector(),
+		Token:     c.Token,
+		Data:      data,
+		Tables:    tables,
+		Files:     c.DataFiles(),
+		IndexPath: c.IndexPath,
+
+		RateLimiter:     c.RateLimiter(),
+		Breaker:         c.CircuitBreaker(),
+		Concurrency:     c.ConcurrencyLimiter(),
+		Answers:         c.AnswerCache(),
+		Sessions:        c.Sessions(),
+		CORS:            c.CORS(),
+		APIKeys:         c.Keys(),
+		TrustedProxies:  c.TrustedProxies,
+		CSVFetcher:      c.CSVFetcher(),
+		RedactQueries:   c.RedactQueries,
+		Normalize:       c.Normalize,
+		Round:           c.Round,
+		DryRun:          c.DryRun,
+		Debug:           c.Debug,
+		IndexColumn:     c.IndexColumn,
+		MaxBodySize:     c.MaxBodySize,
+		MaxUploadSize:   c.MaxUploadSize,
+		TableLimits:     &limits,
+		MaxQueryLength:  c.MaxQueryLength,
+		RequestDeadline: c.RequestDeadline,
+	}
+	if c.Mock {
+		server.Model = MockModel{}
+	}
+	return server
+}
+
+// DataFiles returns the configured data directory, or nil when clients cannot
+// pick a "file".
+func (c Config) DataFiles() *DataDir {
+	if c.DataDir == "" {
+		return nil
+	}
+	return NewDataDir(c.DataDir)
+}
+
+// RateLimiter returns the configured limiter, or nil when rate limiting is
+// disabled.
+func (c Config) RateLimiter() *RateLimiter {
+	if c.RateLimitRPS == 0 {
+		return nil
+	}
+	return NewRateLimiter(c.RateLimitRPS, c.RateLimitBurst)
+}
+
+// CircuitBreaker returns the configured breaker, or nil when it is disabled.
+func (c Config) CircuitBreaker() *CircuitBreaker {
+	if c.BreakerThreshold == 0 {
+		return nil
+	}
+	return NewCircuitBreaker(c.BreakerThreshold, c.BreakerCooldown)
+}
+
+// ConcurrencyLimiter returns the configured limit on model calls in flight,
+// or nil when they are unbounded.
+func (c Config) ConcurrencyLimiter() *ConcurrencyLimiter {
+	if c.MaxUpstreamConcurrency == 0 {
+		return nil
+	}
+	return NewConcurrencyLimiter(c.MaxUpstreamConcurrency, c.UpstreamQueueTimeout)
+}
+
+// AnswerCache returns the configured answer cache, or nil when it is
+// disabled.
+func (c Config) AnswerCache() *AnswerCache {
+	if c.AnswerCacheSize == 0 {
+		return nil
+	}
+	return NewAnswerCache(c.AnswerCacheSize, c.AnswerCacheTTL)
+}
+
+// Sessions returns the configured store of uploaded tables, or nil when
+// uploading tables is disabled.
+func (c Config) Sessions() *SessionTables {
+	if c.SessionTables == 0 {
+		return nil
+	}
+	return NewSessionTables(c.SessionTables, c.SessionTableTTL)
+}
+
+// CORS returns the configured CORS policy, or nil when no origins are
+// allowed.
+func (c Config) CORS() *CORS {
+	if len(c.CORSOrigins) == 0 {
+		return nil
+	}
+	return &CORS{Origins: c.CORSOrigins, Methods: c.CORSMethods, Headers: c.CORSHeaders}
+}
+
+// Keys returns the configured API keys, or nil when the endpoints are open.
+func (c Config) Keys() *APIKeys {
+	if len(c.APIKeys) == 0 {
+		return nil
+	}
+	return NewAPIKeys(c.APIKeys)
+}
+
+// CSVFetcher returns the configured fetcher for "csv_url", or nil when no
+// hosts are allowed.
+func (c Config) CSVFetcher() *CSVFetcher {
+	if len(c.CSVURLHosts) == 0 {
+		return nil
+	}
+	return &CSVFetcher{Hosts: c.CSVURLHosts, Schemes: c.CSVURLSchemes, Timeout: c.CSVURLTimeout}
+}
+
+// listFromEnv splits the environment variable name at commas, dropping
+// surrounding spaces and empty items. It returns nil when there are none.
+func listFromEnv(getenv func(string) string, name string) []string {
+	var items []string
+	for _, item := range strings.Split(getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// durationFromEnv reads the environment variable name as a positive Go
+// duration, returning fallback when it is unset.
+func durationFromEnv(getenv func(string) string, name string, fallback time.Duration) (time.Duration, error) {
+	value := getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be positive", name, value)
+	}
+	return d, nil
+}
+
+// byteSizeFromEnv reads the environment variable name as a positive number
+// of bytes, returning zero, which selects the default, when it is unset.
+func byteSizeFromEnv(getenv func(string) string, name string) (int64, error) {
+	value := getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return size, nil
+}
+
+// listenAddr builds the address to listen on from BIND_ADDR and PORT.
+func listenAddr(getenv func(string) string) (string, error) {
+	port := getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid PORT %q: must be a number between 1 and 65535", port)
+	}
+	return net.JoinHostPort(getenv("BIND_ADDR"), port), nil
+}
+
+// absPath returns the absolute form of the path in the environment variable
+// name, or of fallback when it is unset.
+func absPath(getenv func(string) string, name, fallback string) (string, error) {
+	path := getenv(name)
+	if path == "" {
+		path = fallback
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %v", name, path, err)
+	}
+	return abs, nil
+}
@@ -0,0 +1,250 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// env returns a getenv that reads only from vars.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+var _ = Describe("LoadConfig", func() {
+	It("applies defaults when only the token is set", func() {
+		cfg, err := main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test"}))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		wd, err := os.Getwd()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.Token).Should(Equal("hf_test"))
+		Expect(cfg.Mock).Should(BeFalse())
+		Expect(cfg.Model).Should(BeEmpty())
+		Expect(cfg.Timeout).Should(Equal(main.DefaultRequestTimeout))
+		Expect(cfg.Task).Should(Equal(main.TableQATask))
+		Expect(cfg.ListenAddr).Should(Equal(":8080"))
+		Expect(cfg.DataPath).Should(Equal(filepath.Join(wd, "data-series.csv")))
+		Expect(cfg.IndexPath).Should(Equal(filepath.Join(wd, "index.html")))
+		Expect(cfg.TablesDir).Should(BeEmpty())
+		Expect(cfg.DataFiles()).Should(BeNil())
+		Expect(cfg.RateLimiter()).Should(BeNil())
+		Expect(cfg.TableLimits).Should(Equal(main.DefaultTableLimits))
+		Expect(cfg.CheckFiles()).Should(Succeed())
+		Expect(cfg.CircuitBreaker()).Should(BeNil())
+		Expect(cfg.ConcurrencyLimiter()).Should(BeNil())
+		Expect(cfg.UpstreamQueueTimeout).Should(Equal(main.DefaultUpstreamQueueTimeout))
+		Expect(cfg.AnswerCache()).Should(BeNil())
+		Expect(cfg.Sessions()).Should(BeNil())
+		Expect(cfg.CORS()).Should(BeNil())
+		Expect(cfg.Keys()).Should(BeNil())
+		Expect(cfg.CSVFetcher()).Should(BeNil())
+		Expect(cfg.Debug).Should(BeFalse())
+		Expect(cfg.Normalize).Should(BeNil())
+		Expect(cfg.Round).Should(BeNil())
+		Expect(cfg.MaxBodySize).Should(BeZero())
+		Expect(cfg.RequestDeadline).Should(BeZero())
+		Expect(cfg.ShutdownGracePeriod).Should(Equal(main.DefaultShutdownGracePeriod))
+		Expect(cfg.CheckFiles()).Should(Succeed())
+
+		connector := cfg.Connector()
+		Expect(connector.ModelName()).Should(Equal(main.DefaultModel))
+		Expect(connector.Client.Timeout).Should(Equal(main.DefaultRequestTimeout))
+	})
+
+	It("reads overrides", func() {
+		cfg, err := main.LoadConfig(env(map[string]string{
+			"HUGGINGFACE_TOKEN":         "hf_test",
+			"HF_MODEL":                  "google/tapas-large-finetuned-wtq",
+			"HF_TASK":                   "text-generation",
+			"HF_FALLBACK_MODELS":        "google/tapas-base-finetuned-wtq, org/backup",
+			"HF_API_BASE":               "http://127.0.0.1:9999",
+			"AI_REQUEST_TIMEOUT":        "45s",
+			"HF_USE_CACHE":              "false",
+			"MAX_RESPONSE_BYTES":        "2048",
+			"PORT":                      "9090",
+			"BIND_ADDR":                 "127.0.0.1",
+			"DATA_CSV_PATH":             "/srv/data.csv",
+			"DATA_REFRESH_INTERVAL":     "30s",
+			"TABLES_DIR":                "/srv/tables",
+			"DATA_DIR":                  "/srv/data",
+			"RATE_LIMIT_RPS":            "2.5",
+			"CIRCUIT_BREAKER_THRESHOLD": "5",
+			"CIRCUIT_BREAKER_COOLDOWN":  "1m",
+			"UPSTREAM_MAX_CONCURRENCY":  "8",
+			"UPSTREAM_QUEUE_TIMEOUT":    "0",
+			"ANSWER_CACHE_SIZE":         "100",
+			"SESSION_TABLES":            "20",
+			"SESSION_TABLE_TTL":         "1h",
+			"CORS_ALLOWED_ORIGINS":      "https://app.example.com, http://localhost:5173",
+			"CORS_ALLOWED_METHODS":      "post",
+			"API_KEYS":                  "old-key, new-key",
+			"TRUSTED_PROXIES":           "10.0.0.0/8, 192.168.1.1",
+			"CSV_URL_ALLOWED_HOSTS":     "data.example.com,*.cdn.example.com",
+			"CSV_URL_TIMEOUT":           "3s",
+			"DEBUG_RESPONSES":           "true",
+			"WARMUP":                    "true",
+			"NORMALIZE_CELLS":           "true",
+			"ROUND_NUMERIC_CELLS":       "2",
+			"INDEX_COLUMN":              "Room",
+			"MAX_BODY_BYTES":            "512",
+			"MAX_QUERY_LENGTH":          "100",
+			"REQUEST_DEADLINE":          "10s",
+		}))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(cfg.Model).Should(Equal("google/tapas-large-finetuned-wtq"))
+		Expect(cfg.Fallbacks).Should(Equal([]string{"google/tapas-base-finetuned-wtq", "org/backup"}))
+		Expect(cfg.Timeout).Should(Equal(45 * time.Second))
+		Expect(cfg.ListenAddr).Should(Equal("127.0.0.1:9090"))
+		Expect(cfg.DataPath).Should(Equal("/srv/data.csv"))
+		Expect(cfg.DataRefreshInterval).Should(Equal(30 * time.Second))
+		Expect(cfg.TablesDir).Should(Equal("/srv/tables"))
+		Expect(cfg.DataDir).Should(Equal("/srv/data"))
+		Expect(cfg.DataFiles()).ShouldNot(BeNil())
+		Expect(cfg.RateLimitRPS).Should(Equal(2.5))
+		Expect(cfg.RateLimitBurst).Should(Equal(3))
+		Expect(cfg.BreakerThreshold).Should(Equal(5))
+		Expect(cfg.BreakerCooldown).Should(Equal(time.Minute))
+		Expect(cfg.CircuitBreaker()).ShouldNot(BeNil())
+		Expect(cfg.MaxUpstreamConcurrency).Should(Equal(8))
+		Expect(cfg.UpstreamQueueTimeout).Should(BeZero())
+		Expect(cfg.ConcurrencyLimiter()).ShouldNot(BeNil())
+		Expect(cfg.AnswerCacheTTL).Should(Equal(main.DefaultAnswerCacheTTL))
+		Expect(cfg.AnswerCache()).ShouldNot(BeNil())
+		Expect(cfg.SessionTables).Should(Equal(20))
+		Expect(cfg.SessionTableTTL).Should(Equal(time.Hour))
+		Expect(cfg.Sessions()).ShouldNot(BeNil())
+		Expect(cfg.CORS()).Should(Equal(&main.CORS{
+			Origins: []string{"https://app.example.com", "http://localhost:5173"},
+			Methods: []string{"POST"},
+			Headers: main.DefaultCORSHeaders,
+		}))
+		Expect(cfg.APIKeys).Should(Equal([]string{"old-key", "new-key"}))
+		Expect(cfg.Keys().Valid("new-key")).Should(BeTrue())
+		Expect(cfg.Server(nil, nil).TrustedProxies).Should(Equal([]string{"10.0.0.0/8", "192.168.1.1"}))
+		Expect(cfg.CSVFetcher()).Should(Equal(&main.CSVFetcher{Hosts: []string{"data.example.com", "*.cdn.example.com"}, Timeout: 3 * time.Second}))
+		Expect(cfg.CSVFetcher().Check("https://eu.cdn.example.com/a.csv")).Should(Succeed())
+		Expect(cfg.CSVFetcher().Check("http://data.example.com/a.csv")).ShouldNot(Succeed())
+		Expect(cfg.Normalize).ShouldNot(BeNil())
+		Expect(cfg.Server(nil, nil).Round).Should(Equal(&main.RoundOptions{Precision: 2}))
+		Expect(cfg.IndexColumn).Should(Equal("Room"))
+		Expect(cfg.Debug).Should(BeTrue())
+		Expect(cfg.Warmup).Should(BeTrue())
+		Expect(cfg.MaxBodySize).Should(Equal(int64(512)))
+		Expect(cfg.MaxQueryLength).Should(Equal(100))
+		Expect(cfg.RequestDeadline).Should(Equal(10 * time.Second))
+		Expect(cfg.CheckFiles()).Should(MatchError(ContainSubstring("DATA_CSV_PATH")))
+
+		connector := cfg.Connector()
+		Expect(connector.ModelURL()).Should(Equal("http://127.0.0.1:9999/models/google/tapas-large-finetuned-wtq"))
+		Expect(connector.DisableCache).Should(BeTrue())
+		Expect(connector.TaskName()).Should(Equal(main.TaskTextGeneration))
+		Expect(connector.MaxResponseSize).Should(Equal(int64(2048)))
+	})
+
+	It("loads without a token so readiness can report it", func() {
+		cfg, err := main.LoadConfig(env(nil))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.Token).Should(BeEmpty())
+
+		rec := httptest.NewRecorder()
+		cfg.Server(nil, nil).Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		Expect(rec.Code).Should(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Body.String()).Should(ContainSubstring("HUGGINGFACE_TOKEN is not set"))
+
+		cfg, err = main.LoadConfig(env(map[string]string{"MOCK_AI": "true"}))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.Mock).Should(BeTrue())
+	})
+
+	It("combines BIND_ADDR and PORT", func() {
+		for vars, addr := range map[[2]string]string{
+			{"", "9090"}:          ":9090",
+			{"127.0.0.1", "9090"}: "127.0.0.1:9090",
+			{"::1", "9090"}:       "[::1]:9090",
+			{"::1", ""}:           "[::1]:8080",
+		} {
+			cfg, err := main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test", "BIND_ADDR": vars[0], "PORT": vars[1]}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(cfg.ListenAddr).Should(Equal(addr))
+		}
+	})
+
+	It("resolves paths and reports missing files", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("a\n1\n"), 0o600)).Should(Succeed())
+		cfg, err := main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test", "DATA_CSV_PATH": path}))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.DataPath).Should(Equal(path))
+		Expect(cfg.CheckFiles()).Should(Succeed())
+
+		cfg, err = main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test", "DATA_CSV_PATH": path + ".missing"}))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.CheckFiles()).Should(MatchError(ContainSubstring("DATA_CSV_PATH")))
+	})
+
+	It("sets the table limits individually", func() {
+		cfg, err := main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test", "MAX_TABLE_ROWS": "100", "MAX_TABLE_CELLS": "0"}))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfg.TableLimits).Should(Equal(main.TableLimits{MaxRows: 100, MaxColumns: main.DefaultTableLimits.MaxColumns}))
+		Expect(cfg.Server(nil, nil).TableLimits).Should(Equal(&cfg.TableLimits))
+	})
+
+	It("rejects invalid ports, timeouts and rate limits", func() {
+		for _, vars := range []map[string]string{
+			{"PORT": "http"}, {"PORT": "65536"}, {"PORT": "-1"},
+			{"AI_REQUEST_TIMEOUT": "-1s"}, {"AI_REQUEST_TIMEOUT": "0"},
+			{"RATE_LIMIT_RPS": "2.5", "RATE_LIMIT_BURST": "none"},
+		} {
+			vars["HUGGINGFACE_TOKEN"] = "hf_test"
+			_, err := main.LoadConfig(env(vars))
+			Expect(err).Should(HaveOccurred(), "%v", vars)
+		}
+	})
+
+	It("names the invalid variable", func() {
+		for name, value := range map[string]string{
+			"HF_MODEL":                  "not a model",
+			"HF_FALLBACK_MODELS":        "org/ok,../admin",
+			"HF_API_BASE":               "ftp://example.com",
+			"HF_TASK":                   "summarization",
+			"AI_REQUEST_TIMEOUT":        "soon",
+			"PORT":                      "0",
+			"MAX_TABLE_ROWS":            "-1",
+			"MAX_TABLE_COLUMNS":         "many",
+			"MAX_TABLE_CELLS":           "1e6",
+			"DATA_REFRESH_INTERVAL":     "0",
+			"LOG_LEVEL":                 "loud",
+			"RATE_LIMIT_RPS":            "-1",
+			"MAX_UPLOAD_BYTES":          "0",
+			"CIRCUIT_BREAKER_THRESHOLD": "-1",
+			"UPSTREAM_MAX_CONCURRENCY":  "1.5",
+			"UPSTREAM_QUEUE_TIMEOUT":    "-1s",
+			"ANSWER_CACHE_SIZE":         "lots",
+			"ANSWER_CACHE_TTL":          "0s",
+			"SESSION_TABLES":            "-3",
+			"SESSION_TABLE_TTL":         "forever",
+			"CORS_ALLOWED_ORIGINS":      "https://app.example.com/path",
+			"CSV_URL_ALLOWED_SCHEMES":   "file",
+			"TRUSTED_PROXIES":           "proxy.internal",
+			"CSV_URL_TIMEOUT":           "never",
+			"MAX_QUERY_LENGTH":          "many",
+			"ROUND_NUMERIC_CELLS":       "-1",
+			"SHUTDOWN_GRACE_PERIOD":     "-5s",
+		} {
+			_, err := main.LoadConfig(env(map[string]string{"HUGGINGFACE_TOKEN": "hf_test", name: value}))
+			Expect(err).Should(HaveOccurred(), name)
+			if name != "HF_API_BASE" && name != "LOG_LEVEL" {
+				Expect(err.Error()).Should(ContainSubstring(name))
+			}
+		}
+	})
+})
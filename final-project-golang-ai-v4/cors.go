@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCORSMethods and DefaultCORSHeaders are the methods and request
+// headers allowed for cross-origin requests when CORS_ALLOWED_METHODS and
+// CORS_ALLOWED_HEADERS are unset.
+var (
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	DefaultCORSHeaders = []string{"Content-Type", RequestIDHeader}
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight
+// response.
+const corsMaxAge = 600
+
+// corsExposedHeaders are the response headers cross-origin scripts may read.
+var corsExposedHeaders = strings.Join([]string{RequestIDHeader, "Retry-After", "X-Dry-Run", "X-Model-URL", MockHeader}, ", ")
+
+// CORS lets browser scripts on other origins call the server. Requests from
+// origins not in Origins get no CORS headers, so browsers keep them
+// same-origin only, and their preflight requests are answered with 403. A
+// nil *CORS allows no other origins.
+type CORS struct {
+	// Origins are the allowed origins, such as "https://app.example.com";
+	// "*" allows every origin.
+	Origins []string
+	// Methods and Headers are the methods and request headers allowed in
+	// cross-origin requests.
+	Methods []string
+	Headers []string
+}
+
+// ValidateOrigin reports an error unless origin is "*" or a bare
+// scheme://host[:port] as browsers send in the Origin header.
+func ValidateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid origin %q, expected scheme://host[:port] or *", origin)
+	}
+	return nil
+}
+
+func (cors *CORS) allows(origin string) bool {
+	for _, allowed := range cors.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware adds the CORS headers to responses for allowed origins and
+// answers their preflight OPTIONS requests with 204.
+func (cors *CORS) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if cors == nil || origin == "" {
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !cors.allows(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+			}
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", strings.Join(cors.Methods, ", "))
+		c.Header("Access-Control-Allow-Headers", strings.Join(cors.Headers, ", "))
+		c.Header("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
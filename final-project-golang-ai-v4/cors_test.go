@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CORS", func() {
+	var router http.Handler
+
+	BeforeEach(func() {
+		server := &main.Server{
+			Model: &fakeModel{response: main.Response{Answer: "TV"}},
+			CORS: &main.CORS{
+				Origins: []string{"https://app.example.com"},
+				Methods: main.DefaultCORSMethods,
+				Headers: main.DefaultCORSHeaders,
+			},
+		}
+		router = server.Router()
+	})
+
+	request := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		body := ""
+		if method == http.MethodPost {
+			body = `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+		}
+		req := httptest.NewRequest(method, "/ask-json", strings.NewReader(body))
+		req.Header.Set("Origin", origin)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	preflight := http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"content-type"},
+	}
+
+	It("answers preflight requests from an allowed origin", func() {
+		rec := request(http.MethodOptions, "https://app.example.com", preflight)
+
+		Expect(rec.Code).Should(Equal(http.StatusNoContent))
+		Expect(rec.Header().Get("Access-Control-Allow-Origin")).Should(Equal("https://app.example.com"))
+		Expect(rec.Header().Get("Access-Control-Allow-Methods")).Should(Equal("GET, POST"))
+		Expect(rec.Header().Get("Access-Control-Allow-Headers")).Should(Equal("Content-Type, X-Request-ID"))
+		Expect(rec.Header().Get("Vary")).Should(Equal("Origin"))
+	})
+
+	It("marks answers to an allowed origin as readable", func() {
+		rec := request(http.MethodPost, "https://app.example.com", nil)
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Access-Control-Allow-Origin")).Should(Equal("https://app.example.com"))
+		Expect(rec.Header().Get("Access-Control-Expose-Headers")).Should(ContainSubstring("X-Request-ID"))
+	})
+
+	It("sends no CORS headers to other origins and rejects their preflights", func() {
+		rec := request(http.MethodOptions, "https://evil.example.com", preflight)
+		Expect(rec.Code).Should(Equal(http.StatusForbidden))
+		Expect(rec.Header().Get("Access-Control-Allow-Origin")).Should(BeEmpty())
+
+		rec = request(http.MethodPost, "https://evil.example.com", nil)
+		Expect(rec.Header().Get("Access-Control-Allow-Origin")).Should(BeEmpty())
+	})
+
+	It("allows no other origins by default", func() {
+		router = (&main.Server{Model: &fakeModel{}}).Router()
+		rec := request(http.MethodOptions, "https://app.example.com", preflight)
+		Expect(rec.Header().Get("Access-Control-Allow-Origin")).Should(BeEmpty())
+	})
+
+	It("validates configured origins", func() {
+		Expect(main.ValidateOrigin("https://app.example.com:8443")).Should(Succeed())
+		Expect(main.ValidateOrigin("*")).Should(Succeed())
+		for _, origin := range []string{"app.example.com", "https://app.example.com/", "ftp://example.com", "https://"} {
+			Expect(main.ValidateOrigin(origin)).ShouldNot(Succeed(), origin)
+		}
+	})
+})
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCSVURLTimeout bounds fetching a "csv_url" when CSV_URL_TIMEOUT is
+// unset.
+const DefaultCSVURLTimeout = 10 * time.Second
+
+// maxCSVURLRedirects is how many redirects a CSV fetch follows.
+const maxCSVURLRedirects = 5
+
+// CSVFetcher fetches the CSV that an /ask request names by "csv_url". To keep
+// clients from making the server call internal services for them, it only
+// fetches URLs whose scheme and host are allowed, redirects included. It
+// trusts the allowed hosts: one that resolves to an internal address is
+// fetched all the same. A nil *CSVFetcher fetches nothing.
+type CSVFetcher struct {
+	// Hosts are the allowed host names, such as "data.example.com"; a
+	// leading "*." as in "*.example.com" allows every subdomain.
+	Hosts []string
+	// Schemes are the allowed URL schemes, "https" when empty.
+	Schemes []string
+	// Timeout bounds each fetch, DefaultCSVURLTimeout when zero.
+	Timeout time.Duration
+	// MaxSize bounds the fetched CSV, in bytes; the server's upload limit
+	// applies when zero.
+	MaxSize int64
+	// Client makes the requests; http.DefaultTransport is used when nil.
+	Client *http.Client
+}
+
+// URLNotAllowedError is returned by CSVFetcher.Fetch for a URL it will not
+// fetch.
+type URLNotAllowedError struct {
+	URL    string
+	Reason string
+}
+
+func (e *URLNotAllowedError) Error() string {
+	return fmt.Sprintf("csv_url %q is not allowed: %s", e.URL, e.Reason)
+}
+
+// CSVFetchError is returned by CSVFetcher.Fetch when the CSV could not be
+// downloaded.
+type CSVFetchError struct {
+	URL string
+	// StatusCode is the status of an unsuccessful response, or zero when
+	// there was none.
+	StatusCode int
+	// TooLarge is set when the CSV exceeds the size limit.
+	TooLarge bool
+	Err      error
+}
+
+func (e *CSVFetchError) Error() string {
+	return fmt.Sprintf("error fetching %s: %v", e.URL, e.Err)
+}
+
+func (e *CSVFetchError) Unwrap() error { return e.Err }
+
+// Check returns a *URLNotAllowedError unless rawURL may be fetched.
+func (f *CSVFetcher) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &URLNotAllowedError{URL: rawURL, Reason: "it is not a valid URL"}
+	}
+	return f.check(u)
+}
+
+func (f *CSVFetcher) check(u *url.URL) error {
+	if f == nil {
+		return &URLNotAllowedError{URL: u.Redacted(), Reason: "fetching CSV from URLs is disabled on this server"}
+	}
+	schemes := f.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"https"}
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return &URLNotAllowedError{URL: u.Redacted(), Reason: fmt.Sprintf("the scheme must be one of %s", strings.Join(schemes, ", "))}
+	}
+	if u.User != nil {
+		return &URLNotAllowedError{URL: u.Redacted(), Reason: "it must not contain credentials"}
+	}
+	if !f.allowedHost(u.Hostname()) {
+		return &URLNotAllowedError{URL: u.Redacted(), Reason: fmt.Sprintf("host %q is not in the allowlist", u.Hostname())}
+	}
+	return nil
+}
+
+func (f *CSVFetcher) allowedHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, allowed := range f.Hosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads the CSV at rawURL, reading at most maxSize bytes, and
+// parses it like an upload. Errors are a *URLNotAllowedError, a
+// *CSVFetchError or, for a CSV that cannot be parsed, the error of
+// CsvToSliceOrdered.
+func (f *CSVFetcher) Fetch(ctx context.Context, rawURL string, maxSize int64) (map[string][]string, []string, error) {
+	if err := f.Check(rawURL); err != nil {
+		return nil, nil, err
+	}
+	if f.MaxSize > 0 {
+		maxSize = f.MaxSize
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCSVURLTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := http.Client{}
+	if f.Client != nil {
+		client = *f.Client
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxCSVURLRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxCSVURLRedirects)
+		}
+		return f.check(req.URL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, &CSVFetchError{URL: rawURL, Err: err}
+	}
+	req.Header.Set("Accept", "text/csv, text/plain;q=0.9, */*;q=0.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		var notAllowed *URLNotAllowedError
+		if errors.As(err, &notAllowed) {
+			return nil, nil, notAllowed
+		}
+		return nil, nil, &CSVFetchError{URL: rawURL, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &CSVFetchError{URL: rawURL, StatusCode: resp.StatusCode, Err: fmt.Errorf("server answered %s", resp.Status)}
+	}
+	if resp.ContentLength > maxSize {
+		return nil, nil, &CSVFetchError{URL: rawURL, TooLarge: true, Err: fmt.Errorf("CSV exceeds %d bytes", maxSize)}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, nil, &CSVFetchError{URL: rawURL, Err: err}
+	}
+	if int64(len(data)) > maxSize {
+		return nil, nil, &CSVFetchError{URL: rawURL, TooLarge: true, Err: fmt.Errorf("CSV exceeds %d bytes", maxSize)}
+	}
+	if !LooksLikeText(data) {
+		return nil, nil, &CSVFetchError{URL: rawURL, Err: errors.New("the response is not a text CSV")}
+	}
+	return CsvToSliceOrdered(string(data))
+}
+
+// containsFold reports whether list holds s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFetchError responds to a "csv_url" that could not be fetched or
+// parsed.
+func (s *Server) writeFetchError(c *gin.Context, err error) {
+	var (
+		notAllowed *URLNotAllowedError
+		fetchErr   *CSVFetchError
+	)
+	switch {
+	case errors.As(err, &notAllowed):
+		body := errorJSON(c, CodeURLNotAllowed, err.Error())
+		body.Error.Field = "csv_url"
+		c.JSON(http.StatusBadRequest, body)
+	case errors.As(err, &fetchErr) && fetchErr.TooLarge:
+		body := errorJSON(c, CodeTooLarge, err.Error())
+		body.Error.Field = "csv_url"
+		c.JSON(http.StatusRequestEntityTooLarge, body)
+	case errors.As(err, &fetchErr):
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		body := errorJSON(c, CodeCSVFetch, err.Error())
+		body.Error.Field = "csv_url"
+		if fetchErr.StatusCode != 0 {
+			body.Error.Details = gin.H{"status": fetchErr.StatusCode}
+		}
+		c.JSON(status, body)
+	default:
+		s.Metrics.csvParseFailed()
+		c.JSON(http.StatusBadRequest, csvErrorJSON(c, err))
+	}
+}
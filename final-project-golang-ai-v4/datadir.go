@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DataDir lets /ask query any CSV in a directory on the server, picked by
+// the "file" the client names. Names are relative slash-separated paths such
+// as "2024/march.csv"; absolute paths, ".." elements and symlinks pointing
+// out of the directory are refused, as are files that are not *.csv or
+// *.csv.gz. Each file is kept as a TableCache once opened, so edits are
+// picked up without a restart. It is safe for concurrent use, and a nil
+// *DataDir opens nothing.
+type DataDir struct {
+	root string
+
+	mu     sync.Mutex
+	tables map[string]*TableCache
+}
+
+// FileNotAllowedError is returned by DataDir.Open for a name it will not
+// open.
+type FileNotAllowedError struct {
+	Name   string
+	Reason string
+}
+
+func (e *FileNotAllowedError) Error() string {
+	return fmt.Sprintf("file %q is not allowed: %s", e.Name, e.Reason)
+}
+
+// NewDataDir serves the CSV files under root.
+func NewDataDir(root string) *DataDir {
+	return &DataDir{root: root, tables: make(map[string]*TableCache)}
+}
+
+// Open returns the table in the file called name. Errors are a
+// *FileNotAllowedError, an error matching fs.ErrNotExist for a missing file,
+// or the error of parsing the file.
+func (d *DataDir) Open(name string) (*TableCache, error) {
+	path, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cache, ok := d.tables[path]; ok {
+		return cache, nil
+	}
+	cache, err := NewTableCache(path)
+	if err != nil {
+		return nil, err
+	}
+	d.tables[path] = cache
+	return cache, nil
+}
+
+// resolve returns the path of the file called name, with symlinks
+// evaluated, after checking that it lies within d.root.
+func (d *DataDir) resolve(name string) (string, error) {
+	if d == nil {
+		return "", &FileNotAllowedError{Name: name, Reason: "selecting files is disabled on this server"}
+	}
+	if strings.ContainsAny(name, "\\\x00") || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", &FileNotAllowedError{Name: name, Reason: "it must be a relative path within the data directory"}
+	}
+	if !strings.HasSuffix(name, ".csv") && !strings.HasSuffix(name, ".csv.gz") {
+		return "", &FileNotAllowedError{Name: name, Reason: "only .csv and .csv.gz files can be selected"}
+	}
+
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+		return "", &FileNotAllowedError{Name: name, Reason: "it must be a relative path within the data directory"}
+	}
+	if info, err := os.Stat(path); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return path, nil
+}
+
+// writeFileError responds to a "file" that could not be opened.
+func (s *Server) writeFileError(c *gin.Context, name string, err error) {
+	var notAllowed *FileNotAllowedError
+	switch {
+	case errors.As(err, &notAllowed):
+		body := errorJSON(c, CodeFileNotAllowed, err.Error())
+		body.Error.Field = "file"
+		c.JSON(http.StatusBadRequest, body)
+	case errors.Is(err, fs.ErrNotExist):
+		body := errorJSON(c, CodeTableNotFound, fmt.Sprintf("File %q not found", name))
+		body.Error.Field = "file"
+		c.JSON(http.StatusNotFound, body)
+	default:
+		s.Metrics.csvParseFailed()
+		writeDataError(c, fmt.Sprintf("file %q", name), err)
+	}
+}
@@ -0,0 +1,146 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DataDir", func() {
+	var (
+		root   string
+		secret string
+	)
+
+	BeforeEach(func() {
+		base := GinkgoT().TempDir()
+		root = filepath.Join(base, "data")
+		Expect(os.MkdirAll(filepath.Join(root, "2024"), 0o700)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "energy.csv"), []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "2024", "march.csv.gz"), gzipBytes("Appliance,Room\nTV,Lounge\n"), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "notes.txt"), []byte("Appliance\nLamp\n"), 0o600)).Should(Succeed())
+		secret = filepath.Join(base, "secret.csv")
+		Expect(os.WriteFile(secret, []byte("Password\nhunter2\n"), 0o600)).Should(Succeed())
+	})
+
+	It("opens files by their path within the directory", func() {
+		files := main.NewDataDir(root)
+		cache, err := files.Open("energy.csv")
+		Expect(err).ShouldNot(HaveOccurred())
+		table, _, err := cache.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table).Should(Equal(map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}}))
+
+		again, err := files.Open("./energy.csv")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(again).Should(BeIdenticalTo(cache))
+
+		cache, err = files.Open("2024/march.csv.gz")
+		Expect(err).ShouldNot(HaveOccurred())
+		table, _, err = cache.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table).Should(Equal(map[string][]string{"Appliance": {"TV"}, "Room": {"Lounge"}}))
+	})
+
+	It("refuses names outside the directory", func() {
+		Expect(os.Symlink(secret, filepath.Join(root, "link.csv"))).Should(Succeed())
+		files := main.NewDataDir(root)
+		for _, name := range []string{
+			"../secret.csv",
+			"2024/../../secret.csv",
+			secret,
+			"/etc/passwd.csv",
+			`..\secret.csv`,
+			"energy.csv\x00.csv",
+			"",
+			"notes.txt",
+			"2024",
+			"link.csv",
+		} {
+			_, err := files.Open(name)
+			var notAllowed *main.FileNotAllowedError
+			Expect(errors.As(err, &notAllowed)).Should(BeTrue(), "%q: %v", name, err)
+		}
+	})
+
+	It("reports missing files as such", func() {
+		_, err := main.NewDataDir(root).Open("missing.csv")
+		Expect(err).Should(MatchError(fs.ErrNotExist))
+
+		var files *main.DataDir
+		_, err = files.Open("energy.csv")
+		var notAllowed *main.FileNotAllowedError
+		Expect(errors.As(err, &notAllowed)).Should(BeTrue())
+	})
+
+	Context("in the server", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "Lamp"}}
+			server = &main.Server{Model: model, Files: main.NewDataDir(root)}
+		})
+
+		ask := func(file string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(map[string]string{"query": "Which appliance?", "file": file})
+			Expect(err).ShouldNot(HaveOccurred())
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(string(body))))
+			return rec
+		}
+
+		errorCode := func(rec *httptest.ResponseRecorder) string {
+			var body main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+			Expect(body.Error.Field).Should(Equal("file"))
+			return body.Error.Code
+		}
+
+		It("queries the selected file", func() {
+			rec := ask("2024/march.csv.gz")
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(model.received).Should(Equal([]main.Inputs{{
+				Table:   map[string][]string{"Appliance": {"TV"}, "Room": {"Lounge"}},
+				Query:   "Which appliance?",
+				Columns: []string{"Appliance", "Room"},
+			}}))
+		})
+
+		It("rejects traversal attempts", func() {
+			for _, name := range []string{"../secret.csv", secret} {
+				rec := ask(name)
+				Expect(rec.Code).Should(Equal(http.StatusBadRequest), name)
+				Expect(errorCode(rec)).Should(Equal(main.CodeFileNotAllowed))
+				Expect(rec.Body.String()).ShouldNot(ContainSubstring("hunter2"))
+			}
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("answers 404 for missing files", func() {
+			rec := ask("2023/march.csv")
+			Expect(rec.Code).Should(Equal(http.StatusNotFound))
+			Expect(errorCode(rec)).Should(Equal(main.CodeTableNotFound))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring(root))
+		})
+
+		It("answers 400 when selecting files is disabled", func() {
+			server.Files = nil
+			rec := ask("energy.csv")
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(errorCode(rec)).Should(Equal(main.CodeFileNotAllowed))
+		})
+	})
+})
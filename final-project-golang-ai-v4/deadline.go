@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestDeadline gives the context of every request it guards a deadline d
+// from now. The model call runs with that context, so a call still running
+// when the deadline passes is canceled and the request fails with 504. A
+// non-positive d adds no deadline.
+func requestDeadline(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("request deadline", func() {
+	var (
+		release chan struct{}
+		model   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		model = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+				w.Write([]byte(`{"answer": "TV"}`))
+			case <-r.Context().Done():
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+		model.Close()
+	})
+
+	ask := func(server *main.Server) *httptest.ResponseRecorder {
+		body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+		return rec
+	}
+
+	It("cancels a slow model call and answers 504", func() {
+		server := &main.Server{Connector: newServerConnector(model), Token: "token", RequestDeadline: 50 * time.Millisecond}
+
+		start := time.Now()
+		rec := ask(server)
+
+		Expect(rec.Code).Should(Equal(http.StatusGatewayTimeout))
+		Expect(rec.Body.String()).Should(ContainSubstring("deadline exceeded"))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("leaves calls that finish in time alone", func() {
+		server := &main.Server{Connector: newServerConnector(model), Token: "token", RequestDeadline: 5 * time.Second}
+		go func() { release <- struct{}{} }()
+
+		rec := ask(server)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"TV"`))
+	})
+})
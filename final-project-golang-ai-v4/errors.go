@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes identify the kind of failure in an ErrorResponse. They are
+// stable, so clients can branch on them; the messages are for people and may
+// change.
+const (
+	// CodeInvalidRequest is a malformed body or an invalid parameter.
+	CodeInvalidRequest = "invalid_request"
+	// CodeInvalidQuery is an empty or overlong query.
+	CodeInvalidQuery = "invalid_query"
+	// CodeInvalidTable is a table that cannot be sent to the model, such as
+	// one with misaligned columns, or a filter or column that does not fit
+	// it.
+	CodeInvalidTable = "invalid_table"
+	// CodeInvalidModel is a model name that is not a Hugging Face model ID.
+	CodeInvalidModel = "invalid_model"
+	// CodeTableNotFound is an unknown named table.
+	CodeTableNotFound = "table_not_found"
+	// CodeTooLarge is a body, upload or table over its size limit.
+	CodeTooLarge = "too_large"
+	// CodeUnsupportedMedia is an unsupported upload content type, a binary
+	// upload or an Accept header the server cannot satisfy.
+	CodeUnsupportedMedia = "unsupported_media_type"
+	// CodeCSVParse is a CSV that cannot be parsed.
+	CodeCSVParse = "csv_parse_error"
+	// CodeEmptyCSV is a CSV, uploaded or configured, that is empty or has
+	// a header row but no data.
+	CodeEmptyCSV = "empty_csv"
+	// CodeJSONTable is a JSON upload that does not hold a table.
+	CodeJSONTable = "json_table_error"
+	// CodeURLNotAllowed is a "csv_url" outside the server's allowlist.
+	CodeURLNotAllowed = "url_not_allowed"
+	// CodeCSVFetch is a "csv_url" that could not be downloaded.
+	CodeCSVFetch = "csv_fetch_error"
+	// CodeFileNotAllowed is a "file" outside the server's data directory.
+	CodeFileNotAllowed = "file_not_allowed"
+	// CodeUnauthorized is a request without a valid API key.
+	CodeUnauthorized = "unauthorized"
+	// CodeRateLimited is a client over its rate limit.
+	CodeRateLimited = "rate_limited"
+	// CodeNotSupported is a feature the configured model lacks.
+	CodeNotSupported = "not_supported"
+	// CodeNotFound is an unknown route.
+	CodeNotFound = "not_found"
+
+	// CodeUpstreamUnavailable is a model that failed or could not be
+	// reached.
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	// CodeUpstreamTimeout is a model call that timed out.
+	CodeUpstreamTimeout = "upstream_timeout"
+	// CodeModelLoading is a model that is still loading.
+	CodeModelLoading = "model_loading"
+	// CodeCircuitOpen is a model call skipped while the circuit breaker is
+	// open.
+	CodeCircuitOpen = "circuit_open"
+	// CodeUpstreamBusy is a model call skipped because too many were in
+	// flight.
+	CodeUpstreamBusy = "upstream_busy"
+	// CodeUpstreamAuth is a token the inference API rejected.
+	CodeUpstreamAuth = "upstream_auth_error"
+	// CodeInvalidUpstreamResponse is an answer the model returned that
+	// cannot be used.
+	CodeInvalidUpstreamResponse = "invalid_upstream_response"
+
+	// CodeTokenMissing is a server without HUGGINGFACE_TOKEN.
+	CodeTokenMissing = "token_missing"
+	// CodeDataUnavailable is a configured CSV that cannot be read.
+	CodeDataUnavailable = "data_unavailable"
+	// CodeInternal is a bug on our side.
+	CodeInternal = "internal_error"
+)
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes a failed request.
+type ErrorDetail struct {
+	// Code is one of the Code constants.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID lets a client reporting a failure point at the matching
+	// logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Field is the JSON path of the request value at fault, if any.
+	Field string `json:"field,omitempty"`
+	// Details holds further information for some codes, such as the line
+	// of a csv_parse_error or the known tables for table_not_found.
+	Details gin.H `json:"details,omitempty"`
+}
+
+// errorJSON is the body of an error response with the given code and
+// message.
+func errorJSON(c *gin.Context, code, message string) *ErrorResponse {
+	return &ErrorResponse{Error: ErrorDetail{Code: code, Message: message, RequestID: RequestIDFromContext(c.Request.Context())}}
+}
+
+// recoverJSON answers a request whose handler panicked with an
+// internal_error; gin has already logged the panic.
+func recoverJSON(c *gin.Context, _ interface{}) {
+	c.AbortWithStatusJSON(http.StatusInternalServerError, errorJSON(c, CodeInternal, "Internal server error"))
+}
+
+// upstreamCode returns the error code of a failed model call, matching
+// upstreamStatus.
+func upstreamCode(err error) string {
+	var (
+		open     *CircuitOpenError
+		busy     *UpstreamBusyError
+		netErr   net.Error
+		loading  *ModelLoadingError
+		auth     *AuthError
+		invalid  *InvalidResponseError
+		tooLarge *ResponseTooLargeError
+	)
+	switch {
+	case errors.As(err, &open):
+		return CodeCircuitOpen
+	case errors.As(err, &busy):
+		return CodeUpstreamBusy
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return CodeUpstreamTimeout
+	case errors.As(err, &loading):
+		return CodeModelLoading
+	case errors.As(err, &auth):
+		return CodeUpstreamAuth
+	case errors.As(err, &invalid) || errors.As(err, &tooLarge):
+		return CodeInvalidUpstreamResponse
+	case upstreamStatus(err) == http.StatusInternalServerError:
+		return CodeInternal
+	default:
+		return CodeUpstreamUnavailable
+	}
+}
+
+// upstreamStatus returns the HTTP status reporting a failed model call: 503
+// when the circuit breaker is open or too many calls are in flight, 504 when
+// the call timed out, 502 when the model or the connection to it failed, and
+// 500 for anything else, which points to a bug on our side.
+func upstreamStatus(err error) int {
+	var (
+		open *CircuitOpenError
+		busy *UpstreamBusyError
+	)
+	if errors.As(err, &open) || errors.As(err, &busy) {
+		return http.StatusServiceUnavailable
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout
+	}
+
+	var (
+		upstream *UpstreamError
+		loading  *ModelLoadingError
+		auth     *AuthError
+		invalid  *InvalidResponseError
+		tooLarge *ResponseTooLargeError
+		urlErr   *url.Error
+	)
+	if errors.Is(err, context.Canceled) ||
+		errors.As(err, &upstream) ||
+		errors.As(err, &loading) ||
+		errors.As(err, &auth) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &tooLarge) ||
+		errors.As(err, &urlErr) {
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// describeBindError explains why a JSON request body could not be decoded,
+// telling a malformed body apart from a well-formed one with a value of the
+// wrong type. field is the JSON path of the offending value, if any.
+func describeBindError(err error) (message, field string) {
+	var (
+		syntax    *json.SyntaxError
+		wrongType *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &syntax):
+		return fmt.Sprintf("Malformed JSON at byte %d: %v", syntax.Offset, syntax), ""
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Malformed JSON: the body ends before the value is complete", ""
+	case errors.Is(err, io.EOF):
+		return "Request body is empty, expected a JSON object", ""
+	case errors.As(err, &wrongType):
+		if wrongType.Field == "" {
+			return fmt.Sprintf("Invalid request: expected %s, got %s", jsonKind(wrongType.Type), wrongType.Value), ""
+		}
+		return fmt.Sprintf("Invalid value for %q: expected %s, got %s", wrongType.Field, jsonKind(wrongType.Type), wrongType.Value), wrongType.Field
+	default:
+		return fmt.Sprintf("Invalid request: %v", err), ""
+	}
+}
+
+// jsonKind names the kind of JSON value that decodes into t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	default:
+		return t.String()
+	}
+}
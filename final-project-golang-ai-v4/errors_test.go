@@ -0,0 +1,529 @@
+package main_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func newStaticConnector(status int, body string) *main.AIModelConnector {
+	return &main.AIModelConnector{
+		Client: &http.Client{
+			Transport: &MockClient{
+				MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: status,
+						Status:     http.StatusText(status),
+						Header:     http.Header{},
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+					}, nil
+				},
+			},
+		},
+	}
+}
+
+var _ = Describe("ModelLoadingError", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	It("is returned for a 503 with an estimated_time", func() {
+		connector := newStaticConnector(http.StatusServiceUnavailable,
+			`{"error": "Model google/tapas-base-finetuned-wtq is currently loading", "estimated_time": 20.5}`)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+		Expect(err).Should(HaveOccurred())
+
+		var loading *main.ModelLoadingError
+		Expect(errors.As(err, &loading)).Should(BeTrue())
+		Expect(loading.RetryAfter()).Should(Equal(20500 * time.Millisecond))
+		Expect(loading.Message).Should(ContainSubstring("currently loading"))
+	})
+
+	It("is not returned for a 503 without an estimated_time", func() {
+		connector := newStaticConnector(http.StatusServiceUnavailable, `Service Unavailable`)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+		Expect(err).Should(HaveOccurred())
+
+		var loading *main.ModelLoadingError
+		Expect(errors.As(err, &loading)).Should(BeFalse())
+		Expect(err.Error()).Should(ContainSubstring("failed to get valid response: 503"))
+	})
+
+	It("is not returned for other non-200 statuses", func() {
+		connector := newStaticConnector(http.StatusBadRequest, `{"error": "bad input", "estimated_time": 1}`)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+
+		var loading *main.ModelLoadingError
+		Expect(errors.As(err, &loading)).Should(BeFalse())
+	})
+})
+
+var _ = Describe("UpstreamError", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	It("surfaces the upstream body of a 400", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "table must be a dict of lists"}`))
+		}))
+		defer server.Close()
+
+		_, err := newServerConnector(server).ConnectAIModel(payload, "hf_secret")
+
+		var upstream *main.UpstreamError
+		Expect(errors.As(err, &upstream)).Should(BeTrue())
+		Expect(upstream.StatusCode).Should(Equal(http.StatusBadRequest))
+		Expect(upstream.Body).Should(Equal(`{"error": "table must be a dict of lists"}`))
+		Expect(err.Error()).Should(ContainSubstring("table must be a dict of lists"))
+		Expect(err.Error()).ShouldNot(ContainSubstring("hf_secret"))
+	})
+
+	It("truncates long bodies", func() {
+		connector := newStaticConnector(http.StatusBadRequest, strings.Repeat("x", 5000))
+
+		_, err := connector.ConnectAIModel(payload, "token")
+
+		var upstream *main.UpstreamError
+		Expect(errors.As(err, &upstream)).Should(BeTrue())
+		Expect(len(upstream.Body)).Should(BeNumerically("<", 1100))
+		Expect(upstream.Body).Should(HaveSuffix("(truncated)"))
+	})
+})
+
+var _ = Describe("InvalidResponseError", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	It("is returned for an error object sent with a 200", func() {
+		connector := newStaticConnector(http.StatusOK, `{"error": "Input table is empty"}`)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+
+		var invalid *main.InvalidResponseError
+		Expect(errors.As(err, &invalid)).Should(BeTrue())
+		Expect(err.Error()).Should(ContainSubstring("Input table is empty"))
+	})
+
+	It("is returned when the answer field is missing", func() {
+		for _, body := range []string{`{}`, `{"cells": ["TV"]}`, `[]`, `not json`} {
+			_, err := newStaticConnector(http.StatusOK, body).ConnectAIModel(payload, "token")
+
+			var invalid *main.InvalidResponseError
+			Expect(errors.As(err, &invalid)).Should(BeTrue(), body)
+		}
+	})
+
+	It("accepts an answer that is an empty cell", func() {
+		connector := newStaticConnector(http.StatusOK, `{"answer": "", "coordinates": [[0, 0]], "cells": [""], "aggregator": "NONE"}`)
+
+		response, err := connector.ConnectAIModel(payload, "token")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response).Should(Equal(main.Response{Coordinates: [][]int{{0, 0}}, Cells: []string{""}, Aggregator: "NONE"}))
+	})
+
+	It("fills in an empty answer from the selected cells", func() {
+		connector := newStaticConnector(http.StatusOK, `{"answer": "", "coordinates": [[0, 3], [1, 3]], "cells": ["1.5", "2"], "aggregator": "SUM"}`)
+
+		response, err := connector.ConnectAIModel(payload, "token")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.Answer).Should(Equal("3.5"))
+		Expect(response.AnswerFromCells).Should(BeTrue())
+		Expect(response.Cells).Should(Equal([]string{"1.5", "2"}))
+	})
+})
+
+var _ = Describe("AuthError", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		status := status
+
+		It(fmt.Sprintf("is returned for a %d with the token redacted", status), func() {
+			connector := newStaticConnector(status, `{"error": "Authorization header is invalid: hf_secret"}`)
+
+			_, err := connector.ConnectAIModel(payload, "hf_secret")
+
+			var auth *main.AuthError
+			Expect(errors.As(err, &auth)).Should(BeTrue())
+			Expect(auth.StatusCode).Should(Equal(status))
+			Expect(err.Error()).ShouldNot(ContainSubstring("hf_secret"))
+			Expect(err.Error()).Should(ContainSubstring("[REDACTED]"))
+
+			var upstream *main.UpstreamError
+			Expect(errors.As(err, &upstream)).Should(BeFalse())
+		})
+	}
+})
+
+var _ = Describe("AIModelConnector headers", func() {
+	It("sends extra headers without overriding the token", func() {
+		var received http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.Write([]byte(`{"answer": "value1"}`))
+		}))
+		defer server.Close()
+
+		connector := newServerConnector(server)
+		connector.Headers = http.Header{
+			"X-Use-Cache":   {"false"},
+			"Authorization": {"Bearer other"},
+		}
+
+		_, err := connector.ConnectAIModel(main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}, "hf_secret")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(received.Get("X-Use-Cache")).Should(Equal("false"))
+		Expect(received.Values("Authorization")).Should(Equal([]string{"Bearer hf_secret"}))
+		Expect(received.Get("Accept")).Should(Equal("application/json"))
+	})
+})
+
+var _ = Describe("AIModelConnector cache", func() {
+	var (
+		received http.Header
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	It("leaves the cache enabled by default", func() {
+		_, err := newServerConnector(server).ConnectAIModel(main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(received).ShouldNot(HaveKey("X-Use-Cache"))
+	})
+
+	It("sends x-use-cache: false when disabled", func() {
+		connector := newServerConnector(server)
+		connector.DisableCache = true
+
+		_, err := connector.ConnectAIModel(main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(received.Get("X-Use-Cache")).Should(Equal("false"))
+	})
+
+	It("can be disabled per request with ?use_cache=false", func() {
+		app := &main.Server{Connector: newServerConnector(server), Token: "token"}
+		body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+
+		rec := httptest.NewRecorder()
+		app.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json?use_cache=false", strings.NewReader(body)))
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(received.Get("X-Use-Cache")).Should(Equal("false"))
+
+		rec = httptest.NewRecorder()
+		app.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+		Expect(received).ShouldNot(HaveKey("X-Use-Cache"))
+	})
+})
+
+var _ = Describe("AIModelConnector compression", func() {
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+
+	It("decodes gzip-encoded responses", func() {
+		var acceptEncoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"answer": "TV", "cells": ["TV"], "aggregator": "NONE"}`))
+			gz.Close()
+		}))
+		defer server.Close()
+
+		response, err := newServerConnector(server).ConnectAIModel(payload, "token")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(acceptEncoding).Should(Equal("gzip"))
+		Expect(response.Answer).Should(Equal("TV"))
+		Expect(response.Cells).Should(Equal([]string{"TV"}))
+	})
+
+	It("reads plain responses as before", func() {
+		response, err := newStaticConnector(http.StatusOK, `{"answer": "TV"}`).ConnectAIModel(payload, "token")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.Answer).Should(Equal("TV"))
+	})
+
+	It("reports a corrupt gzip body", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		defer server.Close()
+
+		_, err := newServerConnector(server).ConnectAIModel(payload, "token")
+		Expect(err).Should(MatchError(ContainSubstring("decompressing response")))
+	})
+})
+
+var _ = Describe("ResponseTooLargeError", func() {
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+
+	It("is returned for a body over the limit", func() {
+		connector := newStaticConnector(http.StatusOK, `{"answer": "`+strings.Repeat("x", 100)+`"}`)
+		connector.MaxResponseSize = 64
+
+		_, err := connector.ConnectAIModel(payload, "token")
+
+		var tooLarge *main.ResponseTooLargeError
+		Expect(errors.As(err, &tooLarge)).Should(BeTrue())
+		Expect(tooLarge.Limit).Should(Equal(int64(64)))
+	})
+
+	It("accepts a body exactly at the limit", func() {
+		body := `{"answer": "TV"}`
+		connector := newStaticConnector(http.StatusOK, body)
+		connector.MaxResponseSize = int64(len(body))
+
+		_, err := connector.ConnectAIModel(payload, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("applies the limit after decompression", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"answer": "` + strings.Repeat("x", 1<<20) + `"}`))
+			gz.Close()
+		}))
+		defer server.Close()
+		connector := newServerConnector(server)
+		connector.MaxResponseSize = 1 << 10
+
+		_, err := connector.ConnectAIModel(payload, "token")
+
+		var tooLarge *main.ResponseTooLargeError
+		Expect(errors.As(err, &tooLarge)).Should(BeTrue())
+	})
+})
+
+var _ = Describe("token redaction", func() {
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+	const token = "hf_AbCdEf0123456789"
+
+	// echoingConnector fails every call with an error quoting the
+	// Authorization header, as some proxies do.
+	echoingConnector := func() *main.AIModelConnector {
+		return &main.AIModelConnector{
+			Client: &http.Client{
+				Transport: &MockClient{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						return nil, fmt.Errorf("proxy rejected credentials %q", req.Header.Get("Authorization"))
+					},
+				},
+			},
+		}
+	}
+
+	It("keeps the token out of transport errors", func() {
+		_, err := echoingConnector().ConnectAIModel(payload, token)
+
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).ShouldNot(ContainSubstring(token))
+		Expect(err.Error()).Should(ContainSubstring("[REDACTED]"))
+		var urlErr *url.Error
+		Expect(errors.As(err, &urlErr)).Should(BeTrue())
+	})
+
+	It("keeps the token out of responses and logs", func() {
+		buf := &bytes.Buffer{}
+		logger, err := main.NewLogger(buf, "debug")
+		Expect(err).ShouldNot(HaveOccurred())
+		server := &main.Server{
+			Connector: echoingConnector(),
+			Token:     token,
+			Logger:    logger,
+			Retry:     &main.RetryPolicy{MaxAttempts: 1},
+		}
+
+		body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+
+		Expect(rec.Code).Should(Equal(http.StatusBadGateway))
+		Expect(rec.Body.String()).Should(ContainSubstring("proxy rejected credentials"))
+		Expect(rec.Body.String()).ShouldNot(ContainSubstring(token))
+		Expect(buf.String()).Should(ContainSubstring("upstream call failed"))
+		Expect(buf.String()).ShouldNot(ContainSubstring(token))
+	})
+
+	It("scrubs token-like strings other than the configured token", func() {
+		connector := newStaticConnector(http.StatusBadRequest, `{"error": "hf_SomeoneElsesToken1 and Bearer abc.def are invalid"}`)
+
+		_, err := connector.ConnectAIModel(payload, token)
+
+		Expect(err.Error()).ShouldNot(ContainSubstring("hf_SomeoneElsesToken1"))
+		Expect(err.Error()).ShouldNot(ContainSubstring("abc.def"))
+	})
+})
+
+var _ = Describe("model failure status", func() {
+	body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+	ask := func(server *main.Server) *httptest.ResponseRecorder {
+		server.Token = "token"
+		server.Retry = &main.RetryPolicy{MaxAttempts: 1}
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+		return rec
+	}
+	respond := func(status int, body string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		DeferCleanup(server.Close)
+		return server
+	}
+
+	It("is 502 for an upstream 5xx", func() {
+		rec := ask(&main.Server{Connector: newServerConnector(respond(http.StatusInternalServerError, "boom"))})
+		Expect(rec.Code).Should(Equal(http.StatusBadGateway))
+	})
+
+	It("is 502 with Retry-After while the model loads", func() {
+		model := respond(http.StatusServiceUnavailable, `{"error": "loading", "estimated_time": 12.5}`)
+		rec := ask(&main.Server{Connector: newServerConnector(model)})
+		Expect(rec.Code).Should(Equal(http.StatusBadGateway))
+		Expect(rec.Header().Get("Retry-After")).Should(Equal("13"))
+	})
+
+	It("is 502 for an invalid upstream answer", func() {
+		rec := ask(&main.Server{Connector: newServerConnector(respond(http.StatusOK, `{"error": "bad"}`))})
+		Expect(rec.Code).Should(Equal(http.StatusBadGateway))
+	})
+
+	It("is 502 when the connection fails", func() {
+		model := respond(http.StatusOK, `{"answer": "TV"}`)
+		connector := newServerConnector(model)
+		model.Close()
+
+		Expect(ask(&main.Server{Connector: connector}).Code).Should(Equal(http.StatusBadGateway))
+	})
+
+	It("is 504 when the call times out", func() {
+		release := make(chan struct{})
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer model.Close()
+		defer close(release)
+		connector := newServerConnector(model)
+		connector.Client.Timeout = 20 * time.Millisecond
+
+		Expect(ask(&main.Server{Connector: connector}).Code).Should(Equal(http.StatusGatewayTimeout))
+	})
+
+	It("stays 500 for internal errors", func() {
+		rec := ask(&main.Server{Model: &fakeModel{err: errors.New("bug")}})
+		Expect(rec.Code).Should(Equal(http.StatusInternalServerError))
+	})
+})
+
+var _ = Describe("error responses", func() {
+	table := `"table": {"Appliance": ["TV"]}`
+
+	send := func(server *main.Server, method, path, body string) (int, main.ErrorDetail) {
+		server.Token = "token"
+		server.Retry = &main.RetryPolicy{MaxAttempts: 1}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Request-ID", "trace-1234")
+		server.Router().ServeHTTP(rec, req)
+
+		var decoded map[string]json.RawMessage
+		Expect(json.Unmarshal(rec.Body.Bytes(), &decoded)).Should(Succeed())
+		Expect(decoded).Should(HaveLen(1))
+		var detail main.ErrorDetail
+		Expect(json.Unmarshal(decoded["error"], &detail)).Should(Succeed())
+		Expect(detail.RequestID).Should(Equal("trace-1234"))
+		Expect(detail.Message).ShouldNot(BeEmpty())
+		return rec.Code, detail
+	}
+
+	It("reports invalid queries as invalid_query", func() {
+		code, detail := send(&main.Server{Model: &fakeModel{}}, http.MethodPost, "/ask-json", `{`+table+`, "query": "  "}`)
+		Expect(code).Should(Equal(http.StatusBadRequest))
+		Expect(detail.Code).Should(Equal(main.CodeInvalidQuery))
+		Expect(detail.Field).Should(Equal("query"))
+	})
+
+	It("reports unparseable CSV as csv_parse_error with its position", func() {
+		code, detail := send(&main.Server{}, http.MethodPost, "/validate-csv", "a,b\n1,2\n3\n")
+		Expect(code).Should(Equal(http.StatusBadRequest))
+		Expect(detail.Code).Should(Equal(main.CodeCSVParse))
+		Expect(detail.Details).Should(HaveKeyWithValue("line", 3.0))
+	})
+
+	It("reports a loading model as model_loading", func() {
+		model := &fakeModel{err: &main.ModelLoadingError{Message: "loading", EstimatedTime: 20}}
+		code, detail := send(&main.Server{Model: model}, http.MethodPost, "/ask-json", `{`+table+`, "query": "Which?"}`)
+		Expect(code).Should(Equal(http.StatusBadGateway))
+		Expect(detail.Code).Should(Equal(main.CodeModelLoading))
+	})
+
+	It("reports a failing model as upstream_unavailable", func() {
+		model := &fakeModel{err: &main.UpstreamError{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}}
+		code, detail := send(&main.Server{Model: model}, http.MethodPost, "/ask-json", `{`+table+`, "query": "Which?"}`)
+		Expect(code).Should(Equal(http.StatusBadGateway))
+		Expect(detail.Code).Should(Equal(main.CodeUpstreamUnavailable))
+	})
+
+	It("reports an open circuit as circuit_open", func() {
+		breaker := main.NewCircuitBreaker(1, time.Hour)
+		breaker.Done(&main.UpstreamError{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"})
+		code, detail := send(&main.Server{Model: &fakeModel{}, Breaker: breaker}, http.MethodPost, "/ask-json", `{`+table+`, "query": "Which?"}`)
+		Expect(code).Should(Equal(http.StatusServiceUnavailable))
+		Expect(detail.Code).Should(Equal(main.CodeCircuitOpen))
+	})
+
+	It("reports throttled clients as rate_limited", func() {
+		server := &main.Server{Model: &fakeModel{}, RateLimiter: main.NewRateLimiter(0.001, 1)}
+		router := server.Router()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(`{`+table+`, "query": "Which?"}`)))
+
+		code, detail := send(server, http.MethodPost, "/ask-json", `{`+table+`, "query": "Which?"}`)
+		Expect(code).Should(Equal(http.StatusTooManyRequests))
+		Expect(detail.Code).Should(Equal(main.CodeRateLimited))
+	})
+
+	It("reports unknown routes as not_found", func() {
+		code, detail := send(&main.Server{}, http.MethodGet, "/nowhere", "")
+		Expect(code).Should(Equal(http.StatusNotFound))
+		Expect(detail.Code).Should(Equal(main.CodeNotFound))
+	})
+})
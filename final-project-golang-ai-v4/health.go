@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleHealthz reports that the process is up. It never touches the
+// filesystem or the inference API.
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz reports whether the server can answer queries: the token must
+// be set unless a custom Model is configured, and the CSV file, if
+// configured, must be readable.
+func (s *Server) handleReadyz(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+
+	if s.Model == nil {
+		if s.Token == "" {
+			checks["token"] = "HUGGINGFACE_TOKEN is not set"
+			ready = false
+		} else {
+			checks["token"] = "ok"
+		}
+	}
+
+	if s.Data != nil {
+		if f, err := os.Open(s.Data.Path); err != nil {
+			checks["data"] = err.Error()
+			ready = false
+		} else {
+			f.Close()
+			checks["data"] = "ok"
+		}
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
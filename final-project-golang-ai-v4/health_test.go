@@ -0,0 +1,57 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("health checks", func() {
+	get := func(server *main.Server, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	It("reports healthy without any configuration", func() {
+		rec := get(&main.Server{}, "/healthz")
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{"status": "ok"}`))
+	})
+
+	It("reports ready when the token is set and the CSV is readable", func() {
+		data, err := main.NewTableCache("data-series.csv")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rec := get(&main.Server{Token: "token", Data: data}, "/readyz")
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("reports unavailable when the token is missing", func() {
+		rec := get(&main.Server{}, "/readyz")
+		Expect(rec.Code).Should(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Body.String()).Should(ContainSubstring("HUGGINGFACE_TOKEN is not set"))
+	})
+
+	It("does not need a token with a custom model", func() {
+		rec := get(&main.Server{Model: &fakeModel{}}, "/readyz")
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("reports unavailable when the CSV is missing", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("a\n1\n"), 0o600)).Should(Succeed())
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(os.Remove(path)).Should(Succeed())
+
+		rec := get(&main.Server{Token: "token", Data: data}, "/readyz")
+		Expect(rec.Code).Should(Equal(http.StatusServiceUnavailable))
+	})
+})
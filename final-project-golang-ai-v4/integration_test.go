@@ -0,0 +1,124 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The end-to-end tests run the server as main does, from a configuration,
+// over real HTTP, against a fake inference API standing in for Hugging Face.
+var _ = Describe("End to end /ask", func() {
+	var (
+		mu       sync.Mutex
+		upstream []*http.Request
+		bodies   []string
+		hf       *httptest.Server
+		cfg      main.Config
+		data     *main.TableCache
+	)
+
+	BeforeEach(func() {
+		upstream, bodies = nil, nil
+		hf = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			upstream = append(upstream, r)
+			bodies = append(bodies, string(body))
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"answer": "SUM > 1.5, 2.5", "coordinates": [[0, 2], [1, 2]], "cells": ["1.5", "2.5"], "aggregator": "SUM"}`))
+		}))
+		DeferCleanup(hf.Close)
+
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Appliance,Room,Energy_Consumption\nTV,Living Room,1.5\nLamp,Living Room,2.5\n"), 0o600)).Should(Succeed())
+		var err error
+		data, err = main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cfg, err = main.LoadConfig(env(map[string]string{
+			"HUGGINGFACE_TOKEN": "hf_integration",
+			"HF_API_BASE":       hf.URL,
+			"DATA_CSV_PATH":     path,
+		}))
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	ask := func(server *main.Server, body string) (*http.Response, map[string]interface{}) {
+		app := httptest.NewServer(server.Router())
+		defer app.Close()
+
+		resp, err := http.Post(app.URL+"/ask", "application/json", strings.NewReader(body))
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		var decoded map[string]interface{}
+		Expect(json.NewDecoder(resp.Body).Decode(&decoded)).Should(Succeed())
+		return resp, decoded
+	}
+
+	It("answers a query about the CSV through the inference API", func() {
+		resp, body := ask(cfg.Server(data, nil), `{"query": "How much energy does the living room use?"}`)
+
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).Should(HavePrefix("application/json"))
+		Expect(body).Should(Equal(map[string]interface{}{
+			"answer":      "SUM > 1.5, 2.5",
+			"coordinates": []interface{}{[]interface{}{0.0, 2.0}, []interface{}{1.0, 2.0}},
+			"cells":       []interface{}{"1.5", "2.5"},
+			"aggregator":  "SUM",
+			"candidates": []interface{}{map[string]interface{}{
+				"answer":      "SUM > 1.5, 2.5",
+				"coordinates": []interface{}{[]interface{}{0.0, 2.0}, []interface{}{1.0, 2.0}},
+				"cells":       []interface{}{"1.5", "2.5"},
+				"aggregator":  "SUM",
+			}},
+		}))
+
+		Expect(upstream).Should(HaveLen(1))
+		Expect(upstream[0].URL.Path).Should(Equal("/models/" + main.DefaultModel))
+		Expect(upstream[0].Header.Get("Authorization")).Should(Equal("Bearer hf_integration"))
+		Expect(bodies[0]).Should(MatchJSON(`{
+			"table": {
+				"Appliance": ["TV", "Lamp"],
+				"Room": ["Living Room", "Living Room"],
+				"Energy_Consumption": ["1.5", "2.5"]
+			},
+			"query": "How much energy does the living room use?"
+		}`))
+	})
+
+	It("reports a missing token without calling the inference API", func() {
+		tokenless, err := main.LoadConfig(env(map[string]string{"HF_API_BASE": hf.URL}))
+		Expect(err).ShouldNot(HaveOccurred())
+		resp, body := ask(tokenless.Server(data, nil), `{"query": "How much energy does the living room use?"}`)
+
+		Expect(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+		Expect(body["error"]).Should(HaveKeyWithValue("code", main.CodeTokenMissing))
+		Expect(body["error"]).Should(HaveKeyWithValue("message", "HUGGINGFACE_TOKEN is not set in the environment"))
+		Expect(body["error"]).Should(HaveKey("request_id"))
+		Expect(upstream).Should(BeEmpty())
+	})
+
+	It("rejects bad queries without calling the inference API", func() {
+		server := cfg.Server(data, nil)
+		for _, query := range []string{`{"query": "   "}`, `{"query": "` + strings.Repeat("x", main.DefaultMaxQueryLength+1) + `"}`, `{"query": 42}`} {
+			resp, body := ask(server, query)
+
+			Expect(resp.StatusCode).Should(Equal(http.StatusBadRequest), query)
+			Expect(body["error"]).Should(HaveKeyWithValue("field", "query"), query)
+		}
+		Expect(upstream).Should(BeEmpty())
+	})
+})
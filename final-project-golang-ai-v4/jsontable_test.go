@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONToTable", func() {
+	It("converts uniform objects like CsvToSlice", func() {
+		table, headers, err := main.JSONToTable([]byte(`[
+			{"Room": "Kitchen", "Appliance": "Fridge", "Kwh": 1.5},
+			{"Room": "Bedroom", "Appliance": "Lamp", "Kwh": 0.2}
+		]`))
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers).Should(Equal([]string{"Room", "Appliance", "Kwh"}))
+
+		fromCsv, err := main.CsvToSlice("Room,Appliance,Kwh\nKitchen,Fridge,1.5\nBedroom,Lamp,0.2\n")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table).Should(Equal(fromCsv))
+	})
+
+	It("fills keys missing from heterogeneous objects with empty cells", func() {
+		table, headers, err := main.JSONToTable([]byte(`[
+			{"Room": "Kitchen", "On": true},
+			{"Appliance": "Lamp", "Room": "Bedroom"},
+			{"Room": null}
+		]`))
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers).Should(Equal([]string{"Room", "On", "Appliance"}))
+		Expect(table).Should(Equal(map[string][]string{
+			"Room":      {"Kitchen", "Bedroom", ""},
+			"On":        {"true", "", ""},
+			"Appliance": {"", "Lamp", ""},
+		}))
+	})
+
+	It("rejects anything but a non-empty array of flat objects", func() {
+		for _, data := range []string{
+			`{"Room": "Kitchen"}`,
+			`[]`,
+			`["Kitchen"]`,
+			`[{"Room": {"Name": "Kitchen"}}]`,
+			`[{"Rooms": ["Kitchen"]}]`,
+			`[{"Room": "Kitchen"}] []`,
+			`[{"Room": "Kitchen"}`,
+		} {
+			_, _, err := main.JSONToTable([]byte(data))
+			Expect(err).Should(HaveOccurred(), data)
+		}
+	})
+
+	It("is accepted by /ask-upload as application/json", func() {
+		model := &fakeModel{response: main.Response{Answer: "Lamp"}}
+		server := &main.Server{Model: model}
+
+		req := newMultipartRequest("/ask-upload", "application/json", `[{"Appliance": "Lamp", "Room": "Bedroom"}]`, map[string]string{"query": "Where is the lamp?"})
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(model.received).Should(HaveLen(1))
+		Expect(model.received[0].Table).Should(Equal(map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}}))
+	})
+})
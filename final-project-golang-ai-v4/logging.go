@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"a21hc3NpZ25tZW50/tableqa"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewLogger returns a JSON logger writing to w at the given level, one of
+// "debug", "info" (the default when empty), "warn" or "error".
+func NewLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", level)
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})), nil
+}
+
+// maxRequestIDLen bounds the client-provided request IDs that are accepted.
+const maxRequestIDLen = 128
+
+// validRequestID reports whether a client-provided ID is safe to log and echo:
+// non-empty, not too long and made of printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger gives every request an ID, taken from the X-Request-ID header
+// when the client sent a valid one, and echoes it in the response. It stores
+// a logger carrying that ID in the request context and logs the request once
+// it completes.
+func requestLogger(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		logger := base.With("request_id", id)
+
+		ctx := tableqa.ContextWithRequestID(c.Request.Context(), id)
+		ctx = tableqa.ContextWithLogger(ctx, logger)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		logger.Info("request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
@@ -0,0 +1,175 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func parseLogLines(buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		Expect(json.Unmarshal([]byte(line), &entry)).Should(Succeed(), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func findLog(lines []map[string]interface{}, msg string) map[string]interface{} {
+	for _, line := range lines {
+		if line["msg"] == msg {
+			return line
+		}
+	}
+	return nil
+}
+
+var _ = Describe("logging", func() {
+	It("parses LOG_LEVEL values", func() {
+		for _, level := range []string{"", "debug", "INFO", "warn", "error"} {
+			_, err := main.NewLogger(&bytes.Buffer{}, level)
+			Expect(err).ShouldNot(HaveOccurred(), level)
+		}
+		_, err := main.NewLogger(&bytes.Buffer{}, "verbose")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("filters entries below the configured level", func() {
+		buf := &bytes.Buffer{}
+		logger, err := main.NewLogger(buf, "warn")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		logger.Info("hidden")
+		logger.Warn("shown")
+		Expect(buf.String()).ShouldNot(ContainSubstring("hidden"))
+		Expect(buf.String()).Should(ContainSubstring("shown"))
+	})
+
+	Describe("request logs", func() {
+		var (
+			buf    *bytes.Buffer
+			model  *httptest.Server
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+			logger, err := main.NewLogger(buf, "info")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			model = newModelServer(`{"answer": "TV"}`, nil)
+			server = &main.Server{Connector: newServerConnector(model), Token: "token", Logger: logger}
+		})
+
+		AfterEach(func() {
+			model.Close()
+		})
+
+		ask := func() {
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?", "model": "google/tapas-large-finetuned-wtq"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+		}
+
+		It("logs the query, model and upstream call under one request ID", func() {
+			ask()
+			lines := parseLogLines(buf)
+
+			request := findLog(lines, "request")
+			Expect(request).ShouldNot(BeNil())
+			Expect(request["request_id"]).ShouldNot(BeEmpty())
+			Expect(request["status"]).Should(BeNumerically("==", 200))
+
+			answered := findLog(lines, "answered query")
+			Expect(answered).ShouldNot(BeNil())
+			Expect(answered["request_id"]).Should(Equal(request["request_id"]))
+			Expect(answered["query"]).Should(Equal("Which appliance?"))
+			Expect(answered["model"]).Should(Equal("google/tapas-large-finetuned-wtq"))
+
+			upstream := findLog(lines, "upstream call")
+			Expect(upstream).ShouldNot(BeNil())
+			Expect(upstream["request_id"]).Should(Equal(request["request_id"]))
+			Expect(upstream["status"]).Should(BeNumerically("==", 200))
+			Expect(upstream).Should(HaveKey("latency_ms"))
+		})
+
+		It("redacts queries when asked to", func() {
+			server.RedactQueries = true
+			ask()
+
+			Expect(buf.String()).ShouldNot(ContainSubstring("Which appliance?"))
+			Expect(findLog(parseLogLines(buf), "answered query")["query"]).Should(Equal("[redacted]"))
+		})
+	})
+})
+
+var _ = Describe("request IDs", func() {
+	var (
+		received http.Header
+		server   *main.Server
+	)
+
+	BeforeEach(func() {
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		DeferCleanup(model.Close)
+		logger, err := main.NewLogger(&bytes.Buffer{}, "info")
+		Expect(err).ShouldNot(HaveOccurred())
+		server = &main.Server{Connector: newServerConnector(model), Token: "token", Logger: logger}
+	})
+
+	ask := func(body, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body))
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+		return rec
+	}
+	valid := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+
+	It("echoes the client's ID", func() {
+		rec := ask(valid, "trace-1234")
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Header().Get("X-Request-ID")).Should(Equal("trace-1234"))
+	})
+
+	It("generates an ID when none or an invalid one is sent", func() {
+		for _, id := range []string{"", "has spaces", strings.Repeat("x", 200)} {
+			rec := ask(valid, id)
+			Expect(rec.Header().Get("X-Request-ID")).ShouldNot(BeEmpty())
+			Expect(rec.Header().Get("X-Request-ID")).ShouldNot(Equal(id))
+		}
+		Expect(ask(valid, "").Header().Get("X-Request-ID")).ShouldNot(Equal(ask(valid, "").Header().Get("X-Request-ID")))
+	})
+
+	It("includes the ID in error responses", func() {
+		rec := ask(`{"table": {}, "query": "Which appliance?"}`, "trace-1234")
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(MatchJSON(`{"error": {"code": "invalid_table", "message": "table must have at least one column", "request_id": "trace-1234"}}`))
+	})
+
+	It("forwards the ID upstream only when enabled", func() {
+		ask(valid, "trace-1234")
+		Expect(received).ShouldNot(HaveKey("X-Request-Id"))
+
+		server.Connector.ForwardRequestID = true
+		ask(valid, "trace-1234")
+		Expect(received.Get("X-Request-ID")).Should(Equal("trace-1234"))
+	})
+})
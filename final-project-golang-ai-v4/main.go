@@ -1,179 +1,127 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"a21hc3NpZ25tZW50/tableqa"
 
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
-type AIModelConnector struct {
-	Client *http.Client
-}
-
-type Inputs struct {
-	Table map[string][]string `json:"table"`
-	Query string              `json:"query"`
-}
-
-type Response struct {
-	Answer      string   `json:"answer"`
-	Coordinates [][]int  `json:"coordinates"`
-	Cells       []string `json:"cells"`
-	Aggregator  string   `json:"aggregator"`
-}
-
-func CsvToSlice(data string) (map[string][]string, error) {
-	r := csv.NewReader(strings.NewReader(data))
-	r.TrimLeadingSpace = true
-
-	records, err := r.ReadAll()
-	if err != nil {
-		return nil, err
+// LoadEnv loads variables from the given .env files (".env" by default) into
+// the process environment. A missing file is not an error, since deployments
+// often configure the real environment instead; a file that cannot be parsed
+// is.
+func LoadEnv(filenames ...string) error {
+	err := godotenv.Load(filenames...)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No .env file found, using the process environment")
+		return nil
 	}
-
-	if len(records) < 2 {
-		return nil, errors.New("CSV file must contain at least one row of data")
+	if err != nil {
+		return fmt.Errorf("error loading .env file: %v", err)
 	}
+	return nil
+}
 
-	headers := records[0]
-	result := make(map[string][]string)
-	for _, header := range headers {
-		result[header] = []string{}
-	}
+// warmUp runs server.Warmup, logging the outcome; a failed warmup only
+// means the first request is slow.
+func warmUp(ctx context.Context, server *Server, logger *slog.Logger, token string) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultWarmupTimeout)
+	defer cancel()
 
-	for _, row := range records[1:] {
-		for i, value := range row {
-			result[headers[i]] = append(result[headers[i]], value)
-		}
+	start := time.Now()
+	if err := server.Warmup(ctx); err != nil {
+		logger.Warn("model warmup failed", "error", tableqa.RedactToken(err.Error(), token), "elapsed_ms", time.Since(start).Milliseconds())
+		return
 	}
-
-	return result, nil
+	logger.Info("model warmed up", "elapsed_ms", time.Since(start).Milliseconds())
 }
 
-func (c *AIModelConnector) ConnectAIModel(payload interface{}, token string) (Response, error) {
-	url := "https://api-inference.huggingface.co/models/google/tapas-base-finetuned-wtq"
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return Response{}, err
+func main() {
+	if err := LoadEnv(); err != nil {
+		log.Fatal(err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+	cfg, err := LoadConfig(os.Getenv)
 	if err != nil {
-		return Response{}, err
+		log.Fatal(err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.Client.Do(req)
+	logger, err := NewLogger(os.Stderr, cfg.LogLevel)
 	if err != nil {
-		return Response{}, err
+		log.Fatal(err)
 	}
-	defer resp.Body.Close()
+	slog.SetDefault(logger)
 
-	if resp.StatusCode != http.StatusOK {
-		return Response{}, fmt.Errorf("failed to get valid response: %d %s", resp.StatusCode, resp.Status)
+	if cfg.Mock {
+		log.Printf("WARNING: MOCK_AI is set; answers are canned and the model is never called")
+	} else if err := ValidateToken(cfg.Token); err != nil {
+		log.Printf("WARNING: %v; requests to the model will probably fail", err)
 	}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return Response{}, err
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(RunQuery(os.Args[2:], cfg.Connector(), cfg.Token, os.Stdin, os.Stdout, os.Stderr))
 	}
 
-	var response Response
-	err = json.NewDecoder(bytes.NewReader(respBody)).Decode(&response)
-	if err != nil {
-		return Response{}, err
+	if err := cfg.CheckFiles(); err != nil {
+		log.Fatal(err)
 	}
-
-	return response, nil
-}
-
-func loadEnv() {
-	err := godotenv.Load()
+	data, err := NewTableCache(cfg.DataPath)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Error loading %s: %v", cfg.DataPath, err)
 	}
-}
 
-func main() {
-	loadEnv()
-	router := gin.Default()
-
-	// Serve the HTML file at the root route
-	router.LoadHTMLFiles("index.html")
-
-	router.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", nil)
-	})
-
-	router.POST("/ask", func(c *gin.Context) {
-		// Load CSV data
-		data, err := os.Open("data-series.csv")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error reading CSV file: %v", err)})
-			return
+	var tables *TableRegistry
+	if cfg.TablesDir != "" {
+		if tables, err = LoadTableDir(cfg.TablesDir); err != nil {
+			log.Fatal(err)
 		}
-		defer data.Close()
+	}
 
-		rowData, err := ioutil.ReadAll(data)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error reading CSV file: %v", err)})
-			return
-		}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 
-		// Convert CSV to slice
-		table, err := CsvToSlice(string(rowData))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error converting CSV to slice: %v", err)})
-			return
-		}
+	server := cfg.Server(data, tables)
+	server.Logger = logger
+	server.Metrics = NewMetrics(registry)
 
-		// Get query from request body
-		var jsonData struct {
-			Query string `json:"query"`
-		}
-		if err := c.BindJSON(&jsonData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-
-		// Prepare payload
-		payload := Inputs{
-			Table: table,
-			Query: jsonData.Query,
-		}
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		// Initialize AI model connector
-		client := &http.Client{}
-		connector := &AIModelConnector{Client: client}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Connect to AI model
-		token := os.Getenv("HUGGINGFACE_TOKEN")
-		if token == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "HUGGINGFACE_TOKEN is not set in the environment"})
-			return
-		}
+	if cfg.Warmup {
+		go warmUp(ctx, server, logger, cfg.Token)
+	}
 
-		response, err := connector.ConnectAIModel(payload, token)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error connecting to AI model: %v", err)})
-			return
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		if cfg.DataRefreshInterval > 0 {
+			data.Refresh(ctx, cfg.DataRefreshInterval, logger)
 		}
+	}()
 
-		// Send response back to front-end
-		c.JSON(http.StatusOK, response)
-	})
-
-	router.Run(":8080")
+	if err := Serve(ctx, &http.Server{Handler: server.Router()}, listener, cfg.ShutdownGracePeriod); err != nil {
+		log.Fatal(err)
+	}
+	stop()
+	<-refreshed
 }
@@ -2,9 +2,25 @@ package main_test
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
 
 	main "a21hc3NpZ25tZW50"
 
@@ -55,6 +71,301 @@ value3,value4`
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(result).Should(Equal(expected))
 		})
+
+		It("returns the headers in input order", func() {
+			data := "Room,Appliance,Status,Date\nKitchen,Refrigerator,On,2022-01-01"
+
+			result, headers, err := main.CsvToSliceOrdered(data)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(headers).Should(Equal([]string{"Room", "Appliance", "Status", "Date"}))
+			Expect(result).Should(HaveLen(4))
+			Expect(result["Appliance"]).Should(Equal([]string{"Refrigerator"}))
+		})
+
+		It("preserves quoted commas, escaped quotes and embedded newlines", func() {
+			data := "name,address,note\n" +
+				"\"Smith, John\",\"12 Main St\nApt 4\",\"said \"\"hi\"\"\"\n" +
+				"Doe, \" padded\",\"\""
+
+			result, err := main.CsvToSlice(data)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{
+				"name":    {"Smith, John", "Doe"},
+				"address": {"12 Main St\nApt 4", " padded"},
+				"note":    {`said "hi"`, ""},
+			}))
+		})
+
+		It("counts a quoted multi-line field as one field when checking row length", func() {
+			data := "a,b\n\"x\ny\",1\n\"p,q\",2,3"
+
+			_, err := main.CsvToSlice(data)
+			Expect(err).Should(MatchError(`line 4: row 3 has 3 fields, expected 2: "\"p,q\",2,3"`))
+		})
+
+		It("rejects rows with too many fields", func() {
+			data := "a,b,c,d\n1,2,3,4\n1,2,3,4,5"
+
+			_, err := main.CsvToSlice(data)
+			Expect(err).Should(MatchError(`line 3: row 3 has 5 fields, expected 4: "1,2,3,4,5"`))
+		})
+
+		It("rejects rows with too few fields", func() {
+			data := "a,b,c,d\n1,2,3"
+
+			_, err := main.CsvToSlice(data)
+			Expect(err).Should(MatchError(`line 2: row 2 has 3 fields, expected 4: "1,2,3"`))
+		})
+
+		It("drops trailing commas in the header and data rows", func() {
+			table, headers, err := main.CsvToSliceOrdered("a,b,c,\n1,2,3,\n4,5,6\n7,8,9,,\n")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(headers).Should(Equal([]string{"a", "b", "c"}))
+			Expect(table).Should(Equal(map[string][]string{"a": {"1", "4", "7"}, "b": {"2", "5", "8"}, "c": {"3", "6", "9"}}))
+
+			table, err = main.CsvToSlice("a,b\n1,\n2, \n")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(table).Should(Equal(map[string][]string{"a": {"1", "2"}, "b": {"", ""}}))
+		})
+
+		It("rejects values past the last named column", func() {
+			_, err := main.CsvToSlice("a,b,\n1,2,3\n")
+			Expect(err).Should(MatchError(`line 2: row 2 has 3 fields, expected 2: "1,2,3"`))
+
+			_, err = main.CsvToSlice(",,\n1,2\n")
+			Expect(err).Should(MatchError("CSV header row has no column names"))
+		})
+
+		It("rejects empty-named columns before the last named one", func() {
+			_, err := main.CsvToSlice("a,,c\n1,2,3\n")
+			Expect(err).Should(MatchError("CSV header row has no name for column 2"))
+
+			_, err = main.CsvToSlice(" ,a\n1,2\n")
+			Expect(err).Should(MatchError("CSV header row has no name for column 1"))
+		})
+
+		It("encodes tables back into CSV that parses into them", func() {
+			for _, data := range []string{
+				"Date,Appliance,Energy_Consumption\n2022-01-01,TV,0.8\n2022-01-02,Lamp,0.2\n",
+				"name,note\n\"Smith, John\",\"said \"\"hi\"\"\"\n\"two\nlines\",\"\"\n",
+				"a,b,c\n \" padded\",,\"\"\n",
+				"room\nKitchen\n\"\"\nBedroom\n",
+				"\"\ufeffid\",name\n1,Küche\n",
+			} {
+				table, headers, err := main.CsvToSliceOrdered(data)
+				Expect(err).ShouldNot(HaveOccurred(), data)
+
+				encoded, err := main.SliceToCsv(table, headers)
+				Expect(err).ShouldNot(HaveOccurred(), data)
+				decoded, decodedHeaders, err := main.CsvToSliceOrdered(encoded)
+				Expect(err).ShouldNot(HaveOccurred(), encoded)
+				Expect(decodedHeaders).Should(Equal(headers), encoded)
+				Expect(decoded).Should(Equal(table), encoded)
+			}
+		})
+
+		It("encodes columns in the given order, or sorted", func() {
+			table := map[string][]string{"b": {"1", "2"}, "a": {"x,y", "z"}}
+			Expect(main.SliceToCsv(table, []string{"b", "a"})).Should(Equal("b,a\n1,\"x,y\"\n2,z\n"))
+			Expect(main.SliceToCsv(table, nil)).Should(Equal("a,b\n\"x,y\",1\nz,2\n"))
+		})
+
+		It("refuses to encode misaligned tables or mismatched headers", func() {
+			_, err := main.SliceToCsv(map[string][]string{"a": {"1", "2"}, "b": {"1"}}, nil)
+			var lengthErr *main.ColumnLengthError
+			Expect(errors.As(err, &lengthErr)).Should(BeTrue())
+
+			table := map[string][]string{"a": {"1"}, "b": {"2"}}
+			Expect(main.SliceToCsv(table, []string{"a"})).Error().Should(MatchError(`column "b" is missing from the headers`))
+			Expect(main.SliceToCsv(table, []string{"a", "b", "c"})).Error().Should(MatchError(`column "c" does not exist`))
+			Expect(main.SliceToCsv(table, []string{"a", "a", "b"})).Error().Should(MatchError(`column "a" is listed twice`))
+			Expect(main.SliceToCsv(map[string][]string{}, nil)).Error().Should(HaveOccurred())
+		})
+
+		It("locates field count errors in the input", func() {
+			data := "Date,Appliance,Energy_Consumption\n2022-01-01,TV,0.8\n2022-01-01,Lamp\n"
+
+			_, err := main.CsvToSlice(data)
+			Expect(errors.Is(err, csv.ErrFieldCount)).Should(BeTrue())
+			var lineErr *main.CsvLineError
+			Expect(errors.As(err, &lineErr)).Should(BeTrue())
+			Expect(lineErr.Line).Should(Equal(3))
+			Expect(lineErr.Snippet).Should(Equal("2022-01-01,Lamp"))
+			var countErr *main.FieldCountError
+			Expect(errors.As(err, &countErr)).Should(BeTrue())
+			Expect(*countErr).Should(Equal(main.FieldCountError{Row: 3, Expected: 3, Actual: 2}))
+		})
+
+		It("quotes the line of a parse error", func() {
+			_, err := main.CsvToSlice("a,b\n1,2\r\nx\"y,3\n")
+			Expect(err).Should(MatchError(`line 3, column 2: bare " in non-quoted-field: "x\"y,3"`))
+			Expect(errors.Is(err, csv.ErrBareQuote)).Should(BeTrue())
+		})
+
+		It("cuts long lines in error snippets", func() {
+			_, err := main.CsvToSlice("a,b\n1," + strings.Repeat("x", 200) + ",3\n")
+			var lineErr *main.CsvLineError
+			Expect(errors.As(err, &lineErr)).Should(BeTrue())
+			Expect(lineErr.Snippet).Should(Equal("1," + strings.Repeat("x", 78) + "..."))
+		})
+
+		It("parses tab- and semicolon-delimited data", func() {
+			expected := map[string][]string{
+				"header1": {"value1", "value3"},
+				"header2": {"value2", "value4"},
+			}
+
+			result, err := main.CsvToSliceWithOptions("header1\theader2\nvalue1\tvalue2\nvalue3\tvalue4", main.CsvOptions{Delimiter: '\t'})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(expected))
+
+			result, err = main.CsvToSliceWithOptions("header1;header2\nvalue1;value2\nvalue3;value4", main.CsvOptions{Delimiter: ';'})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(expected))
+		})
+
+		It("rejects duplicate headers", func() {
+			_, err := main.CsvToSlice("id,price,name,price\n1,2,3,4")
+			Expect(err).Should(MatchError(`duplicate column "price" at positions 2 and 4`))
+		})
+
+		It("renames duplicate headers when asked to", func() {
+			result, err := main.CsvToSliceWithOptions("price,name,price,price\n1,2,3,4", main.CsvOptions{RenameDuplicates: true})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{
+				"price":   {"1"},
+				"name":    {"2"},
+				"price_2": {"3"},
+				"price_3": {"4"},
+			}))
+		})
+
+		It("strips a leading UTF-8 BOM from the first header", func() {
+			result, err := main.CsvToSlice("\ufeffid,name\n1,lamp")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{
+				"id":   {"1"},
+				"name": {"lamp"},
+			}))
+		})
+
+		It("transcodes latin1 input to UTF-8", func() {
+			result, err := main.CsvToSliceWithOptions("room,name\nK\xfcche,Caf\xe9", main.CsvOptions{Encoding: "latin1"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{
+				"room": {"Küche"},
+				"name": {"Café"},
+			}))
+
+			_, err = main.CsvToSliceWithOptions("a\n1", main.CsvOptions{Encoding: "ebcdic"})
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("tells empty input from a header without rows", func() {
+			for _, data := range []string{"", "\n\r\n", "\ufeff"} {
+				_, err := main.CsvToSlice(data)
+				Expect(err).Should(MatchError(main.ErrEmptyCSV), "%q", data)
+			}
+			_, err := main.CsvToSliceWithOptions("# just a comment\n", main.CsvOptions{Comment: '#'})
+			Expect(err).Should(MatchError(main.ErrEmptyCSV))
+
+			for _, data := range []string{"id,name", "id,name\n", "id,name\n\n\n"} {
+				_, err := main.CsvToSlice(data)
+				Expect(err).Should(MatchError(main.ErrNoDataRows), "%q", data)
+			}
+
+			result, err := main.CsvToSlice("id,name\n1,lamp\n")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{"id": {"1"}, "name": {"lamp"}}))
+		})
+
+		It("ignores empty lines before the header by default", func() {
+			result, err := main.CsvToSlice("\n\r\nid,name\n1,lamp")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{"id": {"1"}, "name": {"lamp"}}))
+		})
+
+		It("skips leading title lines", func() {
+			data := "Energy report, March\nExported 2024-03-31\nid,name\n1,lamp\n2,tv,extra"
+			_, err := main.CsvToSliceWithOptions(data, main.CsvOptions{SkipLines: 2})
+			var lineErr *main.CsvLineError
+			Expect(errors.As(err, &lineErr)).Should(BeTrue())
+			Expect(lineErr.Line).Should(Equal(5))
+			Expect(lineErr.Snippet).Should(Equal("2,tv,extra"))
+
+			result, err := main.CsvToSliceWithOptions(strings.TrimSuffix(data, ",extra"), main.CsvOptions{SkipLines: 2})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{"id": {"1", "2"}, "name": {"lamp", "tv"}}))
+
+			_, err = main.CsvToSliceWithOptions("a\n1", main.CsvOptions{SkipLines: 5})
+			Expect(err).Should(MatchError(main.ErrEmptyCSV))
+			_, err = main.CsvToSliceWithOptions("a\n1", main.CsvOptions{SkipLines: -1})
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("skips comment lines", func() {
+			data := "# exported by the meter\nid,name\n# first row\n1,lamp"
+			result, err := main.CsvToSliceWithOptions(data, main.CsvOptions{Comment: '#'})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{"id": {"1"}, "name": {"lamp"}}))
+
+			_, err = main.CsvToSliceWithOptions(data, main.CsvOptions{Comment: ','})
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("skips blank records before the header when asked to", func() {
+			data := ",,\n  , ,\nid,name,room\n1,lamp,\n"
+			result, err := main.CsvToSliceWithOptions(data, main.CsvOptions{SkipBlankLines: true})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(result).Should(Equal(map[string][]string{"id": {"1"}, "name": {"lamp"}, "room": {""}}))
+
+			_, err = main.CsvToSlice(data)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("parses CSV from a reader as it is read", func() {
+			data := "\ufeff# meter export\nid,name\n1,\"lamp,\nshade\"\n2,tv\n"
+			table, headers, err := main.CsvToSliceFromReader(iotest.OneByteReader(strings.NewReader(data)), main.CsvOptions{Comment: '#'})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(headers).Should(Equal([]string{"id", "name"}))
+			Expect(table).Should(Equal(map[string][]string{"id": {"1", "2"}, "name": {"lamp,\nshade", "tv"}}))
+
+			table, _, err = main.CsvToSliceFromReader(iotest.HalfReader(strings.NewReader("room\nK\xfcche\n")), main.CsvOptions{Encoding: "latin1"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(table).Should(Equal(map[string][]string{"room": {"Küche"}}))
+		})
+
+		It("quotes the parsed row in reader errors", func() {
+			_, _, err := main.CsvToSliceFromReader(strings.NewReader("title\na,b\n1,2\n3, 4 ,5\n"), main.CsvOptions{SkipLines: 1})
+			Expect(err).Should(MatchError(`line 4: row 3 has 3 fields, expected 2: "3,4 ,5"`))
+			Expect(errors.Is(err, csv.ErrFieldCount)).Should(BeTrue())
+
+			_, _, err = main.CsvToSliceFromReader(strings.NewReader("a,b\n1,x\"y\n"), main.CsvOptions{})
+			var lineErr *main.CsvLineError
+			Expect(errors.As(err, &lineErr)).Should(BeTrue())
+			Expect(lineErr.Line).Should(Equal(2))
+
+			_, _, err = main.CsvToSliceFromReader(iotest.ErrReader(errors.New("disk on fire")), main.CsvOptions{})
+			Expect(err).Should(MatchError("disk on fire"))
+		})
+
+		It("tells text from binary data", func() {
+			Expect(main.LooksLikeText([]byte("Date,Appliance\r\n2022-01-01,\"TV\tset\"\n"))).Should(BeTrue())
+			Expect(main.LooksLikeText([]byte("room\nK\xfcche\n"))).Should(BeTrue())
+			Expect(main.LooksLikeText(nil)).Should(BeTrue())
+
+			Expect(main.LooksLikeText([]byte("a,b\n1,\x002\n"))).Should(BeFalse())
+			Expect(main.LooksLikeText([]byte("\x01\x02\x03abcdefg\x1b"))).Should(BeFalse())
+			// only the start is inspected
+			Expect(main.LooksLikeText([]byte(strings.Repeat("a,b\n", 4096) + "\x00"))).Should(BeTrue())
+		})
+
+		It("rejects newline and quote delimiters", func() {
+			for _, delimiter := range []rune{'\n', '\r', '"'} {
+				_, err := main.CsvToSliceWithOptions("a,b\n1,2", main.CsvOptions{Delimiter: delimiter})
+				Expect(err).Should(HaveOccurred())
+			}
+		})
 	})
 
 	Describe("connectAIModel", func() {
@@ -155,3 +466,456 @@ value3,value4`
 		})
 	})
 })
+
+var _ = Describe("AIModelConnector model", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	newRecordingConnector := func(model string, path *string) *main.AIModelConnector {
+		return &main.AIModelConnector{
+			Model: model,
+			Client: &http.Client{
+				Transport: &MockClient{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						*path = req.URL.Path
+						return &http.Response{
+							StatusCode: 200,
+							Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"answer": "value1"}`))),
+						}, nil
+					},
+				},
+			},
+		}
+	}
+
+	It("defaults to tapas-base-finetuned-wtq", func() {
+		var path string
+		_, err := newRecordingConnector("", &path).ConnectAIModel(payload, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(path).Should(Equal("/models/google/tapas-base-finetuned-wtq"))
+	})
+
+	It("uses the configured model", func() {
+		var path string
+		_, err := newRecordingConnector("google/tapas-large-finetuned-wtq", &path).ConnectAIModel(payload, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(path).Should(Equal("/models/google/tapas-large-finetuned-wtq"))
+	})
+
+	It("rejects models that would break the URL path", func() {
+		for _, model := range []string{"../admin", "google/tapas?x=1", "a/b/c", "google/tapas base", "/tapas"} {
+			var path string
+			_, err := newRecordingConnector(model, &path).ConnectAIModel(payload, "token")
+			Expect(err).Should(HaveOccurred(), model)
+			Expect(path).Should(BeEmpty())
+		}
+	})
+
+	It("rejects a table with misaligned columns without calling the model", func() {
+		unaligned := main.Inputs{
+			Table: map[string][]string{
+				"Appliance": {"TV", "Fridge", "Lamp"},
+				"Room":      {"Living Room", "Kitchen", "Bedroom"},
+				"Energy":    {"1.2"},
+			},
+			Query: "Which appliance uses the most energy?",
+		}
+
+		for _, payload := range []interface{}{unaligned, &unaligned, main.SequentialInputs{Table: unaligned.Table, Queries: []string{"q"}}} {
+			var path string
+			_, err := newRecordingConnector("", &path).ConnectAIModel(payload, "token")
+
+			var lengths *main.ColumnLengthError
+			Expect(errors.As(err, &lengths)).Should(BeTrue())
+			Expect(lengths.Columns).Should(Equal(map[string]int{"Energy": 1}))
+			Expect(err).Should(MatchError("table columns have different lengths: expected 3 rows but Energy has 1"))
+			Expect(path).Should(BeEmpty())
+		}
+	})
+})
+
+var _ = Describe("AIModelConnector fallbacks", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	// newFallbackConnector answers with the status statuses gives the
+	// requested model, 200 when it has none, and records the models called.
+	newFallbackConnector := func(statuses map[string]int, called *[]string) *main.AIModelConnector {
+		return &main.AIModelConnector{
+			Model:     "org/primary",
+			Fallbacks: []string{"org/secondary", "org/tertiary"},
+			Client: &http.Client{
+				Transport: &MockClient{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						model := strings.TrimPrefix(req.URL.Path, "/models/")
+						*called = append(*called, model)
+						status, ok := statuses[model]
+						if !ok {
+							status = http.StatusOK
+						}
+						return &http.Response{
+							StatusCode: status,
+							Status:     http.StatusText(status),
+							Body:       ioutil.NopCloser(strings.NewReader(`{"answer": "value1"}`)),
+						}, nil
+					},
+				},
+			},
+		}
+	}
+
+	It("tries the next model when one is unavailable", func() {
+		var called []string
+		connector := newFallbackConnector(map[string]int{"org/primary": http.StatusServiceUnavailable}, &called)
+
+		result, err := connector.ConnectAIModel(payload, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result.Answer).Should(Equal("value1"))
+		Expect(result.Model).Should(Equal("org/secondary"))
+		Expect(called).Should(Equal([]string{"org/primary", "org/secondary"}))
+	})
+
+	It("records the primary model when it answers", func() {
+		var called []string
+		result, err := newFallbackConnector(nil, &called).ConnectAIModel(payload, "token")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(result.Model).Should(Equal("org/primary"))
+		Expect(called).Should(Equal([]string{"org/primary"}))
+	})
+
+	It("does not fall back on client errors", func() {
+		var called []string
+		connector := newFallbackConnector(map[string]int{"org/primary": http.StatusBadRequest}, &called)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+		var upstream *main.UpstreamError
+		Expect(errors.As(err, &upstream)).Should(BeTrue())
+		Expect(upstream.StatusCode).Should(Equal(http.StatusBadRequest))
+		Expect(called).Should(Equal([]string{"org/primary"}))
+	})
+
+	It("returns the last error when every model is unavailable", func() {
+		var called []string
+		connector := newFallbackConnector(map[string]int{
+			"org/primary":   http.StatusServiceUnavailable,
+			"org/secondary": http.StatusBadGateway,
+			"org/tertiary":  http.StatusInternalServerError,
+		}, &called)
+
+		_, err := connector.ConnectAIModel(payload, "token")
+		var upstream *main.UpstreamError
+		Expect(errors.As(err, &upstream)).Should(BeTrue())
+		Expect(upstream.StatusCode).Should(Equal(http.StatusInternalServerError))
+		Expect(called).Should(Equal([]string{"org/primary", "org/secondary", "org/tertiary"}))
+	})
+})
+
+var _ = Describe("AIModelConnector base URL", func() {
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+
+	It("calls the model on the configured base", func() {
+		var path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Write([]byte(`{"answer": "1"}`))
+		}))
+		defer server.Close()
+
+		connector := &main.AIModelConnector{Client: server.Client(), BaseURL: server.URL + "/proxy/"}
+		response, err := connector.ConnectAIModel(payload, "token")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.Answer).Should(Equal("1"))
+		Expect(path).Should(Equal("/proxy/models/google/tapas-base-finetuned-wtq"))
+	})
+
+	It("validates the base", func() {
+		for _, base := range []string{main.DefaultAPIBase, "http://localhost:8080", "https://proxy.internal/hf"} {
+			Expect(main.ValidateAPIBase(base)).Should(Succeed(), base)
+		}
+		for _, base := range []string{"api-inference.huggingface.co", "ftp://host", "http://", "https://host/?q=1", "://bad"} {
+			Expect(main.ValidateAPIBase(base)).ShouldNot(Succeed(), base)
+		}
+	})
+})
+
+var _ = Describe("Inputs", func() {
+	table := map[string][]string{"Room": {"Kitchen"}, "Appliance": {"Fridge"}, "Energy_Consumption": {"1.2"}}
+
+	It("marshals the same table to identical bytes", func() {
+		first, err := json.Marshal(main.Inputs{Table: table, Query: "q"})
+		Expect(err).ShouldNot(HaveOccurred())
+		for i := 0; i < 20; i++ {
+			again, err := json.Marshal(main.Inputs{Table: table, Query: "q"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(again).Should(Equal(first))
+		}
+		Expect(string(first)).Should(Equal(`{"table":{"Appliance":["Fridge"],"Energy_Consumption":["1.2"],"Room":["Kitchen"]},"query":"q"}`))
+	})
+
+	It("sends the columns in the given order", func() {
+		inputs := main.Inputs{Table: table, Query: "q", Columns: []string{"Room", "Appliance", "Energy_Consumption"}}
+		Expect(inputs.ColumnOrder()).Should(Equal([]string{"Room", "Appliance", "Energy_Consumption"}))
+
+		payload, err := json.Marshal(inputs)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(payload)).Should(Equal(`{"table":{"Room":["Kitchen"],"Appliance":["Fridge"],"Energy_Consumption":["1.2"]},"query":"q"}`))
+	})
+
+	It("falls back to sorted order when Columns does not match the table", func() {
+		for _, columns := range [][]string{{"Room"}, {"Room", "Room", "Appliance"}, {"Room", "Appliance", "Status"}} {
+			inputs := main.Inputs{Table: table, Columns: columns}
+			Expect(inputs.ColumnOrder()).Should(Equal([]string{"Appliance", "Energy_Consumption", "Room"}), "%v", columns)
+		}
+	})
+
+	It("round-trips through encoding/json", func() {
+		payload, err := json.Marshal(main.Inputs{Table: table, Query: "Which <room>?"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var decoded main.Inputs
+		Expect(json.Unmarshal(payload, &decoded)).Should(Succeed())
+		Expect(decoded).Should(Equal(main.Inputs{Table: table, Query: "Which <room>?"}))
+	})
+})
+
+var _ = Describe("NewTransport", func() {
+	It("keeps idle connections to the inference API for reuse", func() {
+		transport := main.NewTransport()
+		Expect(transport.MaxIdleConnsPerHost).Should(BeNumerically(">", http.DefaultMaxIdleConnsPerHost))
+		Expect(transport.IdleConnTimeout).Should(BeNumerically(">", 0))
+		Expect(transport.TLSClientConfig.MinVersion).Should(Equal(uint16(tls.VersionTLS12)))
+		Expect(transport.Proxy).ShouldNot(BeNil())
+
+		connector := main.NewAIModelConnector(time.Second)
+		Expect(connector.Client.Transport).Should(BeAssignableToTypeOf(&http.Transport{}))
+	})
+
+	It("lets one connector serve concurrent calls", func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		defer server.Close()
+		connector := newServerConnector(server)
+		payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				response, err := connector.ConnectAIModel(payload, "token")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.Answer).Should(Equal("TV"))
+			}()
+		}
+		wg.Wait()
+		Expect(atomic.LoadInt32(&calls)).Should(Equal(int32(20)))
+	})
+})
+
+// The tests below are meant to be run with -race as well: they share one
+// connector between many goroutines, with every option that changes the
+// request turned on.
+var _ = Describe("concurrent use", func() {
+	const calls = 50
+
+	// echoServer answers each call with its query, the model from the URL
+	// path and the cache header, so every response can be matched to its call.
+	echoServer := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var inputs main.Inputs
+			json.NewDecoder(r.Body).Decode(&inputs)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"answer":     inputs.Query,
+				"aggregator": r.Header.Get("x-use-cache") + " " + r.Header.Get("X-Team"),
+				"cells":      []string{r.URL.Path},
+			})
+		}))
+	}
+
+	It("answers every call of a shared connector correctly", func() {
+		server := echoServer()
+		defer server.Close()
+		connector := newServerConnector(server)
+		connector.Headers = http.Header{"X-Team": {"energy"}}
+		connector.DisableCache = true
+		connector.ForwardRequestID = true
+
+		var wg sync.WaitGroup
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				query := fmt.Sprintf("query %d", i)
+				response, err := connector.ConnectAIModel(main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: query}, "token")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.Answer).Should(Equal(query))
+				Expect(response.Aggregator).Should(Equal("false energy"))
+				Expect(response.Cells).Should(Equal([]string{"/models/" + main.DefaultModel}))
+			}(i)
+		}
+		wg.Wait()
+		Expect(connector.Headers).Should(Equal(http.Header{"X-Team": {"energy"}}))
+	})
+
+	It("keeps per-request models of a shared server connector apart", func() {
+		upstream := echoServer()
+		defer upstream.Close()
+		path := filepath.Join(GinkgoT().TempDir(), "data.csv")
+		Expect(os.WriteFile(path, []byte("a\n1\n"), 0o600)).Should(Succeed())
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		server := &main.Server{Connector: newServerConnector(upstream), Token: "token", Data: data}
+		router := server.Router()
+
+		var wg sync.WaitGroup
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+				model := fmt.Sprintf("org/model-%d", i)
+				body := fmt.Sprintf(`{"query": "query %d", "model": %q}`, i, model)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(body)))
+
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+				var response main.Response
+				Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+				Expect(response.Answer).Should(Equal(fmt.Sprintf("query %d", i)))
+				Expect(response.Cells).Should(Equal([]string{"/models/" + model}))
+			}(i)
+		}
+		wg.Wait()
+		Expect(server.Connector.Model).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("request timeout", func() {
+	It("builds a connector with the given client timeout", func() {
+		connector := main.NewAIModelConnector(5 * time.Second)
+		Expect(connector.Client.Timeout).Should(Equal(5 * time.Second))
+	})
+
+	It("fails with a timeout error when the model is too slow", func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		connector := newServerConnector(server)
+		connector.Client.Timeout = 50 * time.Millisecond
+
+		payload := main.Inputs{Table: map[string][]string{"header1": {"value1"}}, Query: "What is the total?"}
+		start := time.Now()
+		_, err := connector.ConnectAIModel(payload, "token")
+		Expect(err).Should(HaveOccurred())
+
+		var netErr net.Error
+		Expect(errors.As(err, &netErr)).Should(BeTrue())
+		Expect(netErr.Timeout()).Should(BeTrue())
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+})
+
+var _ = Describe("ValidateToken", func() {
+	It("accepts a Hugging Face token", func() {
+		Expect(main.ValidateToken("hf_abcdefghijklmnop")).Should(Succeed())
+	})
+
+	It("rejects a missing token", func() {
+		Expect(main.ValidateToken("")).Should(MatchError(main.ErrTokenMissing))
+	})
+
+	It("rejects malformed tokens", func() {
+		for _, token := range []string{"abcdefghijklmnop", "Bearer hf_abc", "hf_abc def", "hf_abc\n"} {
+			Expect(main.ValidateToken(token)).Should(MatchError(main.ErrTokenMalformed), token)
+		}
+	})
+})
+
+var _ = Describe("LoadEnv", func() {
+	It("continues without a .env file", func() {
+		Expect(main.LoadEnv(filepath.Join(GinkgoT().TempDir(), ".env"))).Should(Succeed())
+	})
+
+	It("loads variables from a .env file", func() {
+		DeferCleanup(os.Unsetenv, "LOAD_ENV_TEST")
+		path := filepath.Join(GinkgoT().TempDir(), ".env")
+		Expect(os.WriteFile(path, []byte("LOAD_ENV_TEST=loaded\n"), 0o600)).Should(Succeed())
+
+		Expect(main.LoadEnv(path)).Should(Succeed())
+		Expect(os.Getenv("LOAD_ENV_TEST")).Should(Equal("loaded"))
+	})
+
+	It("reports a .env file that cannot be parsed", func() {
+		path := filepath.Join(GinkgoT().TempDir(), ".env")
+		Expect(os.WriteFile(path, []byte("LOAD_ENV_TEST='unterminated\n"), 0o600)).Should(Succeed())
+
+		Expect(main.LoadEnv(path)).ShouldNot(Succeed())
+	})
+})
+
+// newTLSConnector returns a connector that sends every request to the TLS
+// server through transport, which is made to trust the server's certificate.
+func newTLSConnector(server *httptest.Server, transport *http.Transport) *main.AIModelConnector {
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		panic(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	transport.TLSClientConfig.RootCAs = roots
+	return &main.AIModelConnector{
+		Client: &http.Client{Transport: &redirectTransport{target: target, next: transport}},
+	}
+}
+
+func BenchmarkConnectAIModelPerRequestClient(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"answer": "TV"}`))
+	}))
+	defer server.Close()
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transport := main.NewTransport()
+		if _, err := newTLSConnector(server, transport).ConnectAIModel(payload, "token"); err != nil {
+			b.Fatal(err)
+		}
+		transport.CloseIdleConnections()
+	}
+}
+
+func BenchmarkConnectAIModelSharedClient(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"answer": "TV"}`))
+	}))
+	defer server.Close()
+	payload := main.Inputs{Table: map[string][]string{"a": {"1"}}, Query: "q"}
+	transport := main.NewTransport()
+	defer transport.CloseIdleConnections()
+	connector := newTLSConnector(server, transport)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := connector.ConnectAIModel(payload, "token"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors for the service. A nil *Metrics
+// records nothing, so handlers can use it unconditionally.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	askRequests      *prometheus.CounterVec
+	upstreamLatency  *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+	csvParseFailures prometheus.Counter
+	breakerRejects   prometheus.Counter
+	busyRejects      prometheus.Counter
+	cacheHits        prometheus.Counter
+	cacheMisses      prometheus.Counter
+
+	// breaker is the CircuitBreaker whose state the breaker gauge reports.
+	breaker atomic.Pointer[CircuitBreaker]
+	// concurrency is the ConcurrencyLimiter whose slots the in-flight and
+	// queued gauges report.
+	concurrency atomic.Pointer[ConcurrencyLimiter]
+}
+
+// NewMetrics creates the collectors and registers them with registry.
+func NewMetrics(registry *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		askRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ask_requests_total",
+			Help: "Requests to the ask endpoints by route and HTTP status.",
+		}, []string{"route", "status"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Latency of calls to the inference API, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"model"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_errors_total",
+			Help: "Failed calls to the inference API by error type.",
+		}, []string{"type"}),
+		csvParseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "csv_parse_failures_total",
+			Help: "CSV inputs that could not be parsed.",
+		}),
+		breakerRejects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "circuit_breaker_rejections_total",
+			Help: "Model calls skipped because the circuit breaker was open.",
+		}),
+		busyRejects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "upstream_concurrency_rejections_total",
+			Help: "Model calls skipped because too many were in flight.",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "answer_cache_hits_total",
+			Help: "Queries answered from the answer cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "answer_cache_misses_total",
+			Help: "Queries looked up in the answer cache and sent to the model.",
+		}),
+	}
+	breakerState := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "State of the circuit breaker around model calls: 0 closed, 1 half-open, 2 open.",
+	}, func() float64 { return float64(m.breaker.Load().State()) })
+	inFlight := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "upstream_requests_in_flight",
+		Help: "Model calls holding a concurrency slot; zero without a concurrency limit.",
+	}, func() float64 { return float64(m.concurrency.Load().InFlight()) })
+	queued := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "upstream_requests_queued",
+		Help: "Model calls waiting for a concurrency slot.",
+	}, func() float64 { return float64(m.concurrency.Load().Waiting()) })
+	registry.MustRegister(m.askRequests, m.upstreamLatency, m.upstreamErrors, m.csvParseFailures, m.breakerRejects, m.busyRejects, m.cacheHits, m.cacheMisses, breakerState, inFlight, queued)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// countRequests is middleware counting requests by route and final status.
+func (m *Metrics) countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if m != nil {
+			m.askRequests.WithLabelValues(c.FullPath(), strconv.Itoa(c.Writer.Status())).Inc()
+		}
+	}
+}
+
+func (m *Metrics) observeUpstream(model string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.upstreamLatency.WithLabelValues(model).Observe(elapsed.Seconds())
+	if err != nil {
+		m.upstreamErrors.WithLabelValues(upstreamErrorType(err)).Inc()
+	}
+}
+
+// trackBreaker makes the circuit_breaker_state gauge report b.
+func (m *Metrics) trackBreaker(b *CircuitBreaker) {
+	if m != nil {
+		m.breaker.Store(b)
+	}
+}
+
+// trackConcurrency makes the in-flight and queued gauges report l.
+func (m *Metrics) trackConcurrency(l *ConcurrencyLimiter) {
+	if m != nil {
+		m.concurrency.Store(l)
+	}
+}
+
+// concurrencyRejected counts err, returned by ConcurrencyLimiter.Acquire,
+// when the limiter turned the call away.
+func (m *Metrics) concurrencyRejected(err error) {
+	var busy *UpstreamBusyError
+	if m != nil && errors.As(err, &busy) {
+		m.busyRejects.Inc()
+	}
+}
+
+func (m *Metrics) breakerRejected() {
+	if m != nil {
+		m.breakerRejects.Inc()
+	}
+}
+
+func (m *Metrics) answerCacheLookup(hit bool) {
+	switch {
+	case m == nil:
+	case hit:
+		m.cacheHits.Inc()
+	default:
+		m.cacheMisses.Inc()
+	}
+}
+
+func (m *Metrics) csvParseFailed() {
+	if m != nil {
+		m.csvParseFailures.Inc()
+	}
+}
+
+// upstreamErrorType classifies err as "timeout", "canceled", the HTTP status
+// code of an upstream error, "connection" or "other".
+func upstreamErrorType(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+
+	var loading *ModelLoadingError
+	if errors.As(err, &loading) {
+		return "503"
+	}
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return strconv.Itoa(upstream.StatusCode)
+	}
+	var auth *AuthError
+	if errors.As(err, &auth) {
+		return strconv.Itoa(auth.StatusCode)
+	}
+	var invalid *InvalidResponseError
+	if errors.As(err, &invalid) {
+		return "invalid_response"
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return "connection"
+	}
+	return "other"
+}
@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Metrics", func() {
+	var server *main.Server
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		return rec.Body.String()
+	}
+
+	post := func(path, body string) int {
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	It("counts requests, upstream latency and upstream errors", func() {
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.Path, "busy") {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		defer model.Close()
+		server = &main.Server{
+			Connector: newServerConnector(model),
+			Token:     "token",
+			Metrics:   main.NewMetrics(prometheus.NewRegistry()),
+			Retry:     &main.RetryPolicy{MaxAttempts: 1},
+		}
+
+		Expect(post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`)).Should(Equal(http.StatusOK))
+		Expect(post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?", "model": "org/busy"}`)).Should(Equal(http.StatusBadGateway))
+
+		metrics := scrape()
+		Expect(metrics).Should(ContainSubstring(`ask_requests_total{route="/ask-json",status="200"} 1`))
+		Expect(metrics).Should(ContainSubstring(`ask_requests_total{route="/ask-json",status="502"} 1`))
+		Expect(metrics).Should(ContainSubstring(`upstream_request_duration_seconds_count{model="google/tapas-base-finetuned-wtq"} 1`))
+		Expect(metrics).Should(ContainSubstring(`upstream_errors_total{type="429"} 1`))
+	})
+
+	It("labels models the server is not configured with as other", func() {
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"answer": "TV"}`))
+		}))
+		defer model.Close()
+		connector := newServerConnector(model)
+		connector.Fallbacks = []string{"org/backup"}
+		server = &main.Server{Connector: connector, Token: "token", Metrics: main.NewMetrics(prometheus.NewRegistry())}
+
+		for _, name := range []string{"org/backup", "org/anything-1", "org/anything-2"} {
+			Expect(post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?", "model": "`+name+`"}`)).Should(Equal(http.StatusOK))
+		}
+
+		metrics := scrape()
+		Expect(metrics).Should(ContainSubstring(`upstream_request_duration_seconds_count{model="org/backup"} 1`))
+		Expect(metrics).Should(ContainSubstring(`upstream_request_duration_seconds_count{model="other"} 2`))
+		Expect(metrics).ShouldNot(ContainSubstring("anything"))
+	})
+
+	It("counts CSV parse failures", func() {
+		server = &main.Server{Token: "token", Metrics: main.NewMetrics(prometheus.NewRegistry())}
+
+		req := newMultipartRequest("/ask-upload", "text/csv", "a,b\n1,2,3", map[string]string{"query": "q"})
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+
+		Expect(scrape()).Should(ContainSubstring("csv_parse_failures_total 1"))
+	})
+})
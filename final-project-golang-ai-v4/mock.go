@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// MockHeader is set to "true" on answers from a MockModel, so clients can
+// tell canned data from real model output.
+const MockHeader = "X-Mock-AI"
+
+// markMock sets MockHeader on every response of the routes it guards.
+func markMock(c *gin.Context) {
+	c.Header(MockHeader, "true")
+	c.Next()
+}
+
+// isMock reports whether model is a MockModel.
+func isMock(model TableQAModel) bool {
+	switch model.(type) {
+	case MockModel, *MockModel:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MockModel", func() {
+	inputs := main.Inputs{
+		Table: map[string][]string{
+			"Appliance":          {"Refrigerator", "TV", "Lamp"},
+			"Energy_Consumption": {"1.2", "0.8", "0.1"},
+		},
+		Query: "What is the energy consumption of the TV?",
+	}
+
+	It("answers deterministically with a cell of the named column", func() {
+		first, err := main.MockModel{}.Answer(context.Background(), inputs)
+		Expect(err).ShouldNot(HaveOccurred())
+		second, err := main.MockModel{}.Answer(context.Background(), inputs)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(second).Should(Equal(first))
+		Expect(first.Coordinates).Should(HaveLen(1))
+		Expect(first.Coordinates[0][1]).Should(Equal(1))
+		Expect(inputs.Table["Energy_Consumption"]).Should(ContainElement(first.Answer))
+		Expect(first.Cells).Should(Equal([]string{first.Answer}))
+	})
+
+	It("answers from the first column when the query names none", func() {
+		response, err := main.MockModel{}.Answer(context.Background(), main.Inputs{Table: inputs.Table, Query: "Anything?"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.Coordinates[0][1]).Should(Equal(0))
+		Expect(inputs.Table["Appliance"]).Should(ContainElement(response.Answer))
+	})
+
+	It("answers /ask end to end without network I/O", func() {
+		var calls atomic.Int32
+		offline := &MockClient{MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return nil, errors.New("network access in mock mode")
+		}}
+		defaultTransport := http.DefaultTransport
+		http.DefaultTransport = offline
+		DeferCleanup(func() { http.DefaultTransport = defaultTransport })
+
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Appliance,Energy_Consumption\nFridge,1.2\nTV,0.8\n"), 0o600)).Should(Succeed())
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		server := &main.Server{
+			Model:     main.MockModel{},
+			Connector: &main.AIModelConnector{Client: &http.Client{Transport: offline}},
+			Data:      data,
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"query": "Which appliance?"}`))
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, req)
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Header().Get(main.MockHeader)).Should(Equal("true"))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"answer"`))
+		Expect(calls.Load()).Should(BeZero())
+	})
+
+	It("is named mock", func() {
+		Expect(main.MockModel{}.Name()).Should(Equal("mock"))
+	})
+})
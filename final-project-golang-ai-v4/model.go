@@ -0,0 +1,26 @@
+package main
+
+import "slices"
+
+// OtherModelLabel is the metrics label of models a request named that the
+// server is not configured with, so clients cannot add series at will.
+const OtherModelLabel = "other"
+
+// modelLabel names model in logs and metrics. Backends can provide a name
+// with a Name() string method.
+func modelLabel(model TableQAModel) string {
+	if named, ok := model.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "custom"
+}
+
+// metricsLabel is modelLabel for the server's model and its fallbacks, and
+// OtherModelLabel for any other model a request asked for.
+func (s *Server) metricsLabel(model TableQAModel) string {
+	label := modelLabel(model)
+	if s.Model != nil || s.Connector == nil || label == s.Connector.ModelName() || slices.Contains(s.Connector.Fallbacks, label) {
+		return label
+	}
+	return OtherModelLabel
+}
@@ -0,0 +1,32 @@
+package main_test
+
+import (
+	"context"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HuggingFaceModel", func() {
+	It("answers through the connector", func() {
+		var received main.Inputs
+		server := newModelServer(`{"answer": "TV", "aggregator": "NONE"}`, &received)
+		defer server.Close()
+
+		var model main.TableQAModel = &main.HuggingFaceModel{Connector: *newServerConnector(server), Token: "token"}
+		inputs := main.Inputs{Table: map[string][]string{"Appliance": {"TV"}}, Query: "Which appliance?"}
+		response, err := model.Answer(context.Background(), inputs)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.Answer).Should(Equal("TV"))
+		Expect(received).Should(Equal(inputs))
+	})
+
+	It("is named after the Hugging Face model", func() {
+		Expect((&main.HuggingFaceModel{}).Name()).Should(Equal(main.DefaultModel))
+		model := &main.HuggingFaceModel{Connector: main.AIModelConnector{Model: "google/tapas-large-finetuned-wtq"}}
+		Expect(model.Name()).Should(Equal("google/tapas-large-finetuned-wtq"))
+	})
+})
@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateQuery", func() {
+	It("rejects empty and whitespace-only queries", func() {
+		for _, query := range []string{"", "   ", "\t\n", "\x00\x07"} {
+			_, err := main.ValidateQuery(query, 10)
+			Expect(err).Should(MatchError(main.ErrEmptyQuery), "%q", query)
+		}
+	})
+
+	It("rejects queries over the limit", func() {
+		_, err := main.ValidateQuery(strings.Repeat("a", 11), 10)
+		Expect(err).Should(MatchError("query has 11 characters, the limit is 10"))
+
+		Expect(main.ValidateQuery(strings.Repeat("é", 10), 10)).Should(Equal(strings.Repeat("é", 10)))
+	})
+
+	It("strips control characters and surrounding whitespace", func() {
+		Expect(main.ValidateQuery("  Which\x00 room\nuses the most?\x1b ", 0)).Should(Equal("Which room uses the most?"))
+	})
+})
+
+var _ = Describe("POST /ask query validation", func() {
+	var (
+		model  *fakeModel
+		server *main.Server
+	)
+
+	BeforeEach(func() {
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+		model = &fakeModel{response: main.Response{Answer: "Lamp"}}
+		server = &main.Server{Model: model, Data: data, MaxQueryLength: 20}
+	})
+
+	ask := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := `{"query": "` + query + `"}`
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(body)))
+		return rec
+	}
+
+	It("rejects an empty query without calling the model", func() {
+		rec := ask("   ")
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring("query must not be empty"))
+		Expect(model.received).Should(BeEmpty())
+	})
+
+	It("rejects an oversized query without calling the model", func() {
+		rec := ask(strings.Repeat("a", 21))
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring("the limit is 20"))
+		Expect(model.received).Should(BeEmpty())
+	})
+
+	It("sends a valid query to the model trimmed", func() {
+		Expect(ask(" Where is the lamp? ").Code).Should(Equal(http.StatusOK))
+		Expect(model.received).Should(HaveLen(1))
+		Expect(model.received[0].Query).Should(Equal("Where is the lamp?"))
+	})
+})
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitSweepInterval is how often idle buckets are evicted.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter is a per-key token bucket limiter. It is safe for concurrent
+// use. A nil *RateLimiter allows everything.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows each key ratePerSecond requests per second on
+// average, with bursts of up to burst requests.
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    ratePerSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// rateLimitFromEnv reads RATE_LIMIT_RPS and RATE_LIMIT_BURST. The rate is
+// zero when RATE_LIMIT_RPS is unset, and the burst then meaningless.
+func rateLimitFromEnv(getenv func(string) string) (float64, int, error) {
+	value := getenv("RATE_LIMIT_RPS")
+	if value == "" {
+		return 0, 0, nil
+	}
+	rps, err := strconv.ParseFloat(value, 64)
+	if err != nil || rps < 0 || math.IsInf(rps, 0) || math.IsNaN(rps) {
+		return 0, 0, fmt.Errorf("invalid RATE_LIMIT_RPS %q", value)
+	}
+	if rps == 0 {
+		return 0, 0, nil
+	}
+
+	burst := int(math.Ceil(rps))
+	if value := getenv("RATE_LIMIT_BURST"); value != "" {
+		burst, err = strconv.Atoi(value)
+		if err != nil || burst < 1 {
+			return 0, 0, fmt.Errorf("invalid RATE_LIMIT_BURST %q", value)
+		}
+	}
+	return rps, burst, nil
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it reports
+// false and how long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since a full bucket
+// behaves exactly like a missing one. l.mu must be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of tracked keys.
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// middleware rejects requests over the client IP's limit with 429.
+func (l *RateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			return
+		}
+		if ok, wait := l.Allow(c.ClientIP()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorJSON(c, CodeRateLimited, "Rate limit exceeded"))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	It("allows a burst and then rejects", func() {
+		limiter := main.NewRateLimiter(0.001, 3)
+		for i := 0; i < 3; i++ {
+			ok, _ := limiter.Allow("10.0.0.1")
+			Expect(ok).Should(BeTrue())
+		}
+		ok, wait := limiter.Allow("10.0.0.1")
+		Expect(ok).Should(BeFalse())
+		Expect(wait).Should(BeNumerically(">", 0))
+
+		ok, _ = limiter.Allow("10.0.0.2")
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("is safe under concurrency", func() {
+		limiter := main.NewRateLimiter(0.001, 50)
+		var allowed int32
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _ := limiter.Allow("10.0.0.1"); ok {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(allowed).Should(Equal(int32(50)))
+	})
+
+	It("returns 429 with Retry-After once the limit is exceeded", func() {
+		model := newModelServer(`{"answer": "TV"}`, nil)
+		defer model.Close()
+		server := &main.Server{
+			Connector:   newServerConnector(model),
+			Token:       "token",
+			RateLimiter: main.NewRateLimiter(0.5, 2),
+		}
+		router := server.Router()
+
+		var codes []int
+		var last *httptest.ResponseRecorder
+		for i := 0; i < 4; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`))
+			last = httptest.NewRecorder()
+			router.ServeHTTP(last, req)
+			codes = append(codes, last.Code)
+		}
+
+		Expect(codes).Should(Equal([]int{200, 200, 429, 429}))
+		Expect(last.Header().Get("Retry-After")).Should(Equal("2"))
+	})
+
+	It("only believes X-Forwarded-For from trusted proxies", func() {
+		model := newModelServer(`{"answer": "TV"}`, nil)
+		defer model.Close()
+		ask := func(server *main.Server, forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`))
+			req.RemoteAddr = "10.0.0.1:4321"
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		server := &main.Server{Connector: newServerConnector(model), Token: "token", RateLimiter: main.NewRateLimiter(0.001, 2)}
+		var codes []int
+		for _, spoofed := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4"} {
+			codes = append(codes, ask(server, spoofed))
+		}
+		Expect(codes).Should(Equal([]int{200, 200, 429, 429}))
+		Expect(server.RateLimiter.Len()).Should(Equal(1))
+
+		server = &main.Server{Connector: newServerConnector(model), Token: "token", RateLimiter: main.NewRateLimiter(0.001, 1), TrustedProxies: []string{"10.0.0.0/8"}}
+		Expect(ask(server, "203.0.113.1")).Should(Equal(http.StatusOK))
+		Expect(ask(server, "203.0.113.2")).Should(Equal(http.StatusOK))
+		Expect(ask(server, "203.0.113.1")).Should(Equal(http.StatusTooManyRequests))
+	})
+})
@@ -0,0 +1,70 @@
+package main
+
+import "a21hc3NpZ25tZW50/tableqa"
+
+// Truncation is added to a response when the table was cut to ?max_rows
+// rows before it was sent to the model, so the answer only covers those rows.
+type Truncation struct {
+	Truncated    bool `json:"truncated"`
+	OriginalRows int  `json:"original_rows"`
+}
+
+// MaxPreviewRows bounds ?preview, so a preview cannot grow a response to the
+// size of the table.
+const MaxPreviewRows = 100
+
+// TablePreview is the start of the table a query was answered against, added
+// to a response for ?preview=N so clients can show the answer in context.
+type TablePreview struct {
+	// Columns are in the order the table was sent to the model.
+	Columns []string `json:"columns"`
+	// Rows are the first rows, each with one value per column.
+	Rows [][]string `json:"rows"`
+	// TotalRows is the number of rows in the whole table.
+	TotalRows int `json:"total_rows"`
+}
+
+// NewTablePreview returns the first rows of table with its columns in the
+// given order.
+func NewTablePreview(table map[string][]string, columns []string, rows int) *TablePreview {
+	total := tableqa.TableRows(table)
+	rows = min(rows, total)
+	preview := &TablePreview{Columns: columns, Rows: make([][]string, rows), TotalRows: total}
+	for i := range preview.Rows {
+		row := make([]string, len(columns))
+		for j, column := range columns {
+			if values := table[column]; i < len(values) {
+				row[j] = values[i]
+			}
+		}
+		preview.Rows[i] = row
+	}
+	return preview
+}
+
+// newInterpretation returns the yes/no reading of the answer to query for
+// ?interpret=true, or nil when opts did not ask for it or InterpretAnswer
+// cannot tell.
+func newInterpretation(query string, response Response, opts askOptions) *Interpretation {
+	if !opts.interpret {
+		return nil
+	}
+	if interpretation, ok := InterpretAnswer(query, response); ok {
+		return &interpretation
+	}
+	return nil
+}
+
+// cleaned carries the cleaned answer in responses to ?clean=true.
+type cleaned struct {
+	CleanAnswer string `json:"clean_answer"`
+}
+
+// newCleaned returns the cleaned answer of response, or nil when opts did
+// not ask for it.
+func newCleaned(response Response, opts askOptions) *cleaned {
+	if !opts.clean {
+		return nil
+	}
+	return &cleaned{CleanAnswer: CleanAnswer(response)}
+}
@@ -0,0 +1,387 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response", func() {
+	table := map[string][]string{
+		"Appliance":          {"Refrigerator", "TV", "Lamp"},
+		"Energy_Consumption": {"1.2", "0.8", "0.1"},
+	}
+	headers := []string{"Appliance", "Energy_Consumption"}
+
+	Describe("ResolveCells", func() {
+		It("returns the table values at each coordinate", func() {
+			response := main.Response{Coordinates: [][]int{{0, 0}, {1, 1}, {2, 1}}}
+
+			values, err := response.ResolveCells(table, headers)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(values).Should(Equal([]string{"Refrigerator", "0.8", "0.1"}))
+		})
+
+		It("rejects out-of-range coordinates", func() {
+			for _, coordinate := range [][]int{{3, 0}, {0, 2}, {-1, 0}, {0}} {
+				response := main.Response{Coordinates: [][]int{coordinate}}
+
+				_, err := response.ResolveCells(table, headers)
+				Expect(err).Should(HaveOccurred(), "%v", coordinate)
+			}
+		})
+	})
+
+	Describe("Enrich", func() {
+		It("pairs each coordinate with its header and value", func() {
+			response := main.Response{Answer: "0.8", Coordinates: [][]int{{1, 1}}, Cells: []string{"0.8"}}
+
+			enriched, err := response.Enrich(table, headers)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(enriched.Response).Should(Equal(response))
+			Expect(enriched.ResolvedCells).Should(Equal([]main.ResolvedCell{
+				{Row: 1, Column: 1, Header: "Energy_Consumption", Value: "0.8"},
+			}))
+		})
+	})
+
+	Describe("EnrichWithIndex", func() {
+		indexed := map[string][]string{
+			"ID":        {"R-1", "R-2", "R-3"},
+			"Appliance": {"Refrigerator", "TV", "Lamp"},
+		}
+
+		It("labels each cell with the index value of its row", func() {
+			response := main.Response{Answer: "TV, Lamp", Coordinates: [][]int{{1, 1}, {2, 1}}}
+
+			enriched, err := response.EnrichWithIndex(indexed, []string{"ID", "Appliance"}, "ID")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(enriched.IndexColumn).Should(Equal("ID"))
+			Expect(enriched.ResolvedCells).Should(Equal([]main.ResolvedCell{
+				{Row: 1, Column: 1, Header: "Appliance", Value: "TV", Index: "R-2"},
+				{Row: 2, Column: 1, Header: "Appliance", Value: "Lamp", Index: "R-3"},
+			}))
+
+			var out strings.Builder
+			Expect(enriched.WriteCSV(&out)).Should(Succeed())
+			Expect(out.String()).Should(Equal("answer,aggregator,row,column,header,value,index\n" +
+				"\"TV, Lamp\",,1,1,Appliance,TV,R-2\n" +
+				"\"TV, Lamp\",,2,1,Appliance,Lamp,R-3\n"))
+		})
+
+		It("rejects an index column that is not in the table", func() {
+			_, err := main.Response{}.EnrichWithIndex(indexed, []string{"ID", "Appliance"}, "Serial")
+			Expect(err).Should(MatchError(`index column "Serial" not in table`))
+		})
+	})
+
+	Describe("scores", func() {
+		It("decodes cell and aggregator scores when the model returns them", func() {
+			connector := newStaticConnector(http.StatusOK, `{"answer": "TV", "coordinates": [[1, 0]], "cells": ["TV"], "aggregator": "NONE",
+				"cell_scores": [0.93], "aggregator_scores": {"NONE": 0.8, "SUM": 0.15, "AVERAGE": 0.03, "COUNT": 0.02}}`)
+
+			response, err := connector.ConnectAIModel(main.Inputs{Table: table, Query: "Which appliance?"}, "token")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.CellScores).Should(Equal([]float64{0.93}))
+			Expect(response.AggregatorScores).Should(HaveKeyWithValue("NONE", 0.8))
+
+			enriched, err := response.Enrich(table, headers)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(*enriched.ResolvedCells[0].Score).Should(Equal(0.93))
+		})
+
+		It("leaves scores out when the model does not return them", func() {
+			connector := newStaticConnector(http.StatusOK, `{"answer": "TV", "coordinates": [[1, 0]], "cells": ["TV"], "aggregator": "NONE"}`)
+
+			response, err := connector.ConnectAIModel(main.Inputs{Table: table, Query: "Which appliance?"}, "token")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.CellScores).Should(BeNil())
+			Expect(response.AggregatorScores).Should(BeNil())
+
+			enriched, err := response.Enrich(table, headers)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(enriched.ResolvedCells[0].Score).Should(BeNil())
+			encoded, err := json.Marshal(enriched)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(encoded)).ShouldNot(ContainSubstring("score"))
+		})
+
+		It("does not attribute scores that do not match the coordinates", func() {
+			response := main.Response{Coordinates: [][]int{{0, 0}, {1, 0}}, CellScores: []float64{0.5}}
+
+			enriched, err := response.Enrich(table, headers)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(enriched.ResolvedCells[0].Score).Should(BeNil())
+			Expect(enriched.CellScores).Should(Equal([]float64{0.5}))
+		})
+	})
+
+	Describe("candidates", func() {
+		It("decodes ranked candidate answers", func() {
+			connector := newStaticConnector(http.StatusOK, `[
+				{"answer": "Refrigerator", "coordinates": [[0, 0]], "cells": ["Refrigerator"], "aggregator": "NONE", "score": 0.7},
+				{"answer": "SUM > 1.2, 0.8", "coordinates": [[0, 1], [1, 1]], "cells": ["1.2", "0.8"], "aggregator": "SUM", "score": 0.2},
+				{"answer": "TV", "coordinates": [[1, 0]], "cells": ["TV"], "aggregator": "NONE"}
+			]`)
+
+			response, err := connector.ConnectAIModel(main.Inputs{Table: table, Query: "Which appliance uses the most?"}, "token")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Answer).Should(Equal("Refrigerator"))
+			Expect(response.Coordinates).Should(Equal([][]int{{0, 0}}))
+			top, second := 0.7, 0.2
+			Expect(response.CandidateAnswers()).Should(Equal([]main.Candidate{
+				{Answer: "Refrigerator", Coordinates: [][]int{{0, 0}}, Cells: []string{"Refrigerator"}, Aggregator: "NONE", Score: &top},
+				{Answer: "SUM > 1.2, 0.8", Coordinates: [][]int{{0, 1}, {1, 1}}, Cells: []string{"1.2", "0.8"}, Aggregator: "SUM", Score: &second},
+				{Answer: "TV", Coordinates: [][]int{{1, 0}}, Cells: []string{"TV"}, Aggregator: "NONE"},
+			}))
+		})
+
+		It("makes a single answer the only candidate", func() {
+			connector := newStaticConnector(http.StatusOK, `{"answer": "TV", "coordinates": [[1, 0]], "cells": ["TV"], "aggregator": "NONE"}`)
+
+			response, err := connector.ConnectAIModel(main.Inputs{Table: table, Query: "Which appliance?"}, "token")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Candidates).Should(BeNil())
+			Expect(response.CandidateAnswers()).Should(Equal([]main.Candidate{
+				{Answer: "TV", Coordinates: [][]int{{1, 0}}, Cells: []string{"TV"}, Aggregator: "NONE"},
+			}))
+		})
+
+		It("rejects lists without valid answers", func() {
+			for _, body := range []string{`[]`, `[{"answer": "TV"}, {"cells": ["TV"]}]`, `[{"error": "overloaded"}]`, `["TV"]`} {
+				_, err := newStaticConnector(http.StatusOK, body).ConnectAIModel(main.Inputs{Table: table, Query: "Which appliance?"}, "token")
+				var invalid *main.InvalidResponseError
+				Expect(errors.As(err, &invalid)).Should(BeTrue(), body)
+			}
+		})
+
+		It("lists them in the answer", func() {
+			model := &fakeModel{response: main.Response{Answer: "TV", Candidates: []main.Candidate{{Answer: "TV"}, {Answer: "Lamp"}}}}
+			server := &main.Server{Model: model}
+			rec := httptest.NewRecorder()
+			body := `{"table": {"Appliance": ["TV", "Lamp"]}, "query": "Which appliance?"}`
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			var response main.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+			Expect(response.Candidates).Should(HaveLen(2))
+			Expect(response.Candidates[1].Answer).Should(Equal("Lamp"))
+		})
+	})
+
+	Describe("WriteCSV", func() {
+		It("writes an answer without cells as a single record", func() {
+			var out strings.Builder
+			enriched := main.EnrichedResponse{Response: main.Response{Answer: "none", Aggregator: "NONE"}}
+
+			Expect(enriched.WriteCSV(&out)).Should(Succeed())
+			Expect(out.String()).Should(Equal("answer,aggregator,row,column,header,value\nnone,NONE,,,,\n"))
+		})
+	})
+
+	Describe("CleanAnswer", func() {
+		It("strips the aggregator prefix", func() {
+			Expect(main.CleanAnswer(main.Response{Answer: "SUM > 42", Aggregator: "SUM"})).Should(Equal("42"))
+			Expect(main.CleanAnswer(main.Response{Answer: "average>  1.5"})).Should(Equal("1.5"))
+			Expect(main.CleanAnswer(main.Response{Answer: "COUNT > 3"})).Should(Equal("3"))
+		})
+
+		It("rebuilds multi-cell answers from the cells", func() {
+			response := main.Response{
+				Answer:     "SUM > 10,20 ,  1,200",
+				Aggregator: "SUM",
+				Cells:      []string{"10", " 20", "1,200 "},
+			}
+			Expect(main.CleanAnswer(response)).Should(Equal("10, 20, 1,200"))
+		})
+
+		It("drops empty cells", func() {
+			response := main.Response{Answer: "TV, , Lamp", Cells: []string{"TV", "", "Lamp"}}
+			Expect(main.CleanAnswer(response)).Should(Equal("TV, Lamp"))
+		})
+
+		It("collapses odd spacing", func() {
+			Expect(main.CleanAnswer(main.Response{Answer: "  Living \t Room \n"})).Should(Equal("Living Room"))
+			Expect(main.CleanAnswer(main.Response{Answer: "x", Cells: []string{"Living   Room"}})).Should(Equal("Living Room"))
+		})
+
+		It("leaves clean answers alone", func() {
+			Expect(main.CleanAnswer(main.Response{Answer: "TV", Cells: []string{"TV"}, Aggregator: "NONE"})).Should(Equal("TV"))
+			Expect(main.CleanAnswer(main.Response{Answer: "Greater > Lesser"})).Should(Equal("Greater > Lesser"))
+			Expect(main.CleanAnswer(main.Response{})).Should(BeEmpty())
+		})
+	})
+
+	Describe("FillAnswer", func() {
+		It("computes an empty answer from aggregated cells", func() {
+			filled := main.Response{Aggregator: "AVERAGE", Cells: []string{"5", "15"}}.FillAnswer()
+			Expect(filled.Answer).Should(Equal("10"))
+			Expect(filled.AnswerFromCells).Should(BeTrue())
+
+			filled = main.Response{Aggregator: "COUNT", Cells: []string{"TV", "Lamp"}}.FillAnswer()
+			Expect(filled.Answer).Should(Equal("2"))
+		})
+
+		It("joins the cells when they cannot be aggregated", func() {
+			Expect(main.Response{Aggregator: "NONE", Cells: []string{"TV", "", "Lamp"}}.FillAnswer().Answer).Should(Equal("TV, Lamp"))
+			Expect(main.Response{Aggregator: "SUM", Cells: []string{"TV", "3"}}.FillAnswer().Answer).Should(Equal("TV, 3"))
+		})
+
+		It("keeps answers that are not empty or have nothing to fill them with", func() {
+			response := main.Response{Answer: "SUM > 1, 2", Aggregator: "SUM", Cells: []string{"1", "2"}}
+			Expect(response.FillAnswer()).Should(Equal(response))
+
+			response = main.Response{Aggregator: "NONE", Cells: []string{" "}}
+			Expect(response.FillAnswer()).Should(Equal(response))
+			Expect(main.Response{Aggregator: "COUNT"}.FillAnswer().AnswerFromCells).Should(BeFalse())
+		})
+	})
+
+	Describe("ConsistencyWarning", func() {
+		It("accepts answers that agree with the cells", func() {
+			for _, response := range []main.Response{
+				{Answer: "SUM > 1.2, 0.8", Aggregator: "SUM", Cells: []string{"1.2", "0.8"}},
+				{Answer: "2", Aggregator: "SUM", Cells: []string{"1.2", "0.8"}},
+				{Answer: "0.67", Aggregator: "AVERAGE", Cells: []string{"1.2", "0.8", "0.01"}},
+				{Answer: "COUNT > TV, Lamp", Aggregator: "COUNT", Cells: []string{"TV", "Lamp"}},
+				{Answer: "1,200", Aggregator: "NONE", Cells: []string{"1200"}},
+				{Answer: "TV", Aggregator: "NONE", Cells: []string{" TV "}},
+				{Answer: "Anything", Aggregator: "NONE"},
+				main.Response{Aggregator: "NONE", Cells: []string{"TV", "", "Lamp"}}.FillAnswer(),
+			} {
+				Expect(response.ConsistencyWarning()).Should(BeEmpty(), "%+v", response)
+			}
+		})
+
+		It("flags answers that disagree with the cells", func() {
+			response := main.Response{Answer: "5", Aggregator: "SUM", Cells: []string{"1.2", "0.8"}}
+			Expect(response.ConsistencyWarning()).Should(Equal(`answer "5" does not match SUM of the cells, 2`))
+
+			response = main.Response{Answer: "3", Aggregator: "COUNT", Cells: []string{"TV", "Lamp"}}
+			Expect(response.ConsistencyWarning()).Should(Equal(`answer "3" does not match COUNT of the cells, 2`))
+
+			response = main.Response{Answer: "SUM > 1.2, 0.1", Aggregator: "SUM", Cells: []string{"1.2", "0.8"}}
+			Expect(response.ConsistencyWarning()).Should(Equal(`answer "SUM > 1.2, 0.1" does not list the selected cells ["1.2" "0.8"]`))
+
+			response = main.Response{Answer: "Lamp", Cells: []string{"TV"}}
+			Expect(response.ConsistencyWarning()).Should(ContainSubstring("does not list the selected cells"))
+		})
+
+		It("is added to answers without changing them", func() {
+			model := &fakeModel{response: main.Response{Answer: "5", Coordinates: [][]int{{0, 1}, {1, 1}}, Cells: []string{"1.2", "0.8"}, Aggregator: "SUM"}}
+			server := &main.Server{Model: model}
+			rec := httptest.NewRecorder()
+			body := `{"table": {"Appliance": ["Refrigerator", "TV"], "Energy_Consumption": ["1.2", "0.8"]}, "query": "How much in total?"}`
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			var response main.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+			Expect(response.Answer).Should(Equal("5"))
+			Expect(response.Warning).Should(Equal(`answer "5" does not match SUM of the cells, 2`))
+
+			model.response.Answer = "2"
+			rec = httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("warning"))
+		})
+	})
+
+	Describe("InterpretAnswer", func() {
+		It("compares the computed answer with the number in the query", func() {
+			total := main.Response{Aggregator: "SUM", Cells: []string{"60", "50.5"}}
+			interpretation, ok := main.InterpretAnswer("Is the total consumption above 100?", total)
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation.Yes).Should(BeTrue())
+			Expect(*interpretation.Value).Should(BeNumerically("~", 110.5))
+			Expect(interpretation.Operator).Should(Equal(">"))
+			Expect(*interpretation.Threshold).Should(BeNumerically("==", 100))
+
+			interpretation, ok = main.InterpretAnswer("Does the TV use at most 1,000 kWh?", main.Response{Aggregator: "NONE", Cells: []string{"1,200"}})
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation.Yes).Should(BeFalse())
+			Expect(interpretation.Operator).Should(Equal("<="))
+
+			interpretation, ok = main.InterpretAnswer("Are there fewer than 3 appliances in the kitchen?", main.Response{Aggregator: "COUNT", Cells: []string{"Oven", "Fridge"}})
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation.Yes).Should(BeTrue())
+		})
+
+		It("negates a negated comparison", func() {
+			interpretation, ok := main.InterpretAnswer("Is the total not above 100?", main.Response{Aggregator: "SUM", Cells: []string{"60", "50"}})
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation.Yes).Should(BeFalse())
+		})
+
+		It("reads boolean cells", func() {
+			interpretation, ok := main.InterpretAnswer("Is the TV on?", main.Response{Aggregator: "NONE", Cells: []string{" On "}})
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation).Should(Equal(main.Interpretation{Yes: true}))
+
+			interpretation, ok = main.InterpretAnswer("Was the heater used?", main.Response{Cells: []string{"false"}})
+			Expect(ok).Should(BeTrue())
+			Expect(interpretation.Yes).Should(BeFalse())
+		})
+
+		It("does not guess", func() {
+			for query, response := range map[string]main.Response{
+				"What is the total?":                 {Aggregator: "SUM", Cells: []string{"1", "2"}},
+				"Is the total above 100?":            {Aggregator: "SUM", Cells: []string{"TV"}},
+				"Is the TV on?":                      {Cells: []string{"On", "Off"}},
+				"Which room is the TV in?":           {Cells: []string{"yes"}},
+				"Is the TV in the living room?":      {Cells: []string{"Living Room"}},
+				"Are the lamps above 5 and below 2?": {Aggregator: "NONE", Cells: []string{"1", "2"}},
+			} {
+				_, ok := main.InterpretAnswer(query, response)
+				Expect(ok).Should(BeFalse(), query)
+			}
+		})
+	})
+
+	Describe("ComputeAggregate", func() {
+		It("sums the selected cells", func() {
+			response := main.Response{Aggregator: "SUM", Cells: []string{"1.2", "0.8", "1,000"}}
+			Expect(response.ComputeAggregate()).Should(BeNumerically("~", 1002.0))
+		})
+
+		It("averages the selected cells", func() {
+			response := main.Response{Aggregator: "AVERAGE", Cells: []string{"5", "15"}}
+			Expect(response.ComputeAggregate()).Should(BeNumerically("~", 10.0))
+		})
+
+		It("counts the selected cells without parsing them", func() {
+			response := main.Response{Aggregator: "COUNT", Cells: []string{"TV", "Lamp", "Oven"}}
+			Expect(response.ComputeAggregate()).Should(BeNumerically("==", 3))
+		})
+
+		It("returns the single cell for NONE", func() {
+			response := main.Response{Aggregator: "NONE", Cells: []string{"0.8"}}
+			Expect(response.ComputeAggregate()).Should(BeNumerically("~", 0.8))
+
+			response = main.Response{Aggregator: "NONE", Cells: []string{"0.8", "1.2"}}
+			_, err := response.ComputeAggregate()
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("fails when a cell is not numeric", func() {
+			response := main.Response{Aggregator: "SUM", Cells: []string{"1.2", "TV"}}
+			_, err := response.ComputeAggregate()
+			Expect(err).Should(MatchError(`SUM: cell "TV" is not a number`))
+		})
+
+		It("fails for unknown aggregators", func() {
+			response := main.Response{Aggregator: "MEDIAN", Cells: []string{"1"}}
+			_, err := response.ComputeAggregate()
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
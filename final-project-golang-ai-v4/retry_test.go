@@ -0,0 +1,200 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// redirectTransport sends every request to target regardless of its URL.
+type redirectTransport struct {
+	target *url.URL
+	// next sends the redirected request; it defaults to http.DefaultTransport.
+	next http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	if t.next != nil {
+		return t.next.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newServerConnector(server *httptest.Server) *main.AIModelConnector {
+	target, err := url.Parse(server.URL)
+	Expect(err).ShouldNot(HaveOccurred())
+	return &main.AIModelConnector{
+		Client: &http.Client{Transport: &redirectTransport{target: target}},
+	}
+}
+
+var _ = Describe("ConnectAIModelWithRetry", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+	policy := main.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	It("retries 503s until the model answers", func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"answer": "value1", "coordinates": [[0, 0]], "cells": ["value1"], "aggregator": "NONE"}`))
+		}))
+		defer server.Close()
+
+		result, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(context.Background(), payload, "token", policy)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(attempts).Should(Equal(3))
+		Expect(result.Answer).Should(Equal("value1"))
+	})
+
+	It("gives up after MaxAttempts", func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		_, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(context.Background(), payload, "token", policy)
+		Expect(err).Should(HaveOccurred())
+		Expect(attempts).Should(Equal(3))
+		Expect(atomic.LoadInt32(&calls)).Should(Equal(int32(3)))
+
+		var upstream *main.UpstreamError
+		Expect(errors.As(err, &upstream)).Should(BeTrue())
+		Expect(upstream.StatusCode).Should(Equal(http.StatusTooManyRequests))
+	})
+
+	It("does not retry other client errors", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		_, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(context.Background(), payload, "token", policy)
+		Expect(err).Should(HaveOccurred())
+		Expect(attempts).Should(Equal(1))
+	})
+
+	It("waits for the Retry-After header", func() {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"answer": "value1"}`))
+		}))
+		defer server.Close()
+
+		start := time.Now()
+		_, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(context.Background(), payload, "token", policy)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(attempts).Should(Equal(2))
+		Expect(time.Since(start)).Should(BeNumerically(">=", time.Second))
+	})
+
+	It("caps the wait the API asks for at MaxDelay", func() {
+		for name, fail := range map[string]func(w http.ResponseWriter){
+			"Retry-After": func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			"estimated_time": func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error": "Model is currently loading", "estimated_time": 3600}`))
+			},
+		} {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					fail(w)
+					return
+				}
+				w.Write([]byte(`{"answer": "value1"}`))
+			}))
+
+			start := time.Now()
+			capped := main.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+			_, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(context.Background(), payload, "token", capped)
+			server.Close()
+			Expect(err).ShouldNot(HaveOccurred(), name)
+			Expect(attempts).Should(Equal(2), name)
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second), name)
+		}
+		Expect(main.DefaultRetryPolicy.MaxDelay).Should(Equal(main.DefaultMaxRetryDelay))
+	})
+
+	It("retries connection errors", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		connector := newServerConnector(server)
+		server.Close()
+
+		_, attempts, err := connector.ConnectAIModelWithRetry(context.Background(), payload, "token", policy)
+		Expect(err).Should(HaveOccurred())
+		Expect(attempts).Should(Equal(3))
+	})
+})
+
+var _ = Describe("ConnectAIModelWithContext", func() {
+	payload := main.Inputs{
+		Table: map[string][]string{"header1": {"value1"}},
+		Query: "What is the total?",
+	}
+
+	It("returns context.Canceled promptly when cancelled mid-flight", func() {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := newServerConnector(server).ConnectAIModelWithContext(ctx, payload, "token")
+		Expect(err).Should(MatchError(context.Canceled))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("stops retrying once the context is cancelled", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, attempts, err := newServerConnector(server).ConnectAIModelWithRetry(ctx, payload, "token",
+			main.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+		Expect(err).Should(MatchError(context.Canceled))
+		Expect(attempts).Should(Equal(1))
+		Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+})
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxSequenceQueries bounds the number of queries in one /ask-sequence
+// request.
+const MaxSequenceQueries = 20
+
+// handleAskSequence answers a conversation about one table, the inline
+// "table" if given or the server's CSV otherwise. The "queries" build on one
+// another and are sent to the model together in TAPAS's sequential mode, so a
+// follow-up like "Which of them is in the kitchen?" is answered in the light
+// of the earlier answers. This differs from /ask-batch, which answers
+// unrelated queries independently and concurrently and reports failures per
+// query: a sequence is a single model call that succeeds or fails as a whole.
+// The model must be a SequentialModel, such as an SQA-trained TAPAS model.
+func (s *Server) handleAskSequence(c *gin.Context) {
+	var jsonData struct {
+		Queries []string            `json:"queries"`
+		Table   map[string][]string `json:"table"`
+		Model   string              `json:"model"`
+	}
+	if !s.bindJSON(c, &jsonData) {
+		return
+	}
+	if len(jsonData.Queries) == 0 || len(jsonData.Queries) > MaxSequenceQueries {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("A sequence must contain between 1 and %d queries", MaxSequenceQueries)))
+		return
+	}
+	for i, query := range jsonData.Queries {
+		query, err := ValidateQuery(query, s.maxQueryLength())
+		if err != nil {
+			body := errorJSON(c, CodeInvalidQuery, fmt.Sprintf("Query %d: %v", i+1, err))
+			body.Error.Field = fmt.Sprintf("queries[%d]", i)
+			c.JSON(http.StatusBadRequest, body)
+			return
+		}
+		jsonData.Queries[i] = query
+	}
+
+	table, headers, ok := s.tableOrData(c, jsonData.Table)
+	if !ok {
+		return
+	}
+	opts, ok := newAskOptions(c, jsonData.Model)
+	if !ok {
+		return
+	}
+	if !s.checkRequest(c, table, opts.model) {
+		return
+	}
+
+	model := s.modelFor(opts)
+	sequential, ok := model.(SequentialModel)
+	if hf, isHF := model.(*HuggingFaceModel); isHF && hf.Connector.TaskName() != TaskTableQA {
+		ok = false
+	}
+	if !ok {
+		c.JSON(http.StatusNotImplemented, errorJSON(c, CodeNotSupported, "The configured model does not support sequential queries"))
+		return
+	}
+	inputs := SequentialInputs{Table: table, Queries: jsonData.Queries, Columns: headers}
+	if s.DryRun || opts.dryRun {
+		s.writeDryRun(c, inputs, opts)
+		return
+	}
+	if !s.checkToken(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := s.Concurrency.Acquire(ctx); err != nil {
+		s.Metrics.concurrencyRejected(err)
+		s.writeModelError(c, err)
+		return
+	}
+	defer s.Concurrency.Release()
+	if err := s.Breaker.Allow(); err != nil {
+		s.Metrics.breakerRejected()
+		s.writeModelError(c, err)
+		return
+	}
+
+	start := time.Now()
+	responses, err := sequential.AnswerSequence(ctx, inputs)
+	s.Breaker.Done(err)
+	s.Metrics.observeUpstream(s.metricsLabel(model), time.Since(start), err)
+	LoggerFromContext(ctx).Info("answered sequence",
+		"queries", len(jsonData.Queries),
+		"model", modelLabel(model),
+		"ok", err == nil,
+	)
+	if err != nil {
+		s.writeModelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"responses": responses})
+}
@@ -0,0 +1,104 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("POST /ask-sequence", func() {
+	var (
+		server   *main.Server
+		received []byte
+		answers  string
+	)
+
+	BeforeEach(func() {
+		received = nil
+		answers = `[
+			{"answer": "Kitchen, Hall", "coordinates": [[0, 0], [2, 0]], "cells": ["Kitchen", "Hall"], "aggregator": "NONE"},
+			{"answer": "Fridge", "coordinates": [[0, 1]], "cells": ["Fridge"], "aggregator": "NONE"}
+		]`
+		model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(answers))
+		}))
+		DeferCleanup(model.Close)
+
+		server = &main.Server{Connector: newServerConnector(model), Token: "token"}
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-sequence", strings.NewReader(body)))
+		return rec
+	}
+
+	It("sends the queries together in sequential mode and answers each", func() {
+		rec := post(`{
+			"table": {"Room": ["Kitchen", "Bedroom", "Hall"], "Appliance": ["Fridge", "Lamp", "Heater"]},
+			"queries": ["Which rooms use the most energy?", "Which of them has a fridge?"]
+		}`)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+
+		Expect(received).Should(MatchJSON(`{
+			"table": {"Appliance": ["Fridge", "Lamp", "Heater"], "Room": ["Kitchen", "Bedroom", "Hall"]},
+			"query": ["Which rooms use the most energy?", "Which of them has a fridge?"],
+			"parameters": {"sequential": true}
+		}`))
+
+		var body struct {
+			Responses []main.Response `json:"responses"`
+		}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+		Expect(body.Responses).Should(HaveLen(2))
+		Expect(body.Responses[0].Cells).Should(Equal([]string{"Kitchen", "Hall"}))
+		Expect(body.Responses[1].Answer).Should(Equal("Fridge"))
+	})
+
+	It("fails as a whole when the model does not answer every query", func() {
+		answers = `[{"answer": "Kitchen"}]`
+		rec := post(`{"table": {"Room": ["Kitchen"]}, "queries": ["first", "second"]}`)
+		Expect(rec.Code).Should(Equal(http.StatusBadGateway))
+		Expect(rec.Body.String()).Should(ContainSubstring("got 1 answers for 2 queries"))
+	})
+
+	It("rejects empty sequences and empty queries", func() {
+		Expect(post(`{"table": {"Room": ["Kitchen"]}, "queries": []}`).Code).Should(Equal(http.StatusBadRequest))
+
+		rec := post(`{"table": {"Room": ["Kitchen"]}, "queries": ["first", "  "]}`)
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"field":"queries[1]"`))
+		Expect(received).Should(BeNil())
+	})
+
+	It("returns the payload instead of calling the model in a dry run", func() {
+		body := `{"table": {"Room": ["Kitchen"]}, "queries": ["first", "second"]}`
+		expected := `{"table": {"Room": ["Kitchen"]}, "query": ["first", "second"], "parameters": {"sequential": true}}`
+
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-sequence?dry_run=true", strings.NewReader(body)))
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Header().Get("X-Dry-Run")).Should(Equal("true"))
+		Expect(rec.Body.String()).Should(MatchJSON(expected))
+
+		server.DryRun = true
+		rec = post(body)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(expected))
+		Expect(received).Should(BeNil())
+	})
+
+	It("reports models without sequential mode", func() {
+		server = &main.Server{Model: &fakeModel{}}
+		rec := post(`{"table": {"Room": ["Kitchen"]}, "queries": ["first"]}`)
+		Expect(rec.Code).Should(Equal(http.StatusNotImplemented))
+	})
+})
@@ -0,0 +1,839 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"a21hc3NpZ25tZW50/tableqa"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxUploadSize bounds the body of a /ask-upload request when
+// Server.MaxUploadSize is zero.
+const DefaultMaxUploadSize = 10 << 20
+
+// DefaultMaxBodySize bounds the JSON body of the other ask endpoints when
+// Server.MaxBodySize is zero.
+const DefaultMaxBodySize = 1 << 20
+
+const csvMIME = "text/csv"
+
+// csvContentTypes are the upload content types accepted as CSV. Browsers on
+// Windows report .csv files as application/vnd.ms-excel.
+var csvContentTypes = map[string]bool{
+	"text/csv":                 true,
+	"application/csv":          true,
+	"text/plain":               true,
+	"application/vnd.ms-excel": true,
+}
+
+// Server holds the dependencies of the HTTP handlers.
+type Server struct {
+	// Model answers queries. When nil, each request is answered by a
+	// HuggingFaceModel built from Connector and Token, which is the only
+	// backend that honors the per-request "model" and ?use_cache options.
+	Model TableQAModel
+	// Connector is copied per request so each request can pick its own model.
+	Connector *AIModelConnector
+	Token     string
+	// Data is the CSV table queried by /ask.
+	Data *TableCache
+	// Tables are the named tables /ask queries when given a "table".
+	Tables *TableRegistry
+	// Files, when set, lets /ask query the CSV files in a directory by a
+	// "file" name.
+	Files *DataDir
+	// Sessions, when set, keeps the tables uploaded with POST /tables,
+	// which /ask queries when given their "table_id".
+	Sessions *SessionTables
+	// IndexPath is the HTML page served at /; it defaults to "index.html".
+	IndexPath string
+	// MaxUploadSize limits /ask-upload bodies in bytes.
+	MaxUploadSize int64
+	// MaxBodySize limits the JSON bodies of /ask, /ask-json, /ask-batch and
+	// /ask-sequence in bytes.
+	MaxBodySize int64
+	// TableLimits rejects oversized tables before they reach the model; nil
+	// uses DefaultTableLimits. Config sets it from MAX_TABLE_ROWS,
+	// MAX_TABLE_COLUMNS and MAX_TABLE_CELLS.
+	TableLimits *TableLimits
+	// Logger receives structured request logs; it defaults to slog.Default().
+	Logger *slog.Logger
+	// RedactQueries keeps query text out of the logs.
+	RedactQueries bool
+	// Metrics, when set, is updated by the handlers and served at /metrics.
+	Metrics *Metrics
+	// Retry overrides DefaultRetryPolicy for calls to the model.
+	Retry *RetryPolicy
+	// APIKeys, when set, requires one of its keys on the ask endpoints,
+	// /tables and /validate-csv; health checks, /version and /metrics stay
+	// open.
+	APIKeys *APIKeys
+	// CSVFetcher, when set, lets /ask query the CSV at a "csv_url" on the
+	// hosts it allows.
+	CSVFetcher *CSVFetcher
+	// RateLimiter, when set, limits the ask endpoints per client IP.
+	RateLimiter *RateLimiter
+	// Breaker, when set, answers 503 without calling the model while the
+	// model is down.
+	Breaker *CircuitBreaker
+	// Concurrency, when set, bounds the model calls in flight at once,
+	// answering 503 to the calls it turns away.
+	Concurrency *ConcurrencyLimiter
+	// CORS, when set, lets browser scripts on the origins it allows call
+	// the server.
+	CORS *CORS
+	// Answers, when set, caches answers so a repeated query about the
+	// same table is not sent to the model again; ?no_cache=true skips it.
+	Answers *AnswerCache
+	// Normalize, when set, cleans up cell whitespace with NormalizeTable
+	// before tables are sent to the model.
+	Normalize *NormalizeOptions
+	// Round, when set, rounds the decimals in numeric columns with
+	// RoundNumericColumns before tables are sent to the model.
+	Round *RoundOptions
+	// MaxQueryLength bounds queries in characters; it defaults to
+	// DefaultMaxQueryLength.
+	MaxQueryLength int
+	// DryRun makes every ask endpoint behave as if ?dry_run=true was given.
+	DryRun bool
+	// BatchConcurrency bounds the model calls made at once for one
+	// /ask-batch request; it defaults to DefaultBatchConcurrency.
+	BatchConcurrency int
+	// RequestDeadline bounds the time spent answering an ask request,
+	// including retried model calls; a model call still running when it
+	// passes is canceled and answered with 504. Zero leaves only the
+	// connector's client timeout per call.
+	RequestDeadline time.Duration
+	// Debug allows ?debug=true, which adds the inference API's raw response
+	// body to answers as "upstream_response". It is meant for development
+	// and should stay off in production.
+	Debug bool
+	// IndexColumn names a column, e.g. an ID, whose value labels each
+	// resolved cell in verbose and CSV answers. Tables without the column
+	// are answered without labels; ?index_column overrides it per request.
+	IndexColumn string
+	// TrustedProxies are the addresses and CIDR ranges whose
+	// X-Forwarded-For and X-Real-IP headers name the client, which the rate
+	// limiter keys on. Without any, the client is the connection's peer.
+	TrustedProxies []string
+}
+
+// Router returns a Gin engine with all routes registered.
+func (s *Server) Router() *gin.Engine {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(s.TrustedProxies); err != nil {
+		logger.Error("ignoring invalid trusted proxies", "error", err.Error())
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(gin.CustomRecovery(recoverJSON), requestLogger(logger), s.CORS.middleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, errorJSON(c, CodeNotFound, fmt.Sprintf("No route for %s %s", c.Request.Method, c.Request.URL.Path)))
+	})
+
+	indexPath := s.IndexPath
+	if indexPath == "" {
+		indexPath = "index.html"
+	}
+
+	// Serve the HTML file at the root route
+	router.LoadHTMLFiles(indexPath)
+
+	router.GET("/", func(c *gin.Context) {
+		c.HTML(http.StatusOK, filepath.Base(indexPath), nil)
+	})
+
+	router.GET("/healthz", s.handleHealthz)
+	router.GET("/readyz", s.handleReadyz)
+	router.GET("/version", s.handleVersion)
+
+	auth := s.APIKeys.middleware()
+	router.GET("/tables", auth, s.handleTables)
+	router.POST("/tables", auth, s.handleUploadTable)
+	router.POST("/validate-csv", auth, s.handleValidateCSV)
+
+	if s.Metrics != nil {
+		s.Metrics.trackBreaker(s.Breaker)
+		s.Metrics.trackConcurrency(s.Concurrency)
+		router.GET("/metrics", s.Metrics.Handler())
+	}
+
+	middleware := []gin.HandlerFunc{s.Metrics.countRequests(), auth, s.RateLimiter.middleware(), requestDeadline(s.RequestDeadline)}
+	if isMock(s.Model) {
+		middleware = append(middleware, markMock)
+	}
+	ask := router.Group("/", middleware...)
+	ask.POST("/ask", s.handleAsk)
+	ask.POST("/ask-upload", s.handleAskUpload)
+	ask.POST("/ask-json", s.handleAskJSON)
+	ask.POST("/ask-batch", s.handleAskBatch)
+	ask.POST("/ask-sequence", s.handleAskSequence)
+
+	return router
+}
+
+// handleAsk answers a query against the server's CSV, against one of
+// s.Tables when the body names a "table", against a table uploaded with POST
+// /tables by its "table_id", against a CSV in s.Files named by "file", or
+// against the CSV at a "csv_url" that s.CSVFetcher allows. Optional
+// "filters" narrow the table down to the matching rows before it is sent to
+// the model, and optional "columns" then keep only the named columns, in that
+// order. The answer is JSON unless the Accept header asks for text/csv.
+func (s *Server) handleAsk(c *gin.Context) {
+	format := c.NegotiateFormat(gin.MIMEJSON, csvMIME)
+	if format == "" {
+		c.JSON(http.StatusNotAcceptable, errorJSON(c, CodeUnsupportedMedia, fmt.Sprintf("Unsupported Accept %q, expected %s or %s", c.GetHeader("Accept"), gin.MIMEJSON, csvMIME)))
+		return
+	}
+
+	// Get query from request body
+	var jsonData struct {
+		Query   string      `json:"query"`
+		Model   string      `json:"model"`
+		Table   string      `json:"table"`
+		TableID string      `json:"table_id"`
+		File    string      `json:"file"`
+		Filters []RowFilter `json:"filters"`
+		Columns []string    `json:"columns"`
+		CSVURL  string      `json:"csv_url"`
+	}
+	if !s.bindJSON(c, &jsonData) {
+		return
+	}
+
+	var (
+		table   map[string][]string
+		headers []string
+		err     error
+	)
+	if nonEmpty(jsonData.Table, jsonData.TableID, jsonData.File, jsonData.CSVURL) > 1 {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, `Give at most one of "table", "table_id", "file" and "csv_url"`))
+		return
+	}
+	if jsonData.TableID != "" {
+		var ok bool
+		if table, headers, ok = s.Sessions.Get(jsonData.TableID); !ok {
+			body := errorJSON(c, CodeTableNotFound, fmt.Sprintf("Table %q not found; it may have expired, upload it again", jsonData.TableID))
+			body.Error.Field = "table_id"
+			c.JSON(http.StatusNotFound, body)
+			return
+		}
+	} else if jsonData.CSVURL != "" {
+		if table, headers, err = s.CSVFetcher.Fetch(c.Request.Context(), jsonData.CSVURL, s.maxUploadSize()); err != nil {
+			s.writeFetchError(c, err)
+			return
+		}
+	} else {
+		data := s.Data
+		if jsonData.File != "" {
+			if data, err = s.Files.Open(jsonData.File); err != nil {
+				s.writeFileError(c, jsonData.File, err)
+				return
+			}
+		} else if jsonData.Table != "" {
+			var ok bool
+			if data, ok = s.Tables.Get(jsonData.Table); !ok {
+				body := errorJSON(c, CodeTableNotFound, fmt.Sprintf("Table %q not found", jsonData.Table))
+				body.Error.Field = "table"
+				body.Error.Details = gin.H{"tables": s.Tables.Names()}
+				c.JSON(http.StatusNotFound, body)
+				return
+			}
+		}
+
+		// Load CSV data
+		if data == nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(c, CodeDataUnavailable, "No CSV file is configured"))
+			return
+		}
+		if table, headers, err = data.Get(); err != nil {
+			s.Metrics.csvParseFailed()
+			writeDataError(c, "CSV file", err)
+			return
+		}
+	}
+
+	for _, filter := range jsonData.Filters {
+		if table, err = filter.Apply(table); err != nil {
+			body := errorJSON(c, CodeInvalidTable, err.Error())
+			body.Error.Field = "filters"
+			c.JSON(http.StatusBadRequest, body)
+			return
+		}
+	}
+	if len(jsonData.Filters) > 0 && len(table[headers[0]]) == 0 {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidTable, "No rows match the filters"))
+		return
+	}
+	if len(jsonData.Columns) > 0 {
+		if table, err = ProjectTable(table, jsonData.Columns); err != nil {
+			body := errorJSON(c, CodeInvalidTable, err.Error())
+			body.Error.Field = "columns"
+			body.Error.Details = gin.H{"columns": headers}
+			c.JSON(http.StatusBadRequest, body)
+			return
+		}
+		headers = jsonData.Columns
+	}
+
+	opts, ok := newAskOptions(c, jsonData.Model)
+	if !ok {
+		return
+	}
+	opts.csv = format == csvMIME
+	s.answer(c, Inputs{Table: table, Query: jsonData.Query, Columns: headers}, opts)
+}
+
+// nonEmpty counts the values that are not "".
+func nonEmpty(values ...string) int {
+	n := 0
+	for _, value := range values {
+		if value != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// handleAskUpload answers a query against a CSV sent as the "file" field of a
+// multipart form, alongside "query" and an optional "model".
+func (s *Server) handleAskUpload(c *gin.Context) {
+	table, headers, ok := s.readUpload(c)
+	if !ok {
+		return
+	}
+
+	inputs := Inputs{Table: table, Query: c.Request.FormValue("query"), Columns: headers}
+	opts, ok := newAskOptions(c, c.Request.FormValue("model"))
+	if !ok {
+		return
+	}
+	s.answer(c, inputs, opts)
+}
+
+// readUpload parses the "file" field of a multipart form as a CSV, or with
+// JSONToTable when it is sent as application/json. Gzipped files are
+// decompressed first, up to MaxUploadSize bytes. Binary files, such as an
+// .xlsx sent as application/vnd.ms-excel, are rejected before parsing. It
+// writes an error response and returns false when the file cannot be read.
+func (s *Server) readUpload(c *gin.Context) (map[string][]string, []string, bool) {
+	maxSize := s.maxUploadSize()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		writeUploadError(c, err, maxSize)
+		return nil, nil, false
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil || !(csvContentTypes[mediaType] || gzipContentTypes[mediaType] || mediaType == "application/json") {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeUnsupportedMedia, fmt.Sprintf("Unsupported content type %q, expected text/csv or application/json", header.Header.Get("Content-Type"))))
+		return nil, nil, false
+	}
+
+	rowData, err := ioutil.ReadAll(file)
+	if err == nil {
+		rowData, err = Decompress(rowData, maxSize)
+	}
+	if err != nil {
+		writeUploadError(c, err, maxSize)
+		return nil, nil, false
+	}
+
+	var (
+		table   map[string][]string
+		headers []string
+	)
+	if mediaType == "application/json" {
+		table, headers, err = JSONToTable(rowData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(c, CodeJSONTable, fmt.Sprintf("Error converting JSON to table: %v", err)))
+			return nil, nil, false
+		}
+	} else {
+		if !LooksLikeText(rowData) {
+			c.JSON(http.StatusBadRequest, errorJSON(c, CodeUnsupportedMedia, "Uploaded file is not a text CSV; export spreadsheets as CSV before uploading"))
+			return nil, nil, false
+		}
+		table, headers, err = CsvToSliceOrdered(string(rowData))
+		if err != nil {
+			s.Metrics.csvParseFailed()
+			body := csvErrorJSON(c, err)
+			body.Error.Message = fmt.Sprintf("Error converting CSV to slice: %v", err)
+			c.JSON(http.StatusBadRequest, body)
+			return nil, nil, false
+		}
+	}
+
+	return table, headers, true
+}
+
+// handleAskJSON answers a query against a table sent inline in the body, in
+// the same shape as Inputs plus an optional "model".
+func (s *Server) handleAskJSON(c *gin.Context) {
+	var jsonData struct {
+		Inputs
+		Model string `json:"model"`
+	}
+	if !s.bindJSON(c, &jsonData) {
+		return
+	}
+
+	opts, ok := newAskOptions(c, jsonData.Model)
+	if !ok {
+		return
+	}
+	s.answer(c, jsonData.Inputs, opts)
+}
+
+// askOptions are the per-request settings shared by the ask endpoints.
+type askOptions struct {
+	// model overrides the connector's model when set. A model the client
+	// asked for is never swapped for a fallback.
+	model string
+	// verbose adds the resolved cells to the response (?verbose=true).
+	verbose bool
+	// clean adds the answer as cleaned by CleanAnswer alongside the raw one
+	// (?clean=true).
+	clean bool
+	// interpret adds a yes/no reading of the answer to yes/no queries with
+	// InterpretAnswer (?interpret=true).
+	interpret bool
+	// disableCache asks Hugging Face not to serve a cached answer
+	// (?use_cache=false).
+	disableCache bool
+	// noCache answers from the model even if Server.Answers has the answer;
+	// the new answer still replaces the cached one (?no_cache=true).
+	noCache bool
+	// dryRun returns the payload instead of sending it (?dry_run=true).
+	dryRun bool
+	// csv writes the answer as CSV; only /ask negotiates it from the Accept
+	// header.
+	csv bool
+	// indexColumn labels resolved cells with their row's value in this
+	// column (?index_column=ID), overriding Server.IndexColumn.
+	indexColumn string
+	// debug adds the model's raw response body to the answer when
+	// Server.Debug allows it (?debug=true). /ask-batch rejects it.
+	debug bool
+	// preview, when positive, adds the table's first rows to the response
+	// (?preview=N). /ask-batch rejects it.
+	preview int
+	// maxRows, when positive, cuts the table to its first rows instead of
+	// rejecting it for its size (?max_rows=N).
+	maxRows int
+}
+
+// newAskOptions reads the query parameters of c alongside model, which each
+// endpoint takes from its own body format. It writes an error response and
+// returns false when a parameter is invalid.
+func newAskOptions(c *gin.Context, model string) (askOptions, bool) {
+	opts := askOptions{model: model}
+	if value := c.Query("max_rows"); value != "" {
+		maxRows, err := strconv.Atoi(value)
+		if err != nil || maxRows < 1 {
+			c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("Invalid max_rows %q", value)))
+			return opts, false
+		}
+		opts.maxRows = maxRows
+	}
+	if value := c.Query("preview"); value != "" {
+		preview, err := strconv.Atoi(value)
+		if err != nil || preview < 1 || preview > MaxPreviewRows {
+			c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("Invalid preview %q, expected 1 to %d rows", value, MaxPreviewRows)))
+			return opts, false
+		}
+		opts.preview = preview
+	}
+	opts.verbose, _ = strconv.ParseBool(c.Query("verbose"))
+	opts.clean, _ = strconv.ParseBool(c.Query("clean"))
+	opts.interpret, _ = strconv.ParseBool(c.Query("interpret"))
+	if useCache, err := strconv.ParseBool(c.Query("use_cache")); err == nil {
+		opts.disableCache = !useCache
+	}
+	opts.noCache, _ = strconv.ParseBool(c.Query("no_cache"))
+	opts.dryRun, _ = strconv.ParseBool(c.Query("dry_run"))
+	opts.debug, _ = strconv.ParseBool(c.Query("debug"))
+	opts.indexColumn = c.Query("index_column")
+	return opts, true
+}
+
+// answer sends the query about the table in inputs to the model and writes
+// the response.
+func (s *Server) answer(c *gin.Context, inputs Inputs, opts askOptions) {
+	if opts.debug && !s.Debug {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, "Debug responses are disabled on this server"))
+		return
+	}
+	var ok bool
+	if inputs.Query, ok = s.checkQuery(c, inputs.Query); !ok {
+		return
+	}
+	inputs.Table = s.prepareTable(inputs.Table)
+	var truncation *Truncation
+	if rows := tableqa.TableRows(inputs.Table); opts.maxRows > 0 && rows > opts.maxRows {
+		inputs.Table = TruncateTable(inputs.Table, opts.maxRows)
+		truncation = &Truncation{Truncated: true, OriginalRows: rows}
+	}
+	if !s.checkRequest(c, inputs.Table, opts.model) {
+		return
+	}
+	index, ok := s.indexColumn(c, inputs.Table, opts)
+	if !ok {
+		return
+	}
+	if s.DryRun || opts.dryRun {
+		s.writeDryRun(c, inputs, opts)
+		return
+	}
+	if !s.checkToken(c) {
+		return
+	}
+
+	// ?debug=true records the model's reply, which the answer cache cannot
+	// provide
+	ctx := c.Request.Context()
+	var raw *tableqa.RawResponse
+	if opts.debug {
+		ctx, raw = tableqa.ContextWithRawResponse(ctx)
+		opts.noCache = true
+	}
+
+	response, err := s.callModel(ctx, inputs, opts)
+	if err != nil {
+		s.writeModelError(c, err)
+		return
+	}
+	response.Candidates = response.CandidateAnswers()
+	upstream := json.RawMessage(raw.Body())
+
+	// Accept: text/csv gets the answer and the selected cells as CSV
+	if opts.csv {
+		enriched, err := response.EnrichWithIndex(inputs.Table, inputs.ColumnOrder(), index)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, errorJSON(c, CodeInvalidUpstreamResponse, fmt.Sprintf("AI model returned invalid coordinates: %v", err)))
+			return
+		}
+		var buf bytes.Buffer
+		if err := enriched.WriteCSV(&buf); err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(c, CodeInternal, fmt.Sprintf("Error writing CSV: %v", err)))
+			return
+		}
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+		return
+	}
+
+	var preview *TablePreview
+	if opts.preview > 0 {
+		preview = NewTablePreview(inputs.Table, inputs.ColumnOrder(), opts.preview)
+	}
+
+	// Send response back to front-end
+	body, err := answerBody(inputs, response, opts, index, truncation, preview, upstream)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, errorJSON(c, CodeInvalidUpstreamResponse, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// answerBody returns the JSON answer to inputs with the additions opts asks
+// for. It fails when the cells of the answer cannot be resolved against the
+// table for ?verbose=true.
+func answerBody(inputs Inputs, response Response, opts askOptions, index string, truncation *Truncation, preview *TablePreview, upstream json.RawMessage) (interface{}, error) {
+	interpretation := newInterpretation(inputs.Query, response, opts)
+
+	// ?verbose=true adds the selected cells resolved against the table
+	if opts.verbose {
+		enriched, err := response.EnrichWithIndex(inputs.Table, inputs.ColumnOrder(), index)
+		if err != nil {
+			return nil, fmt.Errorf("AI model returned invalid coordinates: %v", err)
+		}
+		return struct {
+			EnrichedResponse
+			*Truncation
+			*cleaned
+			Interpretation *Interpretation `json:"interpretation,omitempty"`
+			Preview        *TablePreview   `json:"preview,omitempty"`
+			Upstream       json.RawMessage `json:"upstream_response,omitempty"`
+		}{enriched, truncation, newCleaned(response, opts), interpretation, preview, upstream}, nil
+	}
+
+	return struct {
+		Response
+		*Truncation
+		*cleaned
+		Interpretation *Interpretation `json:"interpretation,omitempty"`
+		Preview        *TablePreview   `json:"preview,omitempty"`
+		Upstream       json.RawMessage `json:"upstream_response,omitempty"`
+	}{response, truncation, newCleaned(response, opts), interpretation, preview, upstream}, nil
+}
+
+// writeModelError responds to a failed model call with upstreamStatus.
+func (s *Server) writeModelError(c *gin.Context, err error) {
+	// Pass on how long the upstream asked us to wait, so clients can retry
+	// without guessing.
+	var hinted interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hinted) && hinted.RetryAfter() > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(hinted.RetryAfter().Seconds()))))
+	}
+	c.JSON(upstreamStatus(err), errorJSON(c, upstreamCode(err), "Error connecting to AI model: "+tableqa.RedactToken(err.Error(), s.Token)))
+}
+
+// indexColumn returns the column that labels resolved cells: the request's
+// index_column, which must be in table, or else s.IndexColumn if table has
+// it. It writes an error response and returns false for an unknown
+// index_column.
+func (s *Server) indexColumn(c *gin.Context, table map[string][]string, opts askOptions) (string, bool) {
+	if opts.indexColumn != "" {
+		if _, ok := table[opts.indexColumn]; !ok {
+			c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("Unknown index column %q", opts.indexColumn)))
+			return "", false
+		}
+		return opts.indexColumn, true
+	}
+	if _, ok := table[s.IndexColumn]; ok && s.IndexColumn != "" {
+		return s.IndexColumn, true
+	}
+	return "", true
+}
+
+// prepareTable applies the server's optional preprocessing to table. It never
+// modifies table itself, which may be shared with the CSV cache.
+func (s *Server) prepareTable(table map[string][]string) map[string][]string {
+	if s.Normalize != nil {
+		table = NormalizeTable(table, *s.Normalize)
+	}
+	if s.Round != nil {
+		table = RoundNumericColumns(table, *s.Round)
+	}
+	return table
+}
+
+// checkQuery returns query cleaned up by ValidateQuery, or writes an error
+// response and returns false when it is empty or too long.
+func (s *Server) checkQuery(c *gin.Context, query string) (string, bool) {
+	query, err := ValidateQuery(query, s.maxQueryLength())
+	if err != nil {
+		body := errorJSON(c, CodeInvalidQuery, err.Error())
+		body.Error.Field = "query"
+		c.JSON(http.StatusBadRequest, body)
+		return "", false
+	}
+	return query, true
+}
+
+// bindJSON decodes the JSON body of the request into v, reading at most
+// MaxBodySize bytes. It writes an error response and returns false when the
+// body is too large or invalid; the response names the offending "field"
+// when there is one.
+func (s *Server) bindJSON(c *gin.Context, v interface{}) bool {
+	limit := s.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+
+	if err := c.ShouldBindJSON(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, errorJSON(c, CodeTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit)))
+			return false
+		}
+		message, field := describeBindError(err)
+		body := errorJSON(c, CodeInvalidRequest, message)
+		body.Error.Field = field
+		c.JSON(http.StatusBadRequest, body)
+		return false
+	}
+	return true
+}
+
+func (s *Server) maxQueryLength() int {
+	if s.MaxQueryLength <= 0 {
+		return DefaultMaxQueryLength
+	}
+	return s.MaxQueryLength
+}
+
+func (s *Server) maxUploadSize() int64 {
+	if s.MaxUploadSize <= 0 {
+		return DefaultMaxUploadSize
+	}
+	return s.MaxUploadSize
+}
+
+// checkRequest writes an error response and returns false when table cannot
+// be sent to model.
+func (s *Server) checkRequest(c *gin.Context, table map[string][]string, model string) bool {
+	if err := ValidateTable(table); err != nil {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidTable, err.Error()))
+		return false
+	}
+
+	if model != "" {
+		if err := ValidateModel(model); err != nil {
+			body := errorJSON(c, CodeInvalidModel, err.Error())
+			body.Error.Field = "model"
+			c.JSON(http.StatusBadRequest, body)
+			return false
+		}
+	}
+
+	limits := DefaultTableLimits
+	if s.TableLimits != nil {
+		limits = *s.TableLimits
+	}
+	if err := limits.Validate(table); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, errorJSON(c, CodeTooLarge, err.Error()))
+		return false
+	}
+
+	return true
+}
+
+// checkToken writes an error response and returns false when the Hugging
+// Face backend is used without a token.
+func (s *Server) checkToken(c *gin.Context) bool {
+	if s.Model == nil && s.Token == "" {
+		c.JSON(http.StatusServiceUnavailable, errorJSON(c, CodeTokenMissing, "HUGGINGFACE_TOKEN is not set in the environment"))
+		return false
+	}
+	return true
+}
+
+// writeDryRun responds with the body that would be sent to the model for
+// inputs, an Inputs or SequentialInputs, byte for byte, and the model URL in
+// the X-Model-URL header.
+func (s *Server) writeDryRun(c *gin.Context, inputs interface{}, opts askOptions) {
+	connector, url, ok := s.dryRunConnector(c, opts)
+	if !ok {
+		return
+	}
+	payload, err := connector.BuildPayload(inputs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorJSON(c, CodeInternal, fmt.Sprintf("Error building payload: %v", err)))
+		return
+	}
+
+	c.Header("X-Dry-Run", "true")
+	c.Header("X-Model-URL", url)
+	c.Data(http.StatusOK, "application/json", payload)
+}
+
+// dryRunConnector returns the connector that would send a request with opts
+// and its model URL. It writes an error response and returns false when the
+// model is invalid.
+func (s *Server) dryRunConnector(c *gin.Context, opts askOptions) (*AIModelConnector, string, bool) {
+	var connector AIModelConnector
+	if s.Connector != nil {
+		connector = *s.Connector
+	}
+	if opts.model != "" {
+		connector.Model = opts.model
+	}
+
+	url, err := connector.ModelURL()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidModel, err.Error()))
+		return nil, "", false
+	}
+	return &connector, url, true
+}
+
+// callModel sends inputs to the model, unless s.Answers has the answer.
+func (s *Server) callModel(ctx context.Context, inputs Inputs, opts askOptions) (Response, error) {
+	model := s.modelFor(opts)
+
+	loggedQuery := inputs.Query
+	if s.RedactQueries {
+		loggedQuery = "[redacted]"
+	}
+	logger := LoggerFromContext(ctx)
+
+	var key string
+	if s.Answers != nil {
+		key = answerKey(modelLabel(model), inputs)
+		if !opts.noCache {
+			response, ok := s.Answers.Get(key)
+			s.Metrics.answerCacheLookup(ok)
+			if ok {
+				logger.Info("answered query from cache", "query", loggedQuery, "model", modelLabel(model))
+				return response, nil
+			}
+		}
+	}
+
+	if err := s.Concurrency.Acquire(ctx); err != nil {
+		s.Metrics.concurrencyRejected(err)
+		return Response{}, err
+	}
+	defer s.Concurrency.Release()
+	if err := s.Breaker.Allow(); err != nil {
+		s.Metrics.breakerRejected()
+		return Response{}, err
+	}
+
+	// Connect to AI model
+	start := time.Now()
+	response, err := model.Answer(ctx, inputs)
+	s.Breaker.Done(err)
+	s.Metrics.observeUpstream(s.metricsLabel(model), time.Since(start), err)
+	logger.Info("answered query",
+		"query", loggedQuery,
+		"model", modelLabel(model),
+		"ok", err == nil,
+	)
+	if err != nil {
+		return response, err
+	}
+	if response.Warning = response.ConsistencyWarning(); response.Warning != "" {
+		logger.Warn("answer disagrees with its cells", "model", modelLabel(model), "warning", response.Warning)
+	}
+	if s.Answers != nil {
+		s.Answers.Add(key, response)
+	}
+	return response, nil
+}
+
+// modelFor returns the model that answers a request made with opts.
+func (s *Server) modelFor(opts askOptions) TableQAModel {
+	if s.Model != nil {
+		return s.Model
+	}
+
+	connector := *s.Connector
+	if opts.model != "" {
+		connector.Model = opts.model
+		connector.Fallbacks = nil
+	}
+	if opts.disableCache {
+		connector.DisableCache = true
+	}
+
+	policy := DefaultRetryPolicy
+	if s.Retry != nil {
+		policy = *s.Retry
+	}
+
+	return &HuggingFaceModel{Connector: connector, Token: s.Token, Retry: policy}
+}
@@ -0,0 +1,712 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// newModelServer returns a fake inference API that records the last payload
+// it received and answers with the given JSON.
+func newModelServer(answer string, received *main.Inputs) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if received != nil {
+			json.Unmarshal(body, received)
+		}
+		w.Write([]byte(answer))
+	}))
+}
+
+// fakeModel is a TableQAModel that records the inputs it is asked about and
+// returns a fixed answer, so handler tests need no inference API at all.
+type fakeModel struct {
+	mu       sync.Mutex
+	received []main.Inputs
+	response main.Response
+	err      error
+}
+
+func (m *fakeModel) Answer(ctx context.Context, inputs main.Inputs) (main.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = append(m.received, inputs)
+	return m.response, m.err
+}
+
+func newMultipartRequest(path, contentType, csv string, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="data.csv"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	Expect(err).ShouldNot(HaveOccurred())
+	part.Write([]byte(csv))
+
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	Expect(writer.Close()).Should(Succeed())
+
+	req := httptest.NewRequest(http.MethodPost, path, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+var _ = Describe("Server", func() {
+	Describe("POST /ask", func() {
+		It("queries the loaded CSV", func() {
+			model := &fakeModel{response: main.Response{Answer: "Lamp"}}
+
+			path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+			Expect(os.WriteFile(path, []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+			data, err := main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+			server := &main.Server{Model: model, Data: data}
+
+			req := httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"query": "What is in the bedroom?"}`))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(ContainSubstring(`"answer":"Lamp"`))
+			Expect(model.received).Should(Equal([]main.Inputs{{
+				Table:   map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}},
+				Query:   "What is in the bedroom?",
+				Columns: []string{"Appliance", "Room"},
+			}}))
+		})
+
+		It("answers 400 when the loaded CSV has lost its rows", func() {
+			model := &fakeModel{response: main.Response{Answer: "Lamp"}}
+
+			path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+			Expect(os.WriteFile(path, []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+			data, err := main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+			server := &main.Server{Model: model, Data: data}
+			Expect(os.WriteFile(path, []byte("Appliance,Room\n"), 0o600)).Should(Succeed())
+
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"query": "What is in the bedroom?"}`)))
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			var body main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+			Expect(body.Error.Code).Should(Equal(main.CodeEmptyCSV))
+			Expect(body.Error.Message).Should(ContainSubstring("at least one row of data"))
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("resolves coordinates against the CSV header order", func() {
+			model := &fakeModel{response: main.Response{Answer: "Bedroom", Coordinates: [][]int{{0, 1}}}}
+
+			path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+			Expect(os.WriteFile(path, []byte("Room,Appliance\nBedroom,Lamp\n"), 0o600)).Should(Succeed())
+			data, err := main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+			server := &main.Server{Model: model, Data: data}
+
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask?verbose=true", strings.NewReader(`{"query": "Which lamp?"}`)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(ContainSubstring(`"resolved_cells":[{"row":0,"column":1,"header":"Appliance","value":"Lamp"}]`))
+		})
+
+		Describe("?preview", func() {
+			var server *main.Server
+
+			BeforeEach(func() {
+				model := &fakeModel{response: main.Response{Answer: "Lamp"}}
+				path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+				Expect(os.WriteFile(path, []byte("Room,Appliance\nBedroom,Lamp\nKitchen,Fridge\nHall,Heater\n"), 0o600)).Should(Succeed())
+				data, err := main.NewTableCache(path)
+				Expect(err).ShouldNot(HaveOccurred())
+				server = &main.Server{Model: model, Data: data}
+			})
+
+			ask := func(path string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"query": "Which lamp?"}`)))
+				return rec
+			}
+
+			It("adds the first rows in CSV column order", func() {
+				rec := ask("/ask?preview=2")
+
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+				Expect(rec.Body.String()).Should(MatchJSON(`{
+					"answer": "Lamp",
+					"coordinates": null,
+					"cells": null,
+					"aggregator": "",
+					"candidates": [{"answer": "Lamp", "coordinates": null, "cells": null, "aggregator": ""}],
+					"preview": {
+						"columns": ["Room", "Appliance"],
+						"rows": [["Bedroom", "Lamp"], ["Kitchen", "Fridge"]],
+						"total_rows": 3
+					}
+				}`))
+			})
+
+			It("returns the whole table when it is shorter", func() {
+				rec := ask("/ask?preview=10&verbose=true")
+
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+				Expect(rec.Body.String()).Should(ContainSubstring(`"preview":{"columns":["Room","Appliance"],"rows":[["Bedroom","Lamp"],["Kitchen","Fridge"],["Hall","Heater"]],"total_rows":3}`))
+			})
+
+			It("is off by default", func() {
+				Expect(ask("/ask").Body.String()).ShouldNot(ContainSubstring("preview"))
+			})
+
+			It("rejects invalid row counts", func() {
+				for _, value := range []string{"0", "-1", "many", "101"} {
+					Expect(ask("/ask?preview="+value).Code).Should(Equal(http.StatusBadRequest), value)
+				}
+			})
+		})
+
+		Describe("content negotiation", func() {
+			var server *main.Server
+
+			BeforeEach(func() {
+				model := &fakeModel{response: main.Response{Answer: "SUM > 1.2, 0.8", Coordinates: [][]int{{0, 1}, {1, 1}}, Aggregator: "SUM"}}
+				path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+				Expect(os.WriteFile(path, []byte("Appliance,Energy_Consumption\nFridge,1.2\nTV,0.8\n"), 0o600)).Should(Succeed())
+				data, err := main.NewTableCache(path)
+				Expect(err).ShouldNot(HaveOccurred())
+				server = &main.Server{Model: model, Data: data}
+			})
+
+			ask := func(accept string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"query": "Total consumption?"}`))
+				if accept != "" {
+					req.Header.Set("Accept", accept)
+				}
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, req)
+				return rec
+			}
+
+			It("answers with JSON by default", func() {
+				for _, accept := range []string{"", "application/json", "*/*", "text/html, application/json;q=0.9"} {
+					rec := ask(accept)
+					Expect(rec.Code).Should(Equal(http.StatusOK), accept)
+					Expect(rec.Header().Get("Content-Type")).Should(HavePrefix("application/json"), accept)
+				}
+			})
+
+			It("answers with CSV rows when asked for text/csv", func() {
+				rec := ask("text/csv")
+
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+				Expect(rec.Header().Get("Content-Type")).Should(HavePrefix("text/csv"))
+				Expect(rec.Body.String()).Should(Equal("answer,aggregator,row,column,header,value\n" +
+					"\"SUM > 1.2, 0.8\",SUM,0,1,Energy_Consumption,1.2\n" +
+					"\"SUM > 1.2, 0.8\",SUM,1,1,Energy_Consumption,0.8\n"))
+			})
+
+			It("rejects unsupported Accept values with 406", func() {
+				rec := ask("application/xml")
+				Expect(rec.Code).Should(Equal(http.StatusNotAcceptable))
+			})
+		})
+
+		It("reports backend errors from a custom model", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+			Expect(os.WriteFile(path, []byte("Appliance\nLamp\n"), 0o600)).Should(Succeed())
+			data, err := main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+			server := &main.Server{Model: &fakeModel{err: errors.New("backend down")}, Data: data}
+
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"query": "q"}`)))
+
+			Expect(rec.Code).Should(Equal(http.StatusInternalServerError))
+			Expect(rec.Body.String()).Should(ContainSubstring("backend down"))
+		})
+	})
+
+	Describe("POST /ask-upload", func() {
+		var (
+			received main.Inputs
+			model    *httptest.Server
+			server   *main.Server
+		)
+
+		BeforeEach(func() {
+			received = main.Inputs{}
+			model = newModelServer(`{"answer": "1.2", "coordinates": [[0, 1]], "cells": ["1.2"], "aggregator": "NONE"}`, &received)
+			server = &main.Server{Connector: newServerConnector(model), Token: "token"}
+		})
+
+		AfterEach(func() {
+			model.Close()
+		})
+
+		It("answers a query about the uploaded CSV", func() {
+			req := newMultipartRequest("/ask-upload", "text/csv", "Appliance,Energy_Consumption\nTV,1.2",
+				map[string]string{"query": "How much does the TV use?"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			var response main.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+			Expect(response.Answer).Should(Equal("1.2"))
+			Expect(received).Should(Equal(main.Inputs{
+				Table: map[string][]string{"Appliance": {"TV"}, "Energy_Consumption": {"1.2"}},
+				Query: "How much does the TV use?",
+			}))
+		})
+
+		It("forwards quoted fields to the model intact", func() {
+			csv := "Appliance,Note\n\"Lamp, desk\",\"says \"\"on\"\"\nat night\"\n"
+			req := newMultipartRequest("/ask-upload", "text/csv", csv, map[string]string{"query": "Which lamp?"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(received.Table).Should(Equal(map[string][]string{
+				"Appliance": {"Lamp, desk"},
+				"Note":      {"says \"on\"\nat night"},
+			}))
+		})
+
+		It("rejects empty and header-only uploads", func() {
+			for _, csv := range []string{"", "Appliance,Energy_Consumption\n"} {
+				req := newMultipartRequest("/ask-upload", "text/csv", csv, map[string]string{"query": "q"})
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, req)
+
+				Expect(rec.Code).Should(Equal(http.StatusBadRequest), "%q", csv)
+				var body main.ErrorResponse
+				Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+				Expect(body.Error.Code).Should(Equal(main.CodeEmptyCSV))
+			}
+			Expect(received).Should(Equal(main.Inputs{}))
+		})
+
+		It("rejects non-CSV content types", func() {
+			req := newMultipartRequest("/ask-upload", "image/png", "\x89PNG", map[string]string{"query": "q"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		})
+
+		It("rejects uploads over the size limit", func() {
+			server.MaxUploadSize = 1024
+			csv := "a\n" + strings.Repeat("1\n", 2048)
+			req := newMultipartRequest("/ask-upload", "text/csv", csv, map[string]string{"query": "q"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusRequestEntityTooLarge))
+			Expect(rec.Body.String()).Should(ContainSubstring(fmt.Sprint(1024)))
+		})
+
+		It("rejects binary files sent as CSV", func() {
+			xlsx := "PK\x03\x04\x14\x00\x06\x00\x08\x00\x00\x00!\x00b\xee\x9dh^\x01\x00\x00\x90\x04\x00\x00\x13\x00\x08\x02[Content_Types].xml"
+			req := newMultipartRequest("/ask-upload", "application/vnd.ms-excel", xlsx, map[string]string{"query": "q"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring("not a text CSV"))
+		})
+
+		It("rejects malformed CSV uploads", func() {
+			req := newMultipartRequest("/ask-upload", "text/csv", "a,b\n1,2,3", map[string]string{"query": "q"})
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("POST /ask-json", func() {
+		var (
+			received main.Inputs
+			model    *httptest.Server
+			server   *main.Server
+		)
+
+		BeforeEach(func() {
+			received = main.Inputs{}
+			model = newModelServer(`{"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}`, &received)
+			server = &main.Server{Connector: newServerConnector(model), Token: "token"}
+		})
+
+		AfterEach(func() {
+			model.Close()
+		})
+
+		It("forwards a well-formed inline table to the model", func() {
+			body := `{"table": {"Appliance": ["TV", "Lamp"], "Room": ["Living Room", "Bedroom"]}, "query": "Which appliance is in the living room?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(ContainSubstring(`"answer":"TV"`))
+			Expect(received.Table).Should(Equal(map[string][]string{
+				"Appliance": {"TV", "Lamp"},
+				"Room":      {"Living Room", "Bedroom"},
+			}))
+		})
+
+		It("includes the raw model response only with ?debug=true on a debug server", func() {
+			body := `{"table": {"Appliance": ["TV", "Lamp"]}, "query": "Which appliance?"}`
+			post := func(path string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+				return rec
+			}
+
+			rec := post("/ask-json")
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("upstream_response"))
+
+			rec = post("/ask-json?debug=true")
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring("Debug responses are disabled"))
+
+			server.Debug = true
+			rec = post("/ask-json?debug=true")
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(MatchJSON(`{
+				"answer": "TV",
+				"coordinates": [[0, 0]],
+				"cells": ["TV"],
+				"aggregator": "NONE",
+				"candidates": [{"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}],
+				"upstream_response": {"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}
+			}`))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("token"))
+		})
+
+		It("resolves the selected cells with ?verbose=true", func() {
+			body := `{"table": {"Room": ["Living Room", "Bedroom"], "Appliance": ["TV", "Lamp"]}, "query": "Which appliance is in the living room?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json?verbose=true", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(MatchJSON(`{
+				"answer": "TV",
+				"coordinates": [[0, 0]],
+				"cells": ["TV"],
+				"aggregator": "NONE",
+				"candidates": [{"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}],
+				"resolved_cells": [{"row": 0, "column": 0, "header": "Appliance", "value": "TV"}]
+			}`))
+		})
+
+		It("adds the cleaned answer with ?clean=true", func() {
+			body := `{"table": {"Room": ["Living Room", "Bedroom"], "Appliance": ["TV", "Lamp"]}, "query": "Which appliance is in the living room?"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json?clean=true", strings.NewReader(body)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(MatchJSON(`{
+				"answer": "TV",
+				"coordinates": [[0, 0]],
+				"cells": ["TV"],
+				"aggregator": "NONE",
+				"candidates": [{"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}],
+				"clean_answer": "TV"
+			}`))
+		})
+
+		It("adds a yes/no reading of the answer with ?interpret=true", func() {
+			server = &main.Server{Model: &fakeModel{response: main.Response{Answer: "SUM > 60, 50", Cells: []string{"60", "50"}, Aggregator: "SUM"}}}
+			body := `{"table": {"Appliance": ["TV", "Oven"], "Energy": ["60", "50"]}, "query": "Is the total energy above 100?"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json?interpret=true", strings.NewReader(body)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			var response struct {
+				Interpretation main.Interpretation `json:"interpretation"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+			Expect(response.Interpretation.Yes).Should(BeTrue())
+			Expect(*response.Interpretation.Value).Should(BeNumerically("==", 110))
+			Expect(response.Interpretation.Operator).Should(Equal(">"))
+
+			body = `{"table": {"Appliance": ["TV", "Oven"]}, "query": "Which appliance?"}`
+			rec = httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json?interpret=true", strings.NewReader(body)))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("interpretation"))
+		})
+
+		It("labels resolved cells with the ?index_column value of their row", func() {
+			body := `{"table": {"ID": ["A-1", "A-2"], "Appliance": ["TV", "Lamp"]}, "query": "Which appliance?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json?verbose=true&index_column=ID", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			var response main.EnrichedResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+			Expect(response.IndexColumn).Should(Equal("ID"))
+			Expect(response.ResolvedCells).Should(Equal([]main.ResolvedCell{
+				{Row: 0, Column: 0, Header: "Appliance", Value: "TV", Index: "A-1"},
+			}))
+		})
+
+		It("uses the server's index column for tables that have it", func() {
+			server.IndexColumn = "ID"
+			for body, index := range map[string]string{
+				`{"table": {"ID": ["A-1"], "Appliance": ["TV"]}, "query": "Which appliance?"}`: "A-1",
+				`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`:                "",
+			} {
+				req := httptest.NewRequest(http.MethodPost, "/ask-json?verbose=true", strings.NewReader(body))
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, req)
+
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+				var response main.EnrichedResponse
+				Expect(json.Unmarshal(rec.Body.Bytes(), &response)).Should(Succeed())
+				Expect(response.ResolvedCells[0].Index).Should(Equal(index), body)
+			}
+		})
+
+		It("rejects an unknown ?index_column before calling the model", func() {
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json?verbose=true&index_column=ID", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring(`Unknown index column \"ID\"`))
+			Expect(received.Query).Should(BeEmpty())
+		})
+
+		It("keeps the plain response shape by default", func() {
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("resolved_cells"))
+		})
+
+		It("rejects a table whose columns differ in length", func() {
+			body := `{"table": {"Appliance": ["TV", "Lamp"], "Room": ["Living Room"]}, "query": "Which appliance is in the living room?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring("expected 2 rows but Room has 1"))
+			Expect(received.Query).Should(BeEmpty())
+		})
+
+		It("rejects a table over the size limits with 413", func() {
+			server.TableLimits = &main.TableLimits{MaxRows: 1}
+			body := `{"table": {"Appliance": ["TV", "Lamp"]}, "query": "Which appliances are there?"}`
+			req := httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, req)
+
+			Expect(rec.Code).Should(Equal(http.StatusRequestEntityTooLarge))
+			Expect(received.Query).Should(BeEmpty())
+		})
+
+		It("applies the table limits set in the environment", func() {
+			ask := func(vars map[string]string) int {
+				vars["HUGGINGFACE_TOKEN"] = "hf_test"
+				cfg, err := main.LoadConfig(env(vars))
+				Expect(err).ShouldNot(HaveOccurred())
+				server := cfg.Server(nil, nil)
+				server.Model = &fakeModel{response: main.Response{Answer: "TV"}}
+
+				body := `{"table": {"Appliance": ["TV", "Lamp", "Fan"]}, "query": "Which appliances are there?"}`
+				rec := httptest.NewRecorder()
+				server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+				return rec.Code
+			}
+
+			Expect(ask(map[string]string{})).Should(Equal(http.StatusOK))
+			Expect(ask(map[string]string{"MAX_TABLE_ROWS": "2"})).Should(Equal(http.StatusRequestEntityTooLarge))
+			Expect(ask(map[string]string{"MAX_TABLE_CELLS": "2"})).Should(Equal(http.StatusRequestEntityTooLarge))
+		})
+	})
+
+	Describe("invalid request bodies", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "TV"}}
+			server = &main.Server{Model: model}
+		})
+
+		post := func(path, body string) (int, main.ErrorDetail) {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			var decoded main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &decoded)).Should(Succeed())
+			return rec.Code, decoded.Error
+		}
+
+		It("reports where malformed JSON breaks", func() {
+			code, body := post("/ask-json", `{"query": "Which?", "table": {"Appliance": ["TV"],}}`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(HavePrefix("Malformed JSON at byte 51: "))
+			Expect(body.Field).Should(BeEmpty())
+
+			code, body = post("/ask-json", `{"query": "Which?", "table": `)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(Equal("Malformed JSON: the body ends before the value is complete"))
+
+			code, body = post("/ask", ``)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(Equal("Request body is empty, expected a JSON object"))
+		})
+
+		It("names fields of the wrong type", func() {
+			code, body := post("/ask", `{"query": 42}`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(Equal(`Invalid value for "query": expected a string, got number`))
+			Expect(body.Field).Should(Equal("query"))
+
+			code, body = post("/ask-json", `{"query": "Which?", "table": {"Appliance": "TV"}}`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(Equal(`Invalid value for "table.Appliance": expected an array, got string`))
+			Expect(body.Field).Should(Equal("table.Appliance"))
+
+			code, body = post("/ask-batch", `{"table": {"Appliance": ["TV"]}, "queries": "Which?"}`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Field).Should(Equal("queries"))
+
+			code, body = post("/ask", `["Which?"]`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(ContainSubstring("expected an object, got array"))
+		})
+
+		It("names a missing query", func() {
+			code, body := post("/ask-json", `{"table": {"Appliance": ["TV"]}}`)
+			Expect(code).Should(Equal(http.StatusBadRequest))
+			Expect(body.Message).Should(Equal(main.ErrEmptyQuery.Error()))
+			Expect(body.Code).Should(Equal(main.CodeInvalidQuery))
+			Expect(body.Field).Should(Equal("query"))
+			Expect(model.received).Should(BeEmpty())
+		})
+	})
+
+	Describe("request body limits", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "TV"}}
+			server = &main.Server{Model: model, MaxBodySize: 256}
+		})
+
+		post := func(path, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			return rec
+		}
+
+		It("rejects over-limit JSON bodies with 413 before calling the model", func() {
+			table := `{"Appliance": ["` + strings.Repeat("TV", 200) + `"]}`
+			for path, body := range map[string]string{
+				"/ask":       `{"query": "` + strings.Repeat("q", 300) + `"}`,
+				"/ask-json":  `{"table": ` + table + `, "query": "Which appliance?"}`,
+				"/ask-batch": `{"table": ` + table + `, "queries": ["Which appliance?"]}`,
+			} {
+				rec := post(path, body)
+				Expect(rec.Code).Should(Equal(http.StatusRequestEntityTooLarge), path)
+				Expect(rec.Body.String()).Should(ContainSubstring("Request body exceeds 256 bytes"), path)
+			}
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("accepts bodies within the limit", func() {
+			rec := post("/ask-json", `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`)
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+		})
+
+		It("still reports malformed bodies as bad requests", func() {
+			rec := post("/ask-json", `{"table": `)
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		})
+
+		It("defaults to DefaultMaxBodySize", func() {
+			server.MaxBodySize = 0
+			body := `{"table": {"Appliance": ["` + strings.Repeat("x", main.DefaultMaxBodySize) + `"]}, "query": "Which appliance?"}`
+			Expect(post("/ask-json", body).Code).Should(Equal(http.StatusRequestEntityTooLarge))
+		})
+	})
+
+	Describe("dry run", func() {
+		It("returns exactly the payload the real call sends, without calling the model", func() {
+			var sent []byte
+			calls := 0
+			model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				sent, _ = ioutil.ReadAll(r.Body)
+				w.Write([]byte(`{"answer": "TV"}`))
+			}))
+			defer model.Close()
+			server := &main.Server{Connector: newServerConnector(model), Token: "token"}
+
+			body := `{"table": {"Room": ["Living Room"], "Appliance": ["TV"]}, "query": "Which appliance?"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json?dry_run=true", strings.NewReader(body)))
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Header().Get("X-Dry-Run")).Should(Equal("true"))
+			Expect(rec.Header().Get("X-Model-URL")).Should(HaveSuffix("/models/google/tapas-base-finetuned-wtq"))
+			Expect(calls).Should(BeZero())
+
+			expected, err := json.Marshal(main.Inputs{
+				Table: map[string][]string{"Room": {"Living Room"}, "Appliance": {"TV"}},
+				Query: "Which appliance?",
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rec.Body.Bytes()).Should(Equal(expected))
+
+			server.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(calls).Should(Equal(1))
+			Expect(sent).Should(Equal(rec.Body.Bytes()))
+		})
+
+		It("does not need a token", func() {
+			server := &main.Server{Connector: &main.AIModelConnector{}, DryRun: true}
+
+			body := `{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).Should(MatchJSON(body))
+		})
+	})
+})
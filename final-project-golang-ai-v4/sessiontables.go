@@ -0,0 +1,151 @@
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSessionTableTTL is how long an uploaded table is kept after its last
+// use when SESSION_TABLE_TTL is unset.
+const DefaultSessionTableTTL = 30 * time.Minute
+
+// SessionTables keeps tables uploaded with POST /tables in memory, so a client
+// can ask many questions about a table by its ID without sending it again.
+// It holds at most size tables, evicting the least recently used, and forgets
+// each table ttl after it was last uploaded or queried. IDs are random, so
+// knowing one is what gives access to its table. It is safe for concurrent
+// use, and a nil *SessionTables keeps nothing.
+type SessionTables struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of *sessionTable, most recently used first
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type sessionTable struct {
+	id      string
+	table   map[string][]string
+	headers []string
+	expires time.Time
+}
+
+// UploadedTable describes a table stored by POST /tables.
+type UploadedTable struct {
+	ID string `json:"table_id"`
+	TableSchema
+	// ExpiresAt is when the table is forgotten unless it is queried before.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewSessionTables holds up to size tables for ttl each.
+func NewSessionTables(size int, ttl time.Duration) *SessionTables {
+	return &SessionTables{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Add stores table, with its columns in headers order, under a new ID and
+// returns the ID with the time the table expires.
+func (s *SessionTables) Add(table map[string][]string, headers []string) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, fmt.Errorf("generating table ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(s.ttl)
+	s.entries[id] = s.order.PushFront(&sessionTable{id: id, table: table, headers: headers, expires: expires})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*sessionTable).id)
+	}
+	return id, expires, nil
+}
+
+// Get returns the table stored under id and its headers, if it has not
+// expired, and keeps it for another ttl.
+func (s *SessionTables) Get(id string) (map[string][]string, []string, bool) {
+	if s == nil {
+		return nil, nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[id]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := elem.Value.(*sessionTable)
+	now := s.now()
+	if !now.Before(entry.expires) {
+		s.order.Remove(elem)
+		delete(s.entries, id)
+		return nil, nil, false
+	}
+	entry.expires = now.Add(s.ttl)
+	s.order.MoveToFront(elem)
+	return entry.table, entry.headers, true
+}
+
+// Len returns the number of stored tables, including expired ones not yet
+// evicted.
+func (s *SessionTables) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// handleUploadTable stores a table sent like the file of /ask-upload and
+// answers 201 with its ID, which /ask takes as "table_id", and its schema.
+func (s *Server) handleUploadTable(c *gin.Context) {
+	if s.Sessions == nil {
+		c.JSON(http.StatusNotImplemented, errorJSON(c, CodeNotSupported, "Uploading tables is disabled on this server"))
+		return
+	}
+	table, headers, ok := s.readUpload(c)
+	if !ok {
+		return
+	}
+	if err := ValidateTable(table); err != nil {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidTable, err.Error()))
+		return
+	}
+
+	id, expires, err := s.Sessions.Add(table, headers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorJSON(c, CodeInternal, err.Error()))
+		return
+	}
+
+	types := InferColumnTypes(table)
+	columns := make([]ColumnSchema, len(headers))
+	for i, name := range headers {
+		columns[i] = ColumnSchema{Name: name, Type: types[name]}
+	}
+	c.JSON(http.StatusCreated, UploadedTable{
+		ID:          id,
+		TableSchema: TableSchema{Columns: columns, Rows: len(table[headers[0]])},
+		ExpiresAt:   expires.UTC(),
+	})
+}
@@ -0,0 +1,164 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SessionTables", func() {
+	table := map[string][]string{"Appliance": {"TV"}}
+	headers := []string{"Appliance"}
+
+	It("returns stored tables until they expire", func() {
+		sessions := main.NewSessionTables(10, 20*time.Millisecond)
+		id, expires, err := sessions.Add(table, headers)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(id).Should(HaveLen(32))
+		Expect(expires).Should(BeTemporally("~", time.Now().Add(20*time.Millisecond), 10*time.Millisecond))
+
+		stored, storedHeaders, ok := sessions.Get(id)
+		Expect(ok).Should(BeTrue())
+		Expect(stored).Should(Equal(table))
+		Expect(storedHeaders).Should(Equal(headers))
+
+		_, _, ok = sessions.Get("unknown")
+		Expect(ok).Should(BeFalse())
+
+		Eventually(func() bool {
+			_, _, ok := sessions.Get(id)
+			return ok
+		}).WithPolling(50 * time.Millisecond).Should(BeFalse())
+		Expect(sessions.Len()).Should(BeZero())
+	})
+
+	It("keeps tables that are in use", func() {
+		sessions := main.NewSessionTables(10, 50*time.Millisecond)
+		id, _, err := sessions.Add(table, headers)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Consistently(func() bool {
+			_, _, ok := sessions.Get(id)
+			return ok
+		}, 150*time.Millisecond, 10*time.Millisecond).Should(BeTrue())
+	})
+
+	It("gives every table its own ID", func() {
+		sessions := main.NewSessionTables(10, time.Hour)
+		first, _, err := sessions.Add(table, headers)
+		Expect(err).ShouldNot(HaveOccurred())
+		second, _, err := sessions.Add(table, headers)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(first).ShouldNot(Equal(second))
+	})
+
+	It("evicts the least recently used table when full", func() {
+		sessions := main.NewSessionTables(2, time.Hour)
+		a, _, _ := sessions.Add(table, headers)
+		b, _, _ := sessions.Add(table, headers)
+		_, _, _ = sessions.Get(a)
+		_, _, _ = sessions.Add(table, headers)
+
+		Expect(sessions.Len()).Should(Equal(2))
+		_, _, ok := sessions.Get(b)
+		Expect(ok).Should(BeFalse())
+		_, _, ok = sessions.Get(a)
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("keeps nothing when nil", func() {
+		var sessions *main.SessionTables
+		_, _, ok := sessions.Get("id")
+		Expect(ok).Should(BeFalse())
+		Expect(sessions.Len()).Should(BeZero())
+	})
+
+	Context("in the server", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "Lamp"}}
+			server = &main.Server{Model: model, Sessions: main.NewSessionTables(10, time.Hour)}
+		})
+
+		upload := func(csv string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, newMultipartRequest("/tables", "text/csv", csv, nil))
+			return rec
+		}
+
+		ask := func(body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(body)))
+			return rec
+		}
+
+		It("answers queries about an uploaded table by its ID", func() {
+			rec := upload("Appliance,Watts\nLamp,40\nTV,120\n")
+			Expect(rec.Code).Should(Equal(http.StatusCreated))
+			var uploaded main.UploadedTable
+			Expect(json.Unmarshal(rec.Body.Bytes(), &uploaded)).Should(Succeed())
+			Expect(uploaded.ID).ShouldNot(BeEmpty())
+			Expect(uploaded.Rows).Should(Equal(2))
+			Expect(uploaded.Columns).Should(Equal([]main.ColumnSchema{
+				{Name: "Appliance", Type: main.ColumnType{Kind: main.KindText, Confidence: 1}},
+				{Name: "Watts", Type: main.ColumnType{Kind: main.KindInteger, Confidence: 1}},
+			}))
+			Expect(uploaded.ExpiresAt).Should(BeTemporally(">", time.Now()))
+
+			for _, query := range []string{"Which lamp?", "What uses 120 watts?"} {
+				rec = ask(`{"query": "` + query + `", "table_id": "` + uploaded.ID + `"}`)
+				Expect(rec.Code).Should(Equal(http.StatusOK))
+			}
+			Expect(model.received).Should(Equal([]main.Inputs{
+				{Table: map[string][]string{"Appliance": {"Lamp", "TV"}, "Watts": {"40", "120"}}, Query: "Which lamp?", Columns: []string{"Appliance", "Watts"}},
+				{Table: map[string][]string{"Appliance": {"Lamp", "TV"}, "Watts": {"40", "120"}}, Query: "What uses 120 watts?", Columns: []string{"Appliance", "Watts"}},
+			}))
+		})
+
+		It("answers 404 for unknown and expired IDs", func() {
+			server.Sessions = main.NewSessionTables(10, 20*time.Millisecond)
+			var uploaded main.UploadedTable
+			Expect(json.Unmarshal(upload("Appliance\nLamp\n").Body.Bytes(), &uploaded)).Should(Succeed())
+
+			time.Sleep(30 * time.Millisecond)
+			Expect(ask(`{"query": "Which lamp?", "table_id": "` + uploaded.ID + `"}`).Code).Should(Equal(http.StatusNotFound))
+
+			rec := ask(`{"query": "Which lamp?", "table_id": "0123456789abcdef"}`)
+			Expect(rec.Code).Should(Equal(http.StatusNotFound))
+			var body main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+			Expect(body.Error.Code).Should(Equal(main.CodeTableNotFound))
+			Expect(body.Error.Field).Should(Equal("table_id"))
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("rejects a table ID alongside another table", func() {
+			rec := ask(`{"query": "Which lamp?", "table_id": "0123456789abcdef", "table": "rooms"}`)
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring(main.CodeInvalidRequest))
+		})
+
+		It("rejects uploads that cannot be parsed", func() {
+			rec := upload("Appliance,Watts\n")
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring(main.CodeEmptyCSV))
+			Expect(server.Sessions.Len()).Should(BeZero())
+		})
+
+		It("answers 501 when uploading tables is disabled", func() {
+			server.Sessions = nil
+			Expect(upload("Appliance\nLamp\n").Code).Should(Equal(http.StatusNotImplemented))
+		})
+	})
+})
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownGracePeriod is how long in-flight requests may run after a
+// shutdown signal when SHUTDOWN_GRACE_PERIOD is not set.
+const DefaultShutdownGracePeriod = 15 * time.Second
+
+// Serve runs server on listener until ctx is done, then stops accepting new
+// connections and waits up to grace for in-flight requests to finish.
+func Serve(ctx context.Context, server *http.Server, listener net.Listener, grace time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", listener.Addr().String())
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "grace_period", grace.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown did not complete", "error", err.Error())
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
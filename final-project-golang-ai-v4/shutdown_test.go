@@ -0,0 +1,73 @@
+package main_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Serve", func() {
+	It("lets an in-flight request finish after shutdown starts", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		started := make(chan struct{})
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("done"))
+		})}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- main.Serve(ctx, server, listener, 5*time.Second) }()
+
+		responses := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := http.Get("http://" + listener.Addr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			responses <- string(body)
+		}()
+
+		<-started
+		cancel()
+
+		Eventually(responses, 2*time.Second).Should(Receive(Equal("done")))
+		Eventually(served, 2*time.Second).Should(Receive(BeNil()))
+
+		_, err = net.Dial("tcp", listener.Addr().String())
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("gives up on requests that outlast the grace period", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- main.Serve(ctx, server, listener, 50*time.Millisecond) }()
+		go http.Get("http://" + listener.Addr().String())
+
+		<-started
+		cancel()
+		Eventually(served, 2*time.Second).Should(Receive(MatchError(context.DeadlineExceeded)))
+	})
+})
@@ -0,0 +1,412 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func newTable(rows, columns int) map[string][]string {
+	table := make(map[string][]string, columns)
+	for c := 0; c < columns; c++ {
+		values := make([]string, rows)
+		for r := range values {
+			values[r] = fmt.Sprint(r)
+		}
+		table[fmt.Sprintf("col%d", c)] = values
+	}
+	return table
+}
+
+var _ = Describe("ValidateTableSize", func() {
+	limits := main.TableLimits{MaxRows: 10, MaxColumns: 4, MaxCells: 20}
+
+	It("accepts tables at the limits", func() {
+		Expect(limits.Validate(newTable(10, 2))).Should(Succeed())
+		Expect(limits.Validate(newTable(5, 4))).Should(Succeed())
+	})
+
+	It("rejects tables one past each limit", func() {
+		for _, table := range []map[string][]string{newTable(11, 1), newTable(1, 5), newTable(7, 3)} {
+			err := limits.Validate(table)
+			var tooLarge *main.TableTooLargeError
+			Expect(errors.As(err, &tooLarge)).Should(BeTrue())
+		}
+	})
+
+	It("uses the TAPAS defaults", func() {
+		Expect(main.ValidateTableSize(newTable(128, 4))).Should(Succeed())
+		Expect(main.ValidateTableSize(newTable(129, 4))).ShouldNot(Succeed())
+		Expect(main.ValidateTableSize(newTable(257, 1))).ShouldNot(Succeed())
+	})
+})
+
+var _ = Describe("ValidateTable", func() {
+	It("accepts columns of equal length", func() {
+		Expect(main.ValidateTable(newTable(3, 4))).Should(Succeed())
+		Expect(main.ValidateTable(map[string][]string{"a": {}, "b": {}})).Should(Succeed())
+	})
+
+	It("names the columns whose length differs", func() {
+		table := newTable(3, 3)
+		table["short"] = []string{"1"}
+		table["long"] = []string{"1", "2", "3", "4"}
+
+		err := main.ValidateTable(table)
+		var lengthErr *main.ColumnLengthError
+		Expect(errors.As(err, &lengthErr)).Should(BeTrue())
+		Expect(lengthErr.Expected).Should(Equal(3))
+		Expect(lengthErr.Columns).Should(Equal(map[string]int{"short": 1, "long": 4}))
+		Expect(err).Should(MatchError("table columns have different lengths: expected 3 rows but long has 4, short has 1"))
+	})
+
+	It("rejects an empty table", func() {
+		Expect(main.ValidateTable(map[string][]string{})).ShouldNot(Succeed())
+	})
+})
+
+var _ = Describe("FilterTable", func() {
+	table := map[string][]string{
+		"Region": {"EU", "US", "EU", "APAC"},
+		"City":   {"Berlin", "Boston", "Paris", "Tokyo"},
+		"Sales":  {"10", "20", "30", "40"},
+	}
+
+	It("keeps the matching rows with the columns aligned", func() {
+		filtered, err := main.FilterTable(table, "Region", func(value string) bool { return value == "EU" })
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(filtered).Should(Equal(map[string][]string{
+			"Region": {"EU", "EU"},
+			"City":   {"Berlin", "Paris"},
+			"Sales":  {"10", "30"},
+		}))
+		Expect(table["Region"]).Should(HaveLen(4))
+	})
+
+	It("can drop every row", func() {
+		filtered, err := main.FilterTable(table, "Region", func(string) bool { return false })
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(filtered).Should(Equal(map[string][]string{"Region": {}, "City": {}, "Sales": {}}))
+	})
+
+	It("fails for a missing column", func() {
+		_, err := main.FilterTable(table, "Country", func(string) bool { return true })
+		Expect(err).Should(MatchError(`filter column "Country" does not exist`))
+	})
+
+	It("backs the equals and contains filters of RowFilter", func() {
+		eu, us := "EU", "o"
+		filtered, err := main.RowFilter{Column: "Region", Equals: &eu}.Apply(table)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(filtered["City"]).Should(Equal([]string{"Berlin", "Paris"}))
+
+		filtered, err = main.RowFilter{Column: "City", Contains: &us}.Apply(table)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(filtered["City"]).Should(Equal([]string{"Boston", "Tokyo"}))
+
+		_, err = main.RowFilter{Column: "City"}.Apply(table)
+		Expect(err).Should(HaveOccurred())
+		_, err = main.RowFilter{Column: "City", Equals: &eu, Contains: &us}.Apply(table)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	Describe("POST /ask with filters", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "sales.csv")
+			Expect(os.WriteFile(path, []byte("Region,City,Sales\nEU,Berlin,10\nUS,Boston,20\nEU,Paris,30\n"), 0o600)).Should(Succeed())
+			data, err := main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+			model = &fakeModel{response: main.Response{Answer: "40"}}
+			server = &main.Server{Model: model, Data: data}
+		})
+
+		ask := func(body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(body)))
+			return rec
+		}
+
+		It("sends only the matching rows to the model", func() {
+			rec := ask(`{"query": "Total sales?", "filters": [{"column": "Region", "equals": "EU"}, {"column": "City", "contains": "par"}]}`)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(model.received).Should(HaveLen(1))
+			Expect(model.received[0].Table).Should(Equal(map[string][]string{"Region": {"EU"}, "City": {"Paris"}, "Sales": {"30"}}))
+		})
+
+		It("rejects filters on missing columns and filters matching nothing", func() {
+			Expect(ask(`{"query": "q", "filters": [{"column": "Country", "equals": "EU"}]}`).Code).Should(Equal(http.StatusBadRequest))
+			Expect(ask(`{"query": "q", "filters": [{"column": "Region", "equals": "APAC"}]}`).Code).Should(Equal(http.StatusBadRequest))
+			Expect(model.received).Should(BeEmpty())
+		})
+
+		It("sends only the requested columns, in the requested order", func() {
+			rec := ask(`{"query": "Total sales in the EU?", "filters": [{"column": "Region", "equals": "EU"}], "columns": ["Sales", "City"]}`)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(model.received).Should(Equal([]main.Inputs{{
+				Table:   map[string][]string{"Sales": {"10", "30"}, "City": {"Berlin", "Paris"}},
+				Query:   "Total sales in the EU?",
+				Columns: []string{"Sales", "City"},
+			}}))
+		})
+
+		It("rejects unknown columns and lists the available ones", func() {
+			rec := ask(`{"query": "q", "columns": ["City", "Country"]}`)
+
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).Should(ContainSubstring(`column \"Country\" does not exist`))
+			Expect(rec.Body.String()).Should(ContainSubstring(`"columns":["Region","City","Sales"]`))
+			Expect(model.received).Should(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("ProjectTable", func() {
+	table := map[string][]string{
+		"Region": {"EU", "US"},
+		"City":   {"Berlin", "Boston"},
+		"Sales":  {"10", "20"},
+	}
+
+	It("keeps the named columns", func() {
+		projected, err := main.ProjectTable(table, []string{"City", "Sales"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(projected).Should(Equal(map[string][]string{"City": {"Berlin", "Boston"}, "Sales": {"10", "20"}}))
+		Expect(table).Should(HaveLen(3))
+	})
+
+	It("fails for missing and repeated columns", func() {
+		_, err := main.ProjectTable(table, []string{"City", "Country"})
+		Expect(err).Should(MatchError(`column "Country" does not exist`))
+		_, err = main.ProjectTable(table, []string{"City", "City"})
+		Expect(err).Should(MatchError(`column "City" is listed twice`))
+	})
+})
+
+var _ = Describe("TruncateTable", func() {
+	It("keeps the first rows of every column", func() {
+		table := newTable(5, 3)
+		truncated := main.TruncateTable(table, 2)
+
+		Expect(truncated).Should(Equal(newTable(2, 3)))
+		Expect(table).Should(Equal(newTable(5, 3)))
+	})
+
+	It("leaves smaller tables whole", func() {
+		Expect(main.TruncateTable(newTable(2, 2), 10)).Should(Equal(newTable(2, 2)))
+	})
+
+	Describe("?max_rows", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			model = &fakeModel{response: main.Response{Answer: "0"}}
+			server = &main.Server{Model: model, TableLimits: &main.TableLimits{MaxRows: 100}}
+		})
+
+		ask := func(path string, rows int) *httptest.ResponseRecorder {
+			body, err := json.Marshal(main.Inputs{Table: newTable(rows, 2), Query: "q"})
+			Expect(err).ShouldNot(HaveOccurred())
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+			return rec
+		}
+
+		It("sends the first rows and flags the truncation", func() {
+			rec := ask("/ask-json?max_rows=100", 300)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(model.received[0].Table).Should(Equal(newTable(100, 2)))
+			Expect(rec.Body.String()).Should(MatchJSON(`{
+				"answer": "0",
+				"coordinates": null,
+				"cells": null,
+				"aggregator": "",
+				"candidates": [{"answer": "0", "coordinates": null, "cells": null, "aggregator": ""}],
+				"truncated": true,
+				"original_rows": 300
+			}`))
+		})
+
+		It("leaves the response unchanged when nothing was cut", func() {
+			rec := ask("/ask-json?max_rows=100", 10)
+
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+			Expect(rec.Body.String()).ShouldNot(ContainSubstring("truncated"))
+		})
+
+		It("still rejects oversized tables without it", func() {
+			Expect(ask("/ask-json", 300).Code).Should(Equal(http.StatusRequestEntityTooLarge))
+		})
+
+		It("rejects invalid values", func() {
+			Expect(ask("/ask-json?max_rows=0", 10).Code).Should(Equal(http.StatusBadRequest))
+			Expect(ask("/ask-json?max_rows=many", 10).Code).Should(Equal(http.StatusBadRequest))
+		})
+	})
+})
+
+var _ = Describe("NormalizeTable", func() {
+	table := map[string][]string{
+		"Appliance": {"  TV ", "Smart   Lamp", "\tRefrigerator\n"},
+		"Room":      {"Living Room", "", "   "},
+	}
+
+	It("trims and collapses whitespace without touching the input", func() {
+		normalized := main.NormalizeTable(table, main.NormalizeOptions{})
+
+		Expect(normalized).Should(Equal(map[string][]string{
+			"Appliance": {"TV", "Smart Lamp", "Refrigerator"},
+			"Room":      {"Living Room", "", ""},
+		}))
+		Expect(table["Appliance"][0]).Should(Equal("  TV "))
+	})
+
+	It("replaces empty cells with the placeholder", func() {
+		normalized := main.NormalizeTable(table, main.NormalizeOptions{EmptyPlaceholder: "N/A"})
+
+		Expect(normalized["Room"]).Should(Equal([]string{"Living Room", "N/A", "N/A"}))
+	})
+
+	It("is applied by the server only when configured", func() {
+		var received main.Inputs
+		model := newModelServer(`{"answer": "TV"}`, &received)
+		defer model.Close()
+
+		body := `{"table": {"Appliance": ["  Smart   Lamp "]}, "query": "Which appliance?"}`
+		ask := func(server *main.Server) {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+		}
+
+		ask(&main.Server{Connector: newServerConnector(model), Token: "token"})
+		Expect(received.Table["Appliance"]).Should(Equal([]string{"  Smart   Lamp "}))
+
+		ask(&main.Server{Connector: newServerConnector(model), Token: "token", Normalize: &main.NormalizeOptions{}})
+		Expect(received.Table["Appliance"]).Should(Equal([]string{"Smart Lamp"}))
+	})
+})
+
+var _ = Describe("RoundNumericColumns", func() {
+	table := map[string][]string{
+		"Appliance": {"TV", "Lamp", "Heater", "Fridge"},
+		"Price":     {"1,299.9951", "19.5", "-0.0004", "2.675"},
+		"Energy":    {"0.123456", "", "12", "99.999"},
+		"Model":     {"X-1.23456", "3.14159", "v2", "Pro"},
+	}
+
+	It("rounds the decimals of numeric columns and leaves text alone", func() {
+		rounded := main.RoundNumericColumns(table, main.RoundOptions{Precision: 2})
+
+		Expect(rounded).Should(Equal(map[string][]string{
+			"Appliance": {"TV", "Lamp", "Heater", "Fridge"},
+			"Price":     {"1,300.00", "19.5", "0.00", "2.68"},
+			"Energy":    {"0.12", "", "12", "100.00"},
+			"Model":     {"X-1.23456", "3.14159", "v2", "Pro"},
+		}))
+		Expect(table["Price"][0]).Should(Equal("1,299.9951"))
+	})
+
+	It("rounds to whole numbers with no digits", func() {
+		rounded := main.RoundNumericColumns(table, main.RoundOptions{})
+
+		Expect(rounded["Price"]).Should(Equal([]string{"1,300", "20", "0", "3"}))
+		Expect(rounded["Energy"]).Should(Equal([]string{"0", "", "12", "100"}))
+	})
+
+	It("is applied by the server only when configured", func() {
+		var received main.Inputs
+		model := newModelServer(`{"answer": "TV"}`, &received)
+		defer model.Close()
+
+		body := `{"table": {"Appliance": ["TV", "Lamp"], "Price": ["0.333333", "12.5"]}, "query": "Which appliance is cheapest?"}`
+		ask := func(server *main.Server) {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask-json", strings.NewReader(body)))
+			Expect(rec.Code).Should(Equal(http.StatusOK))
+		}
+
+		ask(&main.Server{Connector: newServerConnector(model), Token: "token"})
+		Expect(received.Table["Price"]).Should(Equal([]string{"0.333333", "12.5"}))
+
+		ask(&main.Server{Connector: newServerConnector(model), Token: "token", Round: &main.RoundOptions{Precision: 1}})
+		Expect(received.Table).Should(Equal(map[string][]string{"Appliance": {"TV", "Lamp"}, "Price": {"0.3", "12.5"}}))
+	})
+})
+
+var _ = Describe("JoinTables", func() {
+	appliances := map[string][]string{
+		"Appliance": {"TV", "Fridge", "Heater"},
+		"RoomID":    {"1", "2", "9"},
+	}
+	rooms := map[string][]string{
+		"RoomID": {"1", "2", "2", "3"},
+		"Room":   {"Living Room", "Kitchen", "Pantry", "Garage"},
+	}
+
+	It("joins matching rows, duplicating rows with several matches", func() {
+		joined, err := main.JoinTables(appliances, rooms, "RoomID")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(joined).Should(Equal(map[string][]string{
+			"Appliance": {"TV", "Fridge", "Fridge", "Heater"},
+			"RoomID":    {"1", "2", "2", "9"},
+			"Room":      {"Living Room", "Kitchen", "Pantry", ""},
+		}))
+		Expect(main.ValidateTable(joined)).Should(Succeed())
+		Expect(appliances["Appliance"]).Should(HaveLen(3))
+	})
+
+	It("leaves the right cells empty for unmatched rows", func() {
+		joined, err := main.JoinTables(appliances, map[string][]string{"RoomID": {"7"}, "Room": {"Attic"}}, "RoomID")
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(joined["Appliance"]).Should(Equal([]string{"TV", "Fridge", "Heater"}))
+		Expect(joined["Room"]).Should(Equal([]string{"", "", ""}))
+	})
+
+	It("renames right columns whose names are taken", func() {
+		joined, err := main.JoinTables(
+			map[string][]string{"ID": {"1"}, "Name": {"TV"}},
+			map[string][]string{"ID": {"1"}, "Name": {"Living Room"}},
+			"ID",
+		)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(joined).Should(Equal(map[string][]string{"ID": {"1"}, "Name": {"TV"}, "Name_2": {"Living Room"}}))
+	})
+
+	It("fails when either table lacks the key or is misaligned", func() {
+		_, err := main.JoinTables(appliances, map[string][]string{"Room": {"Kitchen"}}, "RoomID")
+		Expect(err).Should(MatchError(`join key "RoomID" does not exist in the right table`))
+
+		_, err = main.JoinTables(map[string][]string{"Room": {"Kitchen"}}, rooms, "RoomID")
+		Expect(err).Should(MatchError(`join key "RoomID" does not exist in the left table`))
+
+		var lengths *main.ColumnLengthError
+		_, err = main.JoinTables(appliances, map[string][]string{"RoomID": {"1", "2"}, "Room": {"Kitchen"}}, "RoomID")
+		Expect(errors.As(err, &lengths)).Should(BeTrue())
+	})
+})
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRefreshBackoff is the longest Refresh waits before trying again
+// to load a file that failed, unless its interval is longer.
+const DefaultMaxRefreshBackoff = 5 * time.Minute
+
+// TableCache holds the parsed contents of a CSV file and re-parses it only
+// when the file's modification time or size changes. It is safe for
+// concurrent use; callers must not modify the returned table.
+type TableCache struct {
+	Path string
+
+	mu      sync.RWMutex
+	loaded  *tableSnapshot
+	modTime time.Time
+	size    int64
+	// refreshing is set while Refresh runs: Get then returns the table it
+	// last loaded rather than checking the file itself.
+	refreshing bool
+}
+
+// tableSnapshot is one parse of the file, with the column types inferred
+// once so listing schemas stays cheap.
+type tableSnapshot struct {
+	table   map[string][]string
+	headers []string
+	types   map[string]ColumnType
+}
+
+// NewTableCache loads and parses the CSV file at path.
+func NewTableCache(path string) (*TableCache, error) {
+	cache := &TableCache{Path: path}
+	if _, _, err := cache.Get(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Get returns the parsed table and its headers in file order, reloading the
+// file first if it changed since the last load.
+func (c *TableCache) Get() (map[string][]string, []string, error) {
+	snapshot, err := c.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot.table, snapshot.headers, nil
+}
+
+// Schema returns the columns of the table in file order with their inferred
+// types, and its number of rows.
+func (c *TableCache) Schema() ([]ColumnSchema, int, error) {
+	snapshot, err := c.load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	columns := make([]ColumnSchema, len(snapshot.headers))
+	rows := 0
+	for i, name := range snapshot.headers {
+		columns[i] = ColumnSchema{Name: name, Type: snapshot.types[name]}
+		rows = len(snapshot.table[name])
+	}
+	return columns, rows, nil
+}
+
+func (c *TableCache) load() (*tableSnapshot, error) {
+	c.mu.RLock()
+	if c.refreshing && c.loaded != nil {
+		loaded := c.loaded
+		c.mu.RUnlock()
+		return loaded, nil
+	}
+	c.mu.RUnlock()
+
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	if c.loaded != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		loaded := c.loaded
+		c.mu.RUnlock()
+		return loaded, nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.loaded, nil
+	}
+
+	snapshot, err := parseTableFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	c.loaded = snapshot
+	c.modTime, c.size = info.ModTime(), info.Size()
+	return c.loaded, nil
+}
+
+// Refresh checks the file every interval until ctx is done, reloading it in
+// the background when its modification time or size changes, so requests
+// never wait for a parse. While it runs, a file that fails to load does not
+// take the table away: Get keeps returning the last good one, and the file
+// is tried again after a wait that doubles with each failure, up to
+// DefaultMaxRefreshBackoff. Reloads and failures are logged to logger, or
+// slog.Default() when it is nil.
+func (c *TableCache) Refresh(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	c.mu.Lock()
+	c.refreshing = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.refreshing = false
+		c.mu.Unlock()
+	}()
+
+	maxBackoff := max(interval, DefaultMaxRefreshBackoff)
+	wait, failed := interval, false
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		snapshot, err := c.reload(failed)
+		switch {
+		case err != nil:
+			wait, failed = min(2*wait, maxBackoff), true
+			logger.Warn("reloading data file failed, keeping the last table", "path", c.Path, "error", err.Error(), "retry_in", wait.String())
+		case snapshot != nil:
+			wait, failed = interval, false
+			logger.Info("reloaded data file", "path", c.Path, "columns", len(snapshot.headers), "rows", len(snapshot.table[snapshot.headers[0]]))
+		default:
+			wait, failed = interval, false
+		}
+		timer.Reset(wait)
+	}
+}
+
+// reload loads the file if it changed since the last load, or regardless
+// when force is set, and returns the new snapshot, or nil if it is
+// unchanged. The file is parsed without holding the lock, so Get is not
+// blocked meanwhile.
+func (c *TableCache) reload(force bool) (*tableSnapshot, error) {
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	unchanged := c.loaded != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size
+	c.mu.RUnlock()
+	if unchanged && !force {
+		return nil, nil
+	}
+
+	snapshot, err := parseTableFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.loaded = snapshot
+	c.modTime, c.size = info.ModTime(), info.Size()
+	c.mu.Unlock()
+	return snapshot, nil
+}
+
+// parseTableFile reads and parses the CSV file at path.
+func parseTableFile(path string) (*tableSnapshot, error) {
+	file, err := OpenDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	table, headers, err := CsvToSliceFromReader(file, CsvOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &tableSnapshot{table: table, headers: headers, types: InferColumnTypes(table)}, nil
+}
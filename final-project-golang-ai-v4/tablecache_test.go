@@ -0,0 +1,157 @@
+package main_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("TableCache", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+	})
+
+	It("returns the parsed table and header order", func() {
+		cache, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		table, headers, err := cache.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers).Should(Equal([]string{"Appliance", "Room"}))
+		Expect(table).Should(Equal(map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}}))
+	})
+
+	It("reloads the table when the file changes", func() {
+		cache, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(os.WriteFile(path, []byte("Appliance,Room\nTV,Living Room\n"), 0o600)).Should(Succeed())
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(path, later, later)).Should(Succeed())
+
+		table, _, err := cache.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(table["Appliance"]).Should(Equal([]string{"TV"}))
+	})
+
+	It("fails to load a malformed file", func() {
+		Expect(os.WriteFile(path, []byte("a,b\n1,2,3\n"), 0o600)).Should(Succeed())
+
+		_, err := main.NewTableCache(path)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	Context("refreshing in the background", func() {
+		var (
+			cache *main.TableCache
+			logs  *gbytes.Buffer
+			stop  context.CancelFunc
+			done  chan struct{}
+		)
+
+		// rewrite replaces the file, moving its modification time forward
+		// so the change is seen even within the file system's resolution.
+		rewrite := func(content string, age time.Duration) {
+			Expect(os.WriteFile(path, []byte(content), 0o600)).Should(Succeed())
+			modTime := time.Now().Add(age)
+			Expect(os.Chtimes(path, modTime, modTime)).Should(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			cache, err = main.NewTableCache(path)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			logs = gbytes.NewBuffer()
+			var ctx context.Context
+			ctx, stop = context.WithCancel(context.Background())
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				cache.Refresh(ctx, 10*time.Millisecond, slog.New(slog.NewTextHandler(logs, nil)))
+			}()
+		})
+
+		AfterEach(func() {
+			stop()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("reloads the file when it changes", func() {
+			rewrite("Appliance,Room\nTV,Living Room\n", time.Minute)
+			Eventually(logs).Should(gbytes.Say(`msg="reloaded data file".* columns=2 rows=1`))
+
+			table, _, err := cache.Get()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(table["Appliance"]).Should(Equal([]string{"TV"}))
+		})
+
+		It("keeps the last good table while the file is corrupt", func() {
+			rewrite("Appliance,Room\nTV,Living Room,Extra\n", time.Minute)
+			Eventually(logs).Should(gbytes.Say(`msg="reloading data file failed, keeping the last table".* retry_in=20ms`))
+			Eventually(logs).Should(gbytes.Say(`retry_in=40ms`))
+
+			table, headers, err := cache.Get()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(headers).Should(Equal([]string{"Appliance", "Room"}))
+			Expect(table).Should(Equal(map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}}))
+			_, rows, err := cache.Schema()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rows).Should(Equal(1))
+
+			rewrite("Appliance,Room\nTV,Living Room\n", 2*time.Minute)
+			Eventually(func() []string {
+				table, _, _ := cache.Get()
+				return table["Appliance"]
+			}).Should(Equal([]string{"TV"}))
+		})
+
+		It("checks the file on each Get again once stopped", func() {
+			stop()
+			Eventually(done).Should(BeClosed())
+
+			rewrite("Appliance,Room\nTV,Living Room,Extra\n", time.Minute)
+			_, _, err := cache.Get()
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
+
+func BenchmarkReadAndParseCSV(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		data, err := ioutil.ReadFile("data-series.csv")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := main.CsvToSlice(string(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTableCacheGet(b *testing.B) {
+	cache, err := main.NewTableCache("data-series.csv")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := cache.Get(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import "a21hc3NpZ25tZW50/tableqa"
+
+// The table question answering API lives in package tableqa, which other Go
+// programs can import. It is re-exported here so the server and existing
+// callers of package main keep working unchanged.
+
+type (
+	AIModelConnector      = tableqa.AIModelConnector
+	Inputs                = tableqa.Inputs
+	Response              = tableqa.Response
+	Candidate             = tableqa.Candidate
+	ResolvedCell          = tableqa.ResolvedCell
+	EnrichedResponse      = tableqa.EnrichedResponse
+	TableQAModel          = tableqa.TableQAModel
+	HuggingFaceModel      = tableqa.HuggingFaceModel
+	MockModel             = tableqa.MockModel
+	SequentialModel       = tableqa.SequentialModel
+	SequentialInputs      = tableqa.SequentialInputs
+	RetryPolicy           = tableqa.RetryPolicy
+	CsvOptions            = tableqa.CsvOptions
+	CsvLineError          = tableqa.CsvLineError
+	FieldCountError       = tableqa.FieldCountError
+	UpstreamError         = tableqa.UpstreamError
+	InvalidResponseError  = tableqa.InvalidResponseError
+	ResponseTooLargeError = tableqa.ResponseTooLargeError
+	AuthError             = tableqa.AuthError
+	ModelLoadingError     = tableqa.ModelLoadingError
+	TableLimits           = tableqa.TableLimits
+	TableTooLargeError    = tableqa.TableTooLargeError
+	ColumnLengthError     = tableqa.ColumnLengthError
+	NormalizeOptions      = tableqa.NormalizeOptions
+	RoundOptions          = tableqa.RoundOptions
+	RowFilter             = tableqa.RowFilter
+	ColumnKind            = tableqa.ColumnKind
+	ColumnType            = tableqa.ColumnType
+	Interpretation        = tableqa.Interpretation
+	ModelTask             = tableqa.ModelTask
+)
+
+const (
+	DefaultModel           = tableqa.DefaultModel
+	DefaultAPIBase         = tableqa.DefaultAPIBase
+	DefaultMaxResponseSize = tableqa.DefaultMaxResponseSize
+	DefaultRequestTimeout  = tableqa.DefaultRequestTimeout
+	DefaultMaxQueryLength  = tableqa.DefaultMaxQueryLength
+	DefaultMaxRetryDelay   = tableqa.DefaultMaxRetryDelay
+	RequestIDHeader        = tableqa.RequestIDHeader
+
+	TaskTableQA            = tableqa.TaskTableQA
+	TaskTextGeneration     = tableqa.TaskTextGeneration
+	TaskTextClassification = tableqa.TaskTextClassification
+
+	KindInteger = tableqa.KindInteger
+	KindNumeric = tableqa.KindNumeric
+	KindDate    = tableqa.KindDate
+	KindText    = tableqa.KindText
+)
+
+var (
+	ErrTokenMissing         = tableqa.ErrTokenMissing
+	ErrTokenMalformed       = tableqa.ErrTokenMalformed
+	ErrEmptyQuery           = tableqa.ErrEmptyQuery
+	ErrDecompressedTooLarge = tableqa.ErrDecompressedTooLarge
+	ErrEmptyCSV             = tableqa.ErrEmptyCSV
+	ErrNoDataRows           = tableqa.ErrNoDataRows
+
+	// DefaultRetryPolicy and DefaultTableLimits are copies of the tableqa
+	// values, so changing them here does not change the library's defaults.
+	DefaultRetryPolicy = tableqa.DefaultRetryPolicy
+	DefaultTableLimits = tableqa.DefaultTableLimits
+)
+
+var (
+	NewAIModelConnector = tableqa.NewAIModelConnector
+	NewTransport        = tableqa.NewTransport
+	ValidateModel       = tableqa.ValidateModel
+	ValidateAPIBase     = tableqa.ValidateAPIBase
+	ValidateToken       = tableqa.ValidateToken
+
+	TaskByName             = tableqa.TaskByName
+	TableQATask            = tableqa.TableQATask
+	TextGenerationTask     = tableqa.TextGenerationTask
+	TextClassificationTask = tableqa.TextClassificationTask
+
+	CsvToSlice                   = tableqa.CsvToSlice
+	CsvToSliceWithOptions        = tableqa.CsvToSliceWithOptions
+	CsvToSliceOrdered            = tableqa.CsvToSliceOrdered
+	CsvToSliceOrderedWithOptions = tableqa.CsvToSliceOrderedWithOptions
+	JSONToTable                  = tableqa.JSONToTable
+	Decompress                   = tableqa.Decompress
+	ReadDataFile                 = tableqa.ReadDataFile
+	OpenDataFile                 = tableqa.OpenDataFile
+	CsvToSliceFromReader         = tableqa.CsvToSliceFromReader
+	SliceToCsv                   = tableqa.SliceToCsv
+	LooksLikeText                = tableqa.LooksLikeText
+
+	ValidateQuery       = tableqa.ValidateQuery
+	ValidateTable       = tableqa.ValidateTable
+	ValidateTableSize   = tableqa.ValidateTableSize
+	TruncateTable       = tableqa.TruncateTable
+	NormalizeTable      = tableqa.NormalizeTable
+	RoundNumericColumns = tableqa.RoundNumericColumns
+	FilterTable         = tableqa.FilterTable
+	JoinTables          = tableqa.JoinTables
+	ProjectTable        = tableqa.ProjectTable
+	InferColumnTypes    = tableqa.InferColumnTypes
+	CleanAnswer         = tableqa.CleanAnswer
+	InterpretAnswer     = tableqa.InterpretAnswer
+
+	LoggerFromContext    = tableqa.LoggerFromContext
+	RequestIDFromContext = tableqa.RequestIDFromContext
+)
@@ -0,0 +1,65 @@
+package tableqa
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Candidate is one of several answers a model ranked for the same query.
+type Candidate struct {
+	Answer      string   `json:"answer"`
+	Coordinates [][]int  `json:"coordinates"`
+	Cells       []string `json:"cells"`
+	Aggregator  string   `json:"aggregator"`
+	// Score is the model's confidence in the candidate, if it reported one.
+	Score *float64 `json:"score,omitempty"`
+}
+
+// CandidateAnswers returns r.Candidates, or r's own answer as the only
+// candidate when the model gave a single one.
+func (r Response) CandidateAnswers() []Candidate {
+	if len(r.Candidates) > 0 {
+		return r.Candidates
+	}
+	return []Candidate{{Answer: r.Answer, Coordinates: r.Coordinates, Cells: r.Cells, Aggregator: r.Aggregator}}
+}
+
+// decodeCandidates decodes a body holding a list of answers, best first, as
+// ensembles and top-k deployments return. Each must be valid for
+// decodeAnswer; the Response is the first, with all of them as Candidates.
+func decodeCandidates(body []byte) (Response, error) {
+	var answers []json.RawMessage
+	if err := json.Unmarshal(body, &answers); err != nil {
+		return Response{}, &InvalidResponseError{Reason: "body is not a JSON list of answers", Body: truncateBody(body)}
+	}
+	if len(answers) == 0 {
+		return Response{}, &InvalidResponseError{Reason: "empty list of answers", Body: truncateBody(body)}
+	}
+
+	var top Response
+	candidates := make([]Candidate, len(answers))
+	for i, answer := range answers {
+		response, err := decodeAnswer(answer)
+		if err != nil {
+			return Response{}, fmt.Errorf("candidate %d: %w", i+1, err)
+		}
+		var scored struct {
+			Score *float64 `json:"score"`
+		}
+		if err := json.Unmarshal(answer, &scored); err != nil {
+			return Response{}, &InvalidResponseError{Reason: fmt.Sprintf("candidate %d: %v", i+1, err), Body: truncateBody(body)}
+		}
+		if i == 0 {
+			top = response
+		}
+		candidates[i] = Candidate{
+			Answer:      response.Answer,
+			Coordinates: response.Coordinates,
+			Cells:       response.Cells,
+			Aggregator:  response.Aggregator,
+			Score:       scored.Score,
+		}
+	}
+	top.Candidates = candidates
+	return top, nil
+}
@@ -0,0 +1,113 @@
+package tableqa
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnKind is the kind of value a column holds.
+type ColumnKind string
+
+const (
+	KindInteger ColumnKind = "integer"
+	KindNumeric ColumnKind = "numeric"
+	KindDate    ColumnKind = "date"
+	KindText    ColumnKind = "text"
+)
+
+// ColumnType is the dominant kind of a column's values and the fraction of
+// sampled non-empty values that match it.
+type ColumnType struct {
+	Kind       ColumnKind `json:"kind"`
+	Confidence float64    `json:"confidence"`
+}
+
+const (
+	// columnTypeSample is the most values inspected per column.
+	columnTypeSample = 200
+	// columnTypeThreshold is the share of values a kind needs to dominate,
+	// so a few outliers do not demote a numeric column to text.
+	columnTypeThreshold = 0.8
+)
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"02/01/2006",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"15:04",
+	"15:04:05",
+}
+
+// InferColumnTypes classifies every column of table as integer, numeric, date
+// or text by sampling its values. Empty cells are ignored; a column with no
+// values is text with zero confidence. Integer columns also count as numeric
+// when deciding whether a column with some decimals is numeric.
+func InferColumnTypes(table map[string][]string) map[string]ColumnType {
+	types := make(map[string]ColumnType, len(table))
+	for name, values := range table {
+		types[name] = inferColumnType(values)
+	}
+	return types
+}
+
+func inferColumnType(values []string) ColumnType {
+	step := 1
+	if len(values) > columnTypeSample {
+		step = len(values) / columnTypeSample
+	}
+
+	var total, integers, numerics, dates int
+	for i := 0; i < len(values); i += step {
+		value := strings.TrimSpace(values[i])
+		if value == "" {
+			continue
+		}
+		total++
+		switch {
+		case isInteger(value):
+			integers++
+		case isNumeric(value):
+			numerics++
+		case isDate(value):
+			dates++
+		}
+	}
+	if total == 0 {
+		return ColumnType{Kind: KindText}
+	}
+
+	share := func(n int) float64 { return float64(n) / float64(total) }
+	switch {
+	case share(integers) >= columnTypeThreshold:
+		return ColumnType{Kind: KindInteger, Confidence: share(integers)}
+	case share(integers+numerics) >= columnTypeThreshold:
+		return ColumnType{Kind: KindNumeric, Confidence: share(integers + numerics)}
+	case share(dates) >= columnTypeThreshold:
+		return ColumnType{Kind: KindDate, Confidence: share(dates)}
+	default:
+		return ColumnType{Kind: KindText, Confidence: share(total - integers - numerics - dates)}
+	}
+}
+
+func isInteger(value string) bool {
+	_, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+	return err == nil
+}
+
+func isNumeric(value string) bool {
+	_, err := parseNumber(value)
+	return err == nil
+}
+
+func isDate(value string) bool {
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
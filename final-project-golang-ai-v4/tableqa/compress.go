@@ -0,0 +1,105 @@
+package tableqa
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ErrDecompressedTooLarge is returned by Decompress when the decompressed
+// data exceeds its limit.
+var ErrDecompressedTooLarge = errors.New("decompressed data is too large")
+
+// Decompress returns data gunzipped if it starts with the gzip magic bytes,
+// and data itself otherwise, so callers need not know how a file was stored.
+// A positive limit bounds the decompressed size in bytes, which keeps a small
+// upload from expanding into an unbounded table.
+func Decompress(data []byte, limit int64) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing gzip data: %w", err)
+	}
+	defer gz.Close()
+
+	var r io.Reader = gz
+	if limit > 0 {
+		r = io.LimitReader(gz, limit+1)
+	}
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing gzip data: %w", err)
+	}
+	if limit > 0 && int64(len(decompressed)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, limit)
+	}
+	return decompressed, nil
+}
+
+// OpenDataFile opens the file at path for reading like ReadDataFile, but
+// decompresses it as it is read rather than all at once.
+func OpenDataFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+	if magic, _ := buffered.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+		return &dataFile{Reader: buffered, file: file}, nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("decompressing gzip data: %w", err)
+	}
+	return &dataFile{Reader: gz, file: file, gz: gz}, nil
+}
+
+// dataFile is a file opened by OpenDataFile.
+type dataFile struct {
+	io.Reader
+	file *os.File
+	gz   *gzip.Reader
+}
+
+// Size returns the size of a file that is not compressed, or 0 for a gzip
+// file, whose compressed size says little about the data it holds.
+func (f *dataFile) Size() int64 {
+	if f.gz != nil {
+		return 0
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (f *dataFile) Close() error {
+	if f.gz != nil {
+		f.gz.Close()
+	}
+	return f.file.Close()
+}
+
+// ReadDataFile reads the file at path, decompressing it if it is gzipped,
+// e.g. a .csv.gz file.
+func ReadDataFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decompress(data, 0)
+}
@@ -0,0 +1,511 @@
+package tableqa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultModel is the Hugging Face model used when AIModelConnector.Model is empty.
+const DefaultModel = "google/tapas-base-finetuned-wtq"
+
+// DefaultAPIBase is the inference API used when AIModelConnector.BaseURL is
+// empty.
+const DefaultAPIBase = "https://api-inference.huggingface.co"
+
+// DefaultMaxResponseSize bounds inference responses when
+// AIModelConnector.MaxResponseSize is zero. Real answers are a few kilobytes.
+const DefaultMaxResponseSize = 4 << 20
+
+var modelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(/[A-Za-z0-9][A-Za-z0-9._-]*)?$`)
+
+// AIModelConnector calls the Hugging Face inference API. Once configured it
+// is safe for concurrent use: calls only read its fields and keep their own
+// state per call, and Client is meant to be shared so connections to the API
+// are reused across requests. Fields, including the Headers map, must not be
+// changed while calls are in flight. To vary settings per call, copy the
+// connector and change the copy, e.g. to pick a model per request; copies
+// share Client and Headers, so replace Headers rather than editing it.
+type AIModelConnector struct {
+	Client *http.Client
+	// Model is the Hugging Face model ID, e.g. "google/tapas-large-finetuned-wtq".
+	Model string
+	// Fallbacks are models tried in order when the one before them is
+	// unavailable: it answers with a 5xx status, is still loading, or cannot
+	// be reached. Client errors such as a 4xx status are returned without
+	// trying the next model.
+	Fallbacks []string
+	// BaseURL is the inference API the model is called on, for proxies and
+	// self-hosted inference; it defaults to DefaultAPIBase.
+	BaseURL string
+	// Headers are added to every request. They cannot override
+	// Authorization or Content-Type.
+	Headers http.Header
+	// DisableCache sends "x-use-cache: false" so Hugging Face computes a
+	// fresh answer instead of returning a cached one for a repeated input.
+	DisableCache bool
+	// ForwardRequestID sends the ID of the request being served, if any, to
+	// the inference API in the X-Request-ID header.
+	ForwardRequestID bool
+	// MaxResponseSize bounds the decoded response body in bytes; zero uses
+	// DefaultMaxResponseSize.
+	MaxResponseSize int64
+	// Task is the kind of model called, which decides how Inputs are sent
+	// and answers decoded; nil means TableQATask.
+	Task ModelTask
+}
+
+// Inputs is the payload sent to the model. Its table is serialized with the
+// columns in ColumnOrder, which makes the payload deterministic and is the
+// order the column index of every Response coordinate refers to.
+type Inputs struct {
+	Table map[string][]string `json:"table"`
+	Query string              `json:"query"`
+	// Columns, when it names every column of Table exactly once, fixes the
+	// column order, e.g. to the header order of a CSV. Otherwise columns are
+	// sorted by name.
+	Columns []string `json:"-"`
+}
+
+// ColumnOrder returns the order the columns of in.Table are sent in.
+func (in Inputs) ColumnOrder() []string {
+	if len(in.Columns) == len(in.Table) {
+		seen := make(map[string]bool, len(in.Columns))
+		for _, name := range in.Columns {
+			if _, ok := in.Table[name]; !ok || seen[name] {
+				return sortedColumns(in.Table)
+			}
+			seen[name] = true
+		}
+		return in.Columns
+	}
+	return sortedColumns(in.Table)
+}
+
+// MarshalJSON writes the table columns in ColumnOrder; encoding/json would
+// always sort them.
+func (in Inputs) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"table":`)
+	if err := in.writeTable(&buf); err != nil {
+		return nil, err
+	}
+
+	query, err := json.Marshal(in.Query)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(`,"query":`)
+	buf.Write(query)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeTable writes in.Table to buf as a JSON object with its columns in
+// ColumnOrder.
+func (in Inputs) writeTable(buf *bytes.Buffer) error {
+	if in.Table == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	buf.WriteByte('{')
+	for i, name := range in.ColumnOrder() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		values, err := json.Marshal(in.Table[name])
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(values)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// Response is the model's answer. Each coordinate is a [row, column] pair
+// where row counts data rows from zero and column indexes the columns in the
+// order of Inputs.ColumnOrder.
+type Response struct {
+	Answer      string   `json:"answer"`
+	Coordinates [][]int  `json:"coordinates"`
+	Cells       []string `json:"cells"`
+	Aggregator  string   `json:"aggregator"`
+	// CellScores and AggregatorScores are the model's confidence in each
+	// selected cell, in the order of Coordinates, and in each aggregator.
+	// Only some deployments return them; they are empty otherwise.
+	CellScores       []float64          `json:"cell_scores,omitempty"`
+	AggregatorScores map[string]float64 `json:"aggregator_scores,omitempty"`
+	// Model is the model that answered. It is only set by a connector with
+	// Fallbacks, where it may differ from AIModelConnector.Model.
+	Model string `json:"model,omitempty"`
+	// AnswerFromCells reports that the model selected cells but returned an
+	// empty answer, so Answer was filled in by FillAnswer.
+	AnswerFromCells bool `json:"answer_from_cells,omitempty"`
+	// Warning, when set, describes how Answer disagrees with Cells and
+	// Aggregator, as found by ConsistencyWarning. It is informational: the
+	// answer is left as the model gave it. The connector never sets it.
+	Warning string `json:"warning,omitempty"`
+	// Candidates are the ranked answers of a model that returns several,
+	// best first; the other fields hold the first. They are empty when the
+	// model gave a single answer, for which CandidateAnswers stands in.
+	Candidates []Candidate `json:"candidates,omitempty"`
+}
+
+// DefaultRequestTimeout is a timeout for NewAIModelConnector that suits
+// interactive use.
+const DefaultRequestTimeout = 30 * time.Second
+
+// NewAIModelConnector returns a connector whose HTTP client gives up on a
+// call after timeout.
+func NewAIModelConnector(timeout time.Duration) *AIModelConnector {
+	return &AIModelConnector{Client: &http.Client{Timeout: timeout, Transport: NewTransport()}}
+}
+
+// NewTransport returns an http.Transport tuned for many requests to the one
+// inference API host. The default transport keeps only two idle connections
+// per host, so concurrent requests would otherwise keep opening new
+// connections and paying for a TLS handshake each time.
+func NewTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	return transport
+}
+
+// ValidateModel checks that model is a Hugging Face model ID that can be
+// safely used as a URL path.
+func ValidateModel(model string) error {
+	if !modelPattern.MatchString(model) {
+		return fmt.Errorf("invalid model %q", model)
+	}
+	return nil
+}
+
+func (c *AIModelConnector) task() ModelTask {
+	if c.Task == nil {
+		return TableQATask
+	}
+	return c.Task
+}
+
+// TaskName returns the pipeline tag of the connector's Task.
+func (c *AIModelConnector) TaskName() string {
+	return c.task().Name()
+}
+
+// ModelName returns the model the connector calls.
+func (c *AIModelConnector) ModelName() string {
+	if c.Model == "" {
+		return DefaultModel
+	}
+	return c.Model
+}
+
+// Models returns the models the connector tries, in order: ModelName
+// followed by Fallbacks.
+func (c *AIModelConnector) Models() []string {
+	return append([]string{c.ModelName()}, c.Fallbacks...)
+}
+
+// ModelURL returns the inference URL of the connector's model.
+func (c *AIModelConnector) ModelURL() (string, error) {
+	return c.modelURL(c.ModelName())
+}
+
+func (c *AIModelConnector) modelURL(model string) (string, error) {
+	if err := ValidateModel(model); err != nil {
+		return "", err
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultAPIBase
+	}
+	return strings.TrimRight(base, "/") + "/models/" + model, nil
+}
+
+// ValidateAPIBase checks that base is an absolute http or https URL without a
+// query or fragment, to be used as AIModelConnector.BaseURL.
+func ValidateAPIBase(base string) error {
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid API base %q: %v", base, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid API base %q: expected an http or https URL", base)
+	}
+	return nil
+}
+
+// BuildPayload returns the exact request body ConnectAIModel sends for
+// payload. The table of an Inputs or SequentialInputs payload is checked
+// first: one whose columns differ in length, which the model rejects without
+// saying why, is reported as a *ColumnLengthError and never sent. Inputs are
+// then shaped for the connector's Task.
+func (c *AIModelConnector) BuildPayload(payload interface{}) ([]byte, error) {
+	var (
+		table  map[string][]string
+		inputs *Inputs
+	)
+	switch in := payload.(type) {
+	case Inputs:
+		table, inputs = in.Table, &in
+	case *Inputs:
+		if in != nil {
+			table, inputs = in.Table, in
+		}
+	case SequentialInputs:
+		table = in.Table
+	case *SequentialInputs:
+		if in != nil {
+			table = in.Table
+		}
+	}
+	if err := checkColumnLengths(table); err != nil {
+		return nil, err
+	}
+	if inputs != nil {
+		var err error
+		if payload, err = c.task().Payload(*inputs); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func (c *AIModelConnector) ConnectAIModel(payload interface{}, token string) (Response, error) {
+	return c.ConnectAIModelWithContext(context.Background(), payload, token)
+}
+
+// ConnectAIModelWithContext is like ConnectAIModel but aborts the call and
+// returns ctx.Err() as soon as ctx is done. The token never appears in the
+// message of the returned error. When the model is unavailable, each of
+// c.Fallbacks is tried in turn and the error of the last one is returned.
+func (c *AIModelConnector) ConnectAIModelWithContext(ctx context.Context, payload interface{}, token string) (Response, error) {
+	var response Response
+	task := c.task()
+	model, err := c.call(ctx, payload, token, func(body []byte) (err error) {
+		response, err = task.Decode(body)
+		return err
+	})
+	if err == nil && len(c.Fallbacks) > 0 {
+		response.Model = model
+	}
+	return response, err
+}
+
+// call sends payload to each of c.Models in turn until one is available and
+// hands its 200 body to decode. It returns the model that answered.
+func (c *AIModelConnector) call(ctx context.Context, payload interface{}, token string, decode func(body []byte) error) (string, error) {
+	var err error
+	models := c.Models()
+	for i, model := range models {
+		err = c.connect(ctx, model, payload, token, decode)
+		if err == nil {
+			return model, nil
+		}
+		if i == len(models)-1 || ctx.Err() != nil || !isUnavailable(err) {
+			break
+		}
+		LoggerFromContext(ctx).Warn("falling back to next model", "model", model, "next", models[i+1], "error", RedactToken(err.Error(), token))
+	}
+	return "", RedactError(err, token)
+}
+
+func (c *AIModelConnector) connect(ctx context.Context, model string, payload interface{}, token string, decode func(body []byte) error) error {
+	endpoint, err := c.modelURL(model)
+	if err != nil {
+		return err
+	}
+
+	payloadBytes, err := c.BuildPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range c.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if c.DisableCache {
+		req.Header.Set("x-use-cache", "false")
+	}
+	if id := RequestIDFromContext(ctx); c.ForwardRequestID && id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	// Setting Accept-Encoding ourselves turns off the transport's transparent
+	// decompression, so readBody undoes the gzip encoding.
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	setAuthorization(req, token)
+	req.Header.Set("Content-Type", "application/json")
+
+	logger := LoggerFromContext(ctx)
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		logger.Warn("upstream call failed", "model", model, "latency_ms", latency.Milliseconds(), "error", RedactToken(err.Error(), token))
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	logger.Info("upstream call", "model", model, "status", resp.StatusCode, "latency_ms", latency.Milliseconds())
+
+	respBody, err := readBody(resp, c.maxResponseSize())
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return &AuthError{StatusCode: resp.StatusCode, Body: RedactToken(truncateBody(respBody), token)}
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			if loading := parseModelLoading(respBody); loading != nil {
+				loading.Message = RedactToken(loading.Message, token)
+				return loading
+			}
+		}
+		return &UpstreamError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       RedactToken(truncateBody(respBody), token),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	if raw, ok := ctx.Value(rawResponseKey).(*RawResponse); ok {
+		raw.record([]byte(RedactToken(string(respBody), token)))
+	}
+	if err := decode(respBody); err != nil {
+		var invalid *InvalidResponseError
+		if errors.As(err, &invalid) {
+			invalid.Reason = RedactToken(invalid.Reason, token)
+			invalid.Body = RedactToken(invalid.Body, token)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *AIModelConnector) maxResponseSize() int64 {
+	if c.MaxResponseSize <= 0 {
+		return DefaultMaxResponseSize
+	}
+	return c.MaxResponseSize
+}
+
+// setAuthorization is the only place the token is put on a request. Anything
+// that may echo the request back must go through RedactToken.
+func setAuthorization(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// readBody reads the body of resp, decompressing it when the server sent it
+// gzip-encoded. It returns a *ResponseTooLargeError once more than limit
+// bytes have been decoded, so a compressed body cannot expand unbounded.
+func readBody(resp *http.Response, limit int64) ([]byte, error) {
+	var body io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing response: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, &ResponseTooLargeError{Limit: limit}
+	}
+	return data, nil
+}
+
+// decodeResponse decodes a 200 body from the inference API. An answer that
+// is an empty string is legitimate, since the selected cell may be empty, but
+// the "answer" field itself must be present and no "error" field may be. An
+// empty answer next to non-empty cells is filled in with FillAnswer. A list
+// of answers is decoded as ranked candidates.
+func decodeResponse(body []byte) (Response, error) {
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return decodeCandidates(body)
+	}
+	return decodeAnswer(body)
+}
+
+// decodeAnswer decodes a body holding a single answer object.
+func decodeAnswer(body []byte) (Response, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return Response{}, &InvalidResponseError{Reason: "body is not a JSON object", Body: truncateBody(body)}
+	}
+	if message, ok := fields["error"]; ok {
+		return Response{}, &InvalidResponseError{Reason: "model returned an error: " + string(message), Body: truncateBody(body)}
+	}
+	if _, ok := fields["answer"]; !ok {
+		return Response{}, &InvalidResponseError{Reason: `missing "answer" field`, Body: truncateBody(body)}
+	}
+
+	var response Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Response{}, &InvalidResponseError{Reason: err.Error(), Body: truncateBody(body)}
+	}
+	return response.FillAnswer(), nil
+}
+
+var (
+	// ErrTokenMissing means HUGGINGFACE_TOKEN is empty.
+	ErrTokenMissing = errors.New("HUGGINGFACE_TOKEN is not set in the environment")
+	// ErrTokenMalformed means HUGGINGFACE_TOKEN does not look like a Hugging
+	// Face access token.
+	ErrTokenMalformed = errors.New(`HUGGINGFACE_TOKEN does not look like a Hugging Face token (expected "hf_" prefix)`)
+)
+
+// ValidateToken checks that token is set and has the shape of a Hugging Face
+// access token. It does not contact the API.
+func ValidateToken(token string) error {
+	if token == "" {
+		return ErrTokenMissing
+	}
+	if !strings.HasPrefix(token, "hf_") || strings.ContainsAny(token, " \t\r\n") {
+		return ErrTokenMalformed
+	}
+	return nil
+}
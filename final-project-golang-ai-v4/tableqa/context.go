@@ -0,0 +1,77 @@
+package tableqa
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// RequestIDHeader carries the request ID in both directions, so a request can
+// be traced across services.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	rawResponseKey
+)
+
+// ContextWithLogger returns a copy of ctx carrying logger, which the
+// connector uses for the logs of calls made with that context.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the request
+// being served, which AIModelConnector.ForwardRequestID passes on.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RawResponse records the body of the last 200 response a connector
+// received, with the token redacted, for debugging answers that look wrong.
+type RawResponse struct {
+	mu   sync.Mutex
+	body []byte
+}
+
+// Body returns the recorded body, or nil if there is none or r is nil.
+func (r *RawResponse) Body() []byte {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.body
+}
+
+func (r *RawResponse) record(body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.body = body
+}
+
+// ContextWithRawResponse returns a copy of ctx under which connector calls
+// record their response body in the returned RawResponse.
+func ContextWithRawResponse(ctx context.Context) (context.Context, *RawResponse) {
+	raw := &RawResponse{}
+	return context.WithValue(ctx, rawResponseKey, raw), raw
+}
+
+// LoggerFromContext returns the logger stored by ContextWithLogger, or
+// slog.Default() if there is none.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestIDFromContext returns the ID stored by ContextWithRequestID, or ""
+// if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
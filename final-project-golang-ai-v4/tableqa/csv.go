@@ -0,0 +1,565 @@
+package tableqa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf8"
+)
+
+// CsvOptions tunes how CsvToSliceWithOptions parses its input. The zero value
+// matches CsvToSlice.
+type CsvOptions struct {
+	// Delimiter separates fields; it defaults to ','. Use '\t' for TSV or ';'
+	// for common European exports.
+	Delimiter rune
+	// RenameDuplicates suffixes repeated header names ("price", "price_2")
+	// instead of rejecting the input.
+	RenameDuplicates bool
+	// Encoding names the character set of the input: "" or "utf-8" (the
+	// default), or "latin1"/"iso-8859-1", which is transcoded to UTF-8.
+	Encoding string
+	// SkipLines drops this many lines from the start of the input, such as
+	// a title line above the header row. Line numbers in errors still count
+	// from the start of the input.
+	SkipLines int
+	// Comment, if set, makes lines starting with it, such as '#', comments,
+	// which are ignored wherever they appear.
+	Comment rune
+	// SkipBlankLines ignores lines before the header row whose fields are
+	// all empty, like the ",,," some spreadsheets export. Empty lines are
+	// always ignored.
+	SkipBlankLines bool
+}
+
+var (
+	// ErrEmptyCSV is returned for CSV input without a header row: an empty
+	// file, or one holding only blank, comment or skipped lines.
+	ErrEmptyCSV = errors.New("CSV file is empty")
+	// ErrNoDataRows is returned for CSV input with a header row but no rows
+	// below it.
+	ErrNoDataRows = errors.New("CSV file must contain at least one row of data below its header")
+)
+
+// CsvToSlice parses comma-separated data into a map from column header to
+// column values. Every row must have exactly as many fields as the header row;
+// rows that are too long or too short are rejected rather than padded, so the
+// columns handed to the model always line up. Trailing delimiters are the
+// exception: empty-named columns at the end of the header row, as in "a,b,",
+// are dropped, and so are empty fields past the last column of a row, as in
+// "1,2,", so spreadsheet exports with trailing commas parse as if they had
+// none. A value past the last named column is still an error, and so is an
+// empty name before the last named one, as in "a,,b" or ",a". Quoted fields
+// may contain delimiters, doubled quotes and newlines and are returned intact,
+// except that a \r\n inside quotes becomes \n. Malformed input is reported as a
+// *CsvLineError naming and quoting the offending line, and input without
+// data as ErrEmptyCSV or ErrNoDataRows.
+func CsvToSlice(data string) (map[string][]string, error) {
+	table, _, err := CsvToSliceOrdered(data)
+	return table, err
+}
+
+// CsvToSliceWithOptions is like CsvToSlice but parses data according to opts.
+func CsvToSliceWithOptions(data string, opts CsvOptions) (map[string][]string, error) {
+	table, _, err := CsvToSliceOrderedWithOptions(data, opts)
+	return table, err
+}
+
+// CsvToSliceOrdered is like CsvToSlice but also returns the headers in the
+// order they appear in data, which the map alone cannot preserve.
+func CsvToSliceOrdered(data string) (map[string][]string, []string, error) {
+	return CsvToSliceOrderedWithOptions(data, CsvOptions{})
+}
+
+// CsvToSliceOrderedWithOptions combines CsvToSliceOrdered and
+// CsvToSliceWithOptions.
+func CsvToSliceOrderedWithOptions(data string, opts CsvOptions) (map[string][]string, []string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	data, err := decodeCsv(data, opts.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	// every row but the last ends in a newline, so their count bounds the
+	// rows; blank lines, comments and quoted newlines only make it larger
+	rows := strings.Count(data, "\n") + 1
+	return parseCsv(strings.NewReader(data), opts, rows, func(line int) string { return csvLine(data, line) })
+}
+
+// CsvToSliceFromReader is like CsvToSliceOrderedWithOptions but reads the CSV
+// from r as it parses it, so a large file is never held in memory twice,
+// once as text and once as the table. Since the input is not kept, a
+// *CsvLineError for a row with the wrong number of fields quotes the row as
+// parsed rather than the raw line, and one for malformed quoting quotes
+// nothing. If r reports its size, through a Len, Size or Stat method as
+// strings.Reader, OpenDataFile's files and *os.File do, the columns are sized
+// for the rows the start of the input suggests it holds, which saves growing
+// them as rows are read.
+func CsvToSliceFromReader(r io.Reader, opts CsvOptions) (map[string][]string, []string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	size := readerSize(r)
+	r, err := decodeCsvReader(r, opts.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReaderSize(r, csvSampleSize)
+	return parseCsv(buffered, opts, estimateRows(buffered, size), nil)
+}
+
+// csvSampleSize is how much of a streamed input estimateRows inspects.
+const csvSampleSize = 64 << 10
+
+// readerSize returns the number of bytes left in r if r reports it, or 0.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return 0
+}
+
+// estimateRows guesses how many lines the input read by buffered holds from
+// the lines in its first csvSampleSize bytes: all of them if the input is no
+// longer, or as many again for every sample's worth of the size bytes left,
+// or 0 if the size is unknown. It is a hint for parseCsv, which may find
+// fewer rows or more.
+func estimateRows(buffered *bufio.Reader, size int64) int {
+	sample, err := buffered.Peek(csvSampleSize)
+	lines := bytes.Count(sample, []byte("\n")) + 1
+	if err != nil {
+		return lines
+	}
+	if size <= 0 {
+		return 0
+	}
+	return int(size * int64(lines) / int64(len(sample)))
+}
+
+// SliceToCsv is the inverse of CsvToSlice: it encodes table as comma-separated
+// data with a header row, the columns in the order of headers, or sorted by
+// name when headers is nil. Fields with delimiters, quotes, newlines or
+// leading spaces are quoted, so CsvToSlice parses the output back into table,
+// except that a \r\n in a cell comes back as \n and an empty-named column
+// does not come back: CsvToSlice rejects it, or drops it if it is the last
+// column and its values are all empty too. headers must name every column of
+// table exactly once, and the columns must have the same length.
+func SliceToCsv(table map[string][]string, headers []string) (string, error) {
+	if err := ValidateTable(table); err != nil {
+		return "", err
+	}
+	if headers == nil {
+		headers = sortedColumns(table)
+	}
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		if _, ok := table[header]; !ok {
+			return "", fmt.Errorf("column %q does not exist", header)
+		}
+		if seen[header] {
+			return "", fmt.Errorf("column %q is listed twice", header)
+		}
+		seen[header] = true
+	}
+	for _, column := range sortedColumns(table) {
+		if !seen[column] {
+			return "", fmt.Errorf("column %q is missing from the headers", column)
+		}
+	}
+
+	var buf strings.Builder
+	if strings.HasPrefix(headers[0], "\ufeff") {
+		// CsvToSlice drops one leading byte order mark as an encoding marker
+		buf.WriteString("\ufeff")
+	}
+	w := csv.NewWriter(&buf)
+	w.Write(headers)
+	record := make([]string, len(headers))
+	for row := 0; row < TableRows(table); row++ {
+		for i, header := range headers {
+			record[i] = table[header][row]
+		}
+		if len(record) == 1 && record[0] == "" {
+			// an empty line would be skipped when parsing
+			w.Flush()
+			buf.WriteString("\"\"\n")
+			continue
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+func (opts CsvOptions) delimiter() rune {
+	if opts.Delimiter == 0 {
+		return ','
+	}
+	return opts.Delimiter
+}
+
+func (opts CsvOptions) validate() error {
+	delimiter := opts.delimiter()
+	if delimiter == '\r' || delimiter == '\n' || delimiter == '"' || !utf8.ValidRune(delimiter) || delimiter == utf8.RuneError {
+		return fmt.Errorf("invalid CSV delimiter %q", delimiter)
+	}
+	if comment := opts.Comment; comment != 0 && (comment == delimiter || comment == '\r' || comment == '\n' || comment == '"' || !utf8.ValidRune(comment) || comment == utf8.RuneError) {
+		return fmt.Errorf("invalid CSV comment character %q", comment)
+	}
+	if opts.SkipLines < 0 {
+		return fmt.Errorf("invalid number of CSV lines to skip: %d", opts.SkipLines)
+	}
+	return nil
+}
+
+// maxPresizedCells bounds the cells parseCsv allocates room for before it
+// has read them, so a short input with many newlines or columns cannot make
+// it reserve more memory than a modest table needs.
+const maxPresizedCells = 1 << 20
+
+// parseCsv builds the table from input one record at a time. rows is an
+// upper bound on the number of records, if known, or 0; it is only a hint
+// for the capacity of the columns, limited to maxPresizedCells in all, which
+// grow by append beyond it.
+// snippet returns the text of a 1-based line of input for errors; when it is
+// nil, errors quote the offending record instead.
+func parseCsv(input io.Reader, opts CsvOptions, rows int, snippet func(line int) string) (map[string][]string, []string, error) {
+	// skipped lines are consumed before parsing, so the reader's line
+	// numbers are offset by them
+	buffered := bufio.NewReader(input)
+	for i := 0; i < opts.SkipLines; {
+		_, err := buffered.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		i++
+	}
+
+	r := csv.NewReader(buffered)
+	r.Comma = opts.delimiter()
+	r.Comment = opts.Comment
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	var (
+		header  []string
+		headers []string
+		columns [][]string
+	)
+	for n := 1; ; {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line := parseErr.Line + opts.SkipLines
+				lineErr := &CsvLineError{Line: line, Column: parseErr.Column, Err: parseErr.Err}
+				if snippet != nil {
+					lineErr.Snippet = snippet(line)
+				}
+				return nil, nil, lineErr
+			}
+			return nil, nil, err
+		}
+		if header == nil && opts.SkipBlankLines && blankRecord(record) {
+			continue
+		}
+		if header == nil {
+			header = append([]string{}, record[:namedFields(record)]...)
+			n++
+			continue
+		}
+
+		// Headers are checked once the first row shows there is data, so a
+		// file without rows reports that rather than a header problem.
+		if headers == nil {
+			if len(header) == 0 {
+				return nil, nil, errors.New("CSV header row has no column names")
+			}
+			for i, name := range header {
+				if strings.TrimSpace(name) == "" {
+					return nil, nil, fmt.Errorf("CSV header row has no name for column %d", i+1)
+				}
+			}
+			if headers, err = uniqueHeaders(header, opts.RenameDuplicates); err != nil {
+				return nil, nil, err
+			}
+			capacity := min(rows, maxPresizedCells/len(headers))
+			columns = make([][]string, len(headers))
+			for i := range columns {
+				columns[i] = make([]string, 0, capacity)
+			}
+		}
+
+		if len(record) > len(headers) && blankRecord(record[len(headers):]) {
+			record = record[:len(headers)]
+		}
+		if len(record) != len(headers) {
+			line, _ := r.FieldPos(0)
+			line += opts.SkipLines
+			lineErr := &CsvLineError{
+				Line: line,
+				Err:  &FieldCountError{Row: n, Expected: len(headers), Actual: len(record)},
+			}
+			if snippet != nil {
+				lineErr.Snippet = snippet(line)
+			} else {
+				lineErr.Snippet = csvRecord(record, r.Comma)
+			}
+			return nil, nil, lineErr
+		}
+		for i, value := range record {
+			columns[i] = append(columns[i], value)
+		}
+		n++
+	}
+
+	if header == nil {
+		return nil, nil, ErrEmptyCSV
+	}
+	if headers == nil {
+		return nil, nil, ErrNoDataRows
+	}
+	result := make(map[string][]string, len(headers))
+	for i, header := range headers {
+		result[header] = columns[i]
+	}
+	return result, headers, nil
+}
+
+// textSampleSize is how much of the input LooksLikeText inspects.
+const textSampleSize = 8 << 10
+
+// LooksLikeText reports whether data is plausibly a text file rather than a
+// binary one such as a spreadsheet, judging by its first few kilobytes: text
+// has no NUL bytes and few other control characters. Bytes above 0x7f pass,
+// so UTF-8 and Latin-1 text both count.
+func LooksLikeText(data []byte) bool {
+	if len(data) > textSampleSize {
+		data = data[:textSampleSize]
+	}
+	control := 0
+	for _, b := range data {
+		switch {
+		case b == 0:
+			return false
+		case b == '\t' || b == '\n' || b == '\r' || b == '\f':
+		case b < 0x20 || b == 0x7f:
+			control++
+		}
+	}
+	// a stray control character is tolerated, one byte in ten is not
+	return control*10 <= len(data)
+}
+
+// namedFields returns the number of fields in the header row record up to
+// and including its last non-empty name; the empty names after it are dropped.
+func namedFields(record []string) int {
+	n := len(record)
+	for n > 0 && strings.TrimSpace(record[n-1]) == "" {
+		n--
+	}
+	return n
+}
+
+// blankRecord reports whether every field of record is empty.
+func blankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CsvLineError is a CSV parse error located in the input, with the offending
+// line quoted so users can find and fix it.
+type CsvLineError struct {
+	// Line is the 1-based line the error is on; Column, if known, is the
+	// 1-based column in that line.
+	Line    int
+	Column  int
+	Snippet string
+	Err     error
+}
+
+func (e *CsvLineError) Error() string {
+	location := fmt.Sprintf("line %d", e.Line)
+	if e.Column > 0 {
+		location += fmt.Sprintf(", column %d", e.Column)
+	}
+	if e.Snippet == "" {
+		return fmt.Sprintf("%s: %v", location, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %q", location, e.Err, e.Snippet)
+}
+
+func (e *CsvLineError) Unwrap() error { return e.Err }
+
+// FieldCountError reports a CSV row whose length differs from the header
+// row's. It matches csv.ErrFieldCount with errors.Is.
+type FieldCountError struct {
+	// Row is the 1-based record number, counting the header row; it differs
+	// from the line number when quoted fields span lines.
+	Row      int
+	Expected int
+	Actual   int
+}
+
+func (e *FieldCountError) Error() string {
+	return fmt.Sprintf("row %d has %d fields, expected %d", e.Row, e.Actual, e.Expected)
+}
+
+func (e *FieldCountError) Is(target error) bool { return target == csv.ErrFieldCount }
+
+// maxSnippetLength bounds the line quoted in a CsvLineError, in runes.
+const maxSnippetLength = 80
+
+// csvLine returns the 1-based line of data, cut to maxSnippetLength.
+func csvLine(data string, line int) string {
+	lines := strings.Split(data, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return shortenSnippet(strings.TrimSuffix(lines[line-1], "\r"))
+}
+
+// csvRecord encodes record as a CSV line, cut to maxSnippetLength, to quote
+// it when the raw line is not available.
+func csvRecord(record []string, delimiter rune) string {
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Comma = delimiter
+	w.Write(record)
+	w.Flush()
+	return shortenSnippet(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func shortenSnippet(snippet string) string {
+	if runes := []rune(snippet); len(runes) > maxSnippetLength {
+		snippet = string(runes[:maxSnippetLength]) + "..."
+	}
+	return snippet
+}
+
+// decodeCsv converts data from encoding to UTF-8 and strips a leading UTF-8
+// byte order mark, as written by Excel on Windows.
+func decodeCsv(data string, encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		return strings.TrimPrefix(data, "\ufeff"), nil
+	case "latin1", "latin-1", "iso-8859-1":
+		runes := make([]rune, len(data))
+		for i := 0; i < len(data); i++ {
+			runes[i] = rune(data[i])
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("unsupported CSV encoding %q", encoding)
+	}
+}
+
+// decodeCsvReader is decodeCsv for a stream.
+func decodeCsvReader(r io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		buffered := bufio.NewReader(r)
+		if bom, err := buffered.Peek(len("\ufeff")); err == nil && string(bom) == "\ufeff" {
+			buffered.Discard(len(bom))
+		}
+		return buffered, nil
+	case "latin1", "latin-1", "iso-8859-1":
+		return &latin1Reader{r: r}, nil
+	default:
+		return nil, fmt.Errorf("unsupported CSV encoding %q", encoding)
+	}
+}
+
+// latin1Reader transcodes a Latin-1 stream to UTF-8, in which every byte of
+// Latin-1 is the rune of the same value.
+type latin1Reader struct {
+	r       io.Reader
+	buf     []byte
+	encoded []byte
+	pending []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	if len(l.pending) > 0 {
+		n := copy(p, l.pending)
+		l.pending = l.pending[n:]
+		return n, nil
+	}
+
+	// each byte encodes to at most two, so half of p is read at a time
+	size := max(len(p)/2, 1)
+	if cap(l.buf) < size {
+		l.buf = make([]byte, size)
+	}
+	n, err := l.r.Read(l.buf[:size])
+	l.encoded = l.encoded[:0]
+	for _, b := range l.buf[:n] {
+		l.encoded = utf8.AppendRune(l.encoded, rune(b))
+	}
+	copied := copy(p, l.encoded)
+	if l.pending = l.encoded[copied:]; len(l.pending) > 0 && err == io.EOF {
+		err = nil
+	}
+	return copied, err
+}
+
+// uniqueHeaders rejects repeated header names, or renames them when rename is
+// set, so that no two columns are merged into one slice.
+func uniqueHeaders(headers []string, rename bool) ([]string, error) {
+	positions := make(map[string]int, len(headers))
+	for i, header := range headers {
+		if first, ok := positions[header]; ok {
+			if !rename {
+				return nil, fmt.Errorf("duplicate column %q at positions %d and %d", header, first+1, i+1)
+			}
+			continue
+		}
+		positions[header] = i
+	}
+	if !rename {
+		return headers, nil
+	}
+
+	// next remembers the suffix to try for each name, so that many copies of
+	// one header are renamed in linear rather than quadratic time.
+	seen := make(map[string]bool, len(headers))
+	next := make(map[string]int)
+	result := make([]string, len(headers))
+	for i, header := range headers {
+		name := header
+		for n := max(next[header], 2); seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", header, n)
+			next[header] = n + 1
+		}
+		seen[name] = true
+		result[i] = name
+	}
+	return result, nil
+}
@@ -0,0 +1,226 @@
+package tableqa_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"a21hc3NpZ25tZW50/tableqa"
+)
+
+// FuzzCsvToSlice checks that CsvToSlice never panics, that every table it
+// returns is rectangular: one column per header, all of the same length, and
+// that CsvToSliceFromReader parses the same input the same way, and that
+// SliceToCsv encodes the table into CSV that parses back into it.
+// Run it with go test -fuzz=FuzzCsvToSlice ./tableqa.
+func FuzzCsvToSlice(f *testing.F) {
+	for _, seed := range []string{
+		"Name,Age\nJohn,30\nDoe,40",
+		"\ufeffDate,Appliance\n2022-01-01,TV\n",
+		"a,b\n\"x\ny\",1\n\"p,q\",2,3",
+		"a,b\r\n\"said \"\"hi\"\"\",\"\"\r\n",
+		"a,b\n1,2\nx\"y,3\n",
+		"a,\"b\n1,2\n",
+		"a,b,c,d\n1,2,3\n",
+		"id,price,name,price\n1,2,3,4",
+		"a\n\n\n1\n",
+		",\n,\n",
+		"a,b,\n1,2,\n3,4\n5,6,,\n",
+		"a," + strings.Repeat("b", 4096) + "\n1," + strings.Repeat("2,", 1000) + "3\n",
+		"",
+		"\"",
+	} {
+		f.Add(seed, false)
+	}
+	f.Add("id,id,id_2\n1,2,3\n", true)
+	// Thousands of empty headers once took minutes to rename.
+	f.Add(strings.Repeat(",", 5000)+"\n"+strings.Repeat(",", 5000)+"\n", true)
+
+	f.Fuzz(func(t *testing.T, data string, rename bool) {
+		opts := tableqa.CsvOptions{RenameDuplicates: rename}
+		table, headers, err := tableqa.CsvToSliceOrderedWithOptions(data, opts)
+		streamed, streamedHeaders, streamErr := tableqa.CsvToSliceFromReader(iotest.OneByteReader(strings.NewReader(data)), opts)
+		if (err == nil) != (streamErr == nil) {
+			t.Fatalf("string error %v, reader error %v", err, streamErr)
+		}
+		if err != nil {
+			return
+		}
+		if !reflect.DeepEqual(table, streamed) || !reflect.DeepEqual(headers, streamedHeaders) {
+			t.Fatalf("reader parsed %v %v, string parsed %v %v", streamedHeaders, streamed, headers, table)
+		}
+		if len(headers) == 0 || len(table) != len(headers) {
+			t.Fatalf("%d headers for %d columns", len(headers), len(table))
+		}
+		rows := len(table[headers[0]])
+		if rows == 0 {
+			t.Fatalf("table without rows")
+		}
+		for _, header := range headers {
+			column, ok := table[header]
+			if !ok {
+				t.Fatalf("header %q has no column", header)
+			}
+			if len(column) != rows {
+				t.Fatalf("column %q has %d rows, expected %d", header, len(column), rows)
+			}
+		}
+
+		encoded, err := tableqa.SliceToCsv(table, headers)
+		if err != nil {
+			t.Fatalf("SliceToCsv: %v", err)
+		}
+		decoded, decodedHeaders, err := tableqa.CsvToSliceOrdered(encoded)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(table, decoded) || !reflect.DeepEqual(headers, decodedHeaders) {
+			t.Fatalf("%q parsed as %v %v, expected %v %v", encoded, decodedHeaders, decoded, headers, table)
+		}
+	})
+}
+
+// TestCsvToSliceEmptyHeaders checks that an empty name is rejected before the
+// last named column, whether the input is a string or a reader, and dropped
+// after it.
+func TestCsvToSliceEmptyHeaders(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  string
+	}{
+		{"a,,b\n1,2,3\n", "CSV header row has no name for column 2"},
+		{",a\n1,2\n", "CSV header row has no name for column 1"},
+		{"a, ,b\n1,2,3\n", "CSV header row has no name for column 2"},
+		{"a,b,,\n1,2,,\n", ""},
+	} {
+		_, _, err := tableqa.CsvToSliceOrdered(test.data)
+		_, _, streamErr := tableqa.CsvToSliceFromReader(strings.NewReader(test.data), tableqa.CsvOptions{})
+		for _, err := range []error{err, streamErr} {
+			if test.err == "" && err != nil {
+				t.Errorf("%q: unexpected error %v", test.data, err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("%q: error %v, expected %q", test.data, err, test.err)
+			}
+		}
+	}
+}
+
+// TestCsvToSliceManyNewlines checks that an input with far more newlines
+// than rows, which would overestimate the rows to preallocate, is parsed
+// without reserving memory for the rows it does not have.
+func TestCsvToSliceManyNewlines(t *testing.T) {
+	headers := make([]string, 1000)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("c%d", i)
+	}
+	data := strings.Join(headers, ",") + "\n" + strings.Repeat("\n", 2000000) + strings.Repeat("1,", 999) + "1\n"
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	table, err := tableqa.CsvToSlice(data)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 1000 || len(table["c999"]) != 1 {
+		t.Fatalf("parsed %d columns with %d rows, expected 1000 with 1", len(table), len(table["c999"]))
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Fatalf("parsing allocated %d MB", allocated>>20)
+	}
+}
+
+// BenchmarkCsvToSlice compares reading a large file into a string before
+// parsing it with parsing it from the file as it is read, as TableCache does.
+func BenchmarkCsvToSlice(b *testing.B) {
+	var data strings.Builder
+	data.WriteString("Date,Time,Appliance,Energy_Consumption,Room,Status\n")
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&data, "2022-01-%02d,%02d:00,Appliance %d,%d.%d,Room %d,On\n", i%28+1, i%24, i%50, i%10, i%7, i%12)
+	}
+	path := filepath.Join(b.TempDir(), "large.csv")
+	if err := os.WriteFile(path, []byte(data.String()), 0o600); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, _, err := tableqa.CsvToSliceOrdered(string(content)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _, err = tableqa.CsvToSliceFromReader(file, tableqa.CsvOptions{})
+			file.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("data file", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			file, err := tableqa.OpenDataFile(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _, err = tableqa.CsvToSliceFromReader(file, tableqa.CsvOptions{})
+			file.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkCsvToSliceAllocs measures the allocations of parsing a 100,000
+// row table already in memory, without the cost of reading it from disk.
+func BenchmarkCsvToSliceAllocs(b *testing.B) {
+	var data strings.Builder
+	data.WriteString("Date,Time,Appliance,Energy_Consumption,Room,Status\n")
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&data, "2022-01-%02d,%02d:00,Appliance %d,%d.%d,Room %d,On\n", i%28+1, i%24, i%50, i%10, i%7, i%12)
+	}
+	csv := data.String()
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(csv)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := tableqa.CsvToSliceOrdered(csv); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reader", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(csv)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := tableqa.CsvToSliceFromReader(strings.NewReader(csv), tableqa.CsvOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,10 @@
+// Package tableqa answers natural-language questions about tables with a
+// TAPAS model on the Hugging Face inference API.
+//
+// Tables are maps from column header to column values, read from CSV with
+// CsvToSlice or from JSON with JSONToTable. An AIModelConnector sends a table
+// and a query to the API and returns the model's Response, whose coordinates
+// can be resolved back to the table with Response.Enrich. Code that only needs
+// answers can depend on the TableQAModel interface, implemented by
+// HuggingFaceModel and, for offline use, MockModel.
+package tableqa
@@ -0,0 +1,180 @@
+package tableqa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBodyLen bounds the upstream body kept in an UpstreamError.
+const maxErrorBodyLen = 1024
+
+// UpstreamError is returned by ConnectAIModel when the inference API answers
+// with a non-200 status that has no more specific error type.
+type UpstreamError struct {
+	StatusCode int
+	Status     string
+	// Body is the start of the response body, up to maxErrorBodyLen bytes.
+	Body       string
+	retryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("failed to get valid response: %d %s", e.StatusCode, e.Status)
+	}
+	return fmt.Sprintf("failed to get valid response: %d %s: %s", e.StatusCode, e.Status, e.Body)
+}
+
+// truncateBody returns body as a string, cut to maxErrorBodyLen bytes.
+func truncateBody(body []byte) string {
+	if len(body) <= maxErrorBodyLen {
+		return strings.TrimSpace(string(body))
+	}
+	return strings.TrimSpace(string(body[:maxErrorBodyLen])) + "... (truncated)"
+}
+
+// RetryAfter reports the delay requested by the upstream Retry-After header,
+// or zero when none was sent.
+func (e *UpstreamError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// InvalidResponseError is returned by ConnectAIModel when the inference API
+// answers 200 with a body that is not a table-QA answer, such as an error
+// object.
+type InvalidResponseError struct {
+	Reason string
+	// Body is the start of the response body with the token redacted.
+	Body string
+}
+
+func (e *InvalidResponseError) Error() string {
+	return fmt.Sprintf("invalid response from model: %s", e.Reason)
+}
+
+// ResponseTooLargeError is returned by ConnectAIModel when the response body
+// exceeds AIModelConnector.MaxResponseSize.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response from model exceeds %d bytes", e.Limit)
+}
+
+// AuthError is returned by ConnectAIModel when the inference API rejects the
+// token (401) or denies access to the model (403), e.g. for a private or gated
+// model the token has not been granted.
+type AuthError struct {
+	StatusCode int
+	// Body is the start of the response body with the token redacted.
+	Body string
+}
+
+func (e *AuthError) Error() string {
+	reason := "invalid or missing token"
+	if e.StatusCode == http.StatusForbidden {
+		reason = "token is not allowed to access this model"
+	}
+	if e.Body == "" {
+		return fmt.Sprintf("authentication failed (%d): %s", e.StatusCode, reason)
+	}
+	return fmt.Sprintf("authentication failed (%d): %s: %s", e.StatusCode, reason, e.Body)
+}
+
+var (
+	// bearerPattern matches an Authorization header value echoed back by a
+	// proxy or an error message.
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[^\s"',;]+`)
+	// hfTokenPattern matches Hugging Face access tokens, so a token other than
+	// the configured one is scrubbed too.
+	hfTokenPattern = regexp.MustCompile(`\bhf_[A-Za-z0-9]{6,}`)
+)
+
+// RedactToken replaces every occurrence of token in s, and anything else that
+// looks like a bearer credential or a Hugging Face token. Every message that
+// may contain request details goes through it before it is returned to a
+// client or logged.
+func RedactToken(s, token string) string {
+	if token != "" {
+		s = strings.ReplaceAll(s, token, "[REDACTED]")
+	}
+	s = bearerPattern.ReplaceAllString(s, "Bearer [REDACTED]")
+	return hfTokenPattern.ReplaceAllString(s, "[REDACTED]")
+}
+
+// redactedError hides the token in the message of an error while keeping the
+// error itself available to errors.Is and errors.As.
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string { return e.message }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// RedactError returns err with RedactToken applied to its message, or err
+// itself when there is nothing to redact.
+func RedactError(err error, token string) error {
+	if err == nil {
+		return nil
+	}
+	message := RedactToken(err.Error(), token)
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, message: message}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// ModelLoadingError is returned by ConnectAIModel when the inference API
+// answers with 503 because the model is still being loaded.
+type ModelLoadingError struct {
+	Message       string
+	EstimatedTime float64
+}
+
+func (e *ModelLoadingError) Error() string {
+	return fmt.Sprintf("model is loading (estimated time %.1fs): %s", e.EstimatedTime, e.Message)
+}
+
+// RetryAfter reports how long the caller should wait before trying again.
+func (e *ModelLoadingError) RetryAfter() time.Duration {
+	return time.Duration(e.EstimatedTime * float64(time.Second))
+}
+
+// parseModelLoading returns a ModelLoadingError when body is the JSON the
+// inference API sends for a cold model, or nil otherwise.
+func parseModelLoading(body []byte) *ModelLoadingError {
+	var loading struct {
+		Error         string   `json:"error"`
+		EstimatedTime *float64 `json:"estimated_time"`
+	}
+	if err := json.Unmarshal(body, &loading); err != nil || loading.EstimatedTime == nil {
+		return nil
+	}
+
+	return &ModelLoadingError{Message: loading.Error, EstimatedTime: *loading.EstimatedTime}
+}
@@ -0,0 +1,93 @@
+package tableqa_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"a21hc3NpZ25tZW50/tableqa"
+)
+
+func ExampleCsvToSlice() {
+	table, err := tableqa.CsvToSlice("Appliance,Energy_Consumption\nRefrigerator,1.2\nTV,0.8\n")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(table["Appliance"], table["Energy_Consumption"])
+	// Output: [Refrigerator TV] [1.2 0.8]
+}
+
+func ExampleCsvToSlice_error() {
+	_, err := tableqa.CsvToSlice("Appliance,Energy_Consumption\nRefrigerator,1.2\nTV\n")
+	fmt.Println(err)
+	// Output: line 3: row 3 has 1 fields, expected 2: "TV"
+}
+
+func ExampleAIModelConnector_ConnectAIModel() {
+	// A stand-in for the inference API.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"answer": "SUM > 1.2, 0.8", "coordinates": [[0, 1], [1, 1]], "cells": ["1.2", "0.8"], "aggregator": "SUM"}`))
+	}))
+	defer api.Close()
+
+	connector := tableqa.NewAIModelConnector(tableqa.DefaultRequestTimeout)
+	connector.BaseURL = api.URL
+
+	table := map[string][]string{
+		"Appliance":          {"Refrigerator", "TV"},
+		"Energy_Consumption": {"1.2", "0.8"},
+	}
+	response, err := connector.ConnectAIModel(tableqa.Inputs{Table: table, Query: "What is the total energy consumption?"}, "hf_token")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	total, _ := response.ComputeAggregate()
+	fmt.Println(response.Aggregator, total)
+	// Output: SUM 2
+}
+
+func ExampleResponse_Enrich() {
+	table := map[string][]string{
+		"Appliance":          {"Refrigerator", "TV"},
+		"Energy_Consumption": {"1.2", "0.8"},
+	}
+	// Columns are indexed in Inputs.ColumnOrder, which sorts them by name
+	// unless Inputs.Columns fixes the order.
+	headers := tableqa.Inputs{Table: table}.ColumnOrder()
+	response := tableqa.Response{Answer: "TV", Coordinates: [][]int{{1, 0}}, Cells: []string{"TV"}, Aggregator: "NONE"}
+
+	enriched, err := response.Enrich(table, headers)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, cell := range enriched.ResolvedCells {
+		fmt.Printf("row %d, %s: %s\n", cell.Row, cell.Header, cell.Value)
+	}
+	// Output: row 1, Appliance: TV
+}
+
+func ExampleMockModel() {
+	var model tableqa.TableQAModel = tableqa.MockModel{}
+
+	table := map[string][]string{"Appliance": {"TV"}, "Room": {"Living Room"}}
+	response, err := model.Answer(context.Background(), tableqa.Inputs{Table: table, Query: "Which room is the TV in?"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(response.Answer, response.Coordinates)
+	// Output: Living Room [[0 1]]
+}
+
+func ExampleValidateTable() {
+	err := tableqa.ValidateTable(map[string][]string{
+		"Appliance": {"Refrigerator", "TV"},
+		"Room":      {"Kitchen"},
+	})
+	fmt.Println(err)
+	// Output: table columns have different lengths: expected 2 rows but Room has 1
+}
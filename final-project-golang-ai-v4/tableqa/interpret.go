@@ -0,0 +1,114 @@
+package tableqa
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Interpretation is the yes/no reading of an answer to a yes/no query, as
+// produced by InterpretAnswer.
+type Interpretation struct {
+	Yes bool `json:"yes"`
+	// Value, Operator and Threshold are set when the query was a comparison:
+	// Yes is whether Value, the answer as computed by ComputeAggregate,
+	// satisfies Operator (one of >, >=, <, <= and =) against Threshold.
+	Value     *float64 `json:"value,omitempty"`
+	Operator  string   `json:"operator,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+}
+
+// yesNoWords are the words a yes/no question starts with.
+var yesNoWords = map[string]bool{
+	"is": true, "are": true, "was": true, "were": true,
+	"do": true, "does": true, "did": true,
+	"has": true, "have": true, "had": true,
+	"can": true, "could": true, "will": true, "would": true, "should": true,
+}
+
+// comparisonPattern finds a comparison with a number in a query, such as
+// "above 100" or "at least 1,000", optionally negated by a "not" or "no" in
+// front of it.
+var comparisonPattern = regexp.MustCompile(`\b(?:(not|no)\s+)?(more than|greater than|higher than|larger than|above|over|exceeds?|at least|less than|fewer than|lower than|smaller than|below|under|at most|equal to|equals?)\s+(-?\d[\d,]*(?:\.\d+)?|-?\.\d+)`)
+
+// comparisonOperators maps the phrases of comparisonPattern to operators.
+var comparisonOperators = map[string]string{
+	"more than": ">", "greater than": ">", "higher than": ">", "larger than": ">",
+	"above": ">", "over": ">", "exceed": ">", "exceeds": ">",
+	"at least":  ">=",
+	"less than": "<", "fewer than": "<", "lower than": "<", "smaller than": "<",
+	"below": "<", "under": "<",
+	"at most":  "<=",
+	"equal to": "=", "equal": "=", "equals": "=",
+}
+
+// booleanCells are the cell values read as yes or no.
+var booleanCells = map[string]bool{
+	"yes": true, "y": true, "true": true, "on": true,
+	"no": false, "n": false, "false": false, "off": false,
+}
+
+// InterpretAnswer reads r as a yes or no when query is a yes/no question,
+// that is, one starting with a word like "is", "does" or "has". It is a
+// heuristic and reports false whenever it is unsure:
+//   - for a comparison with a number, as in "Is the total consumption above
+//     100?" or "Does the TV use at most 2 kWh?", the answer is computed with
+//     ComputeAggregate and compared to the number; a "not" or "no" in front
+//     of the comparison, as in "no more than 5", negates it;
+//   - otherwise the answer is yes or no when every non-empty selected cell
+//     reads as the same boolean, such as "Yes", "true" or "On".
+//
+// Queries that are not yes/no questions, like "What is the total?", are not
+// interpreted, and neither are comparisons whose cells are not numbers.
+func InterpretAnswer(query string, r Response) (Interpretation, bool) {
+	lower := strings.ToLower(query)
+	words := strings.Fields(lower)
+	if len(words) == 0 || !yesNoWords[words[0]] {
+		return Interpretation{}, false
+	}
+
+	if match := comparisonPattern.FindStringSubmatch(lower); match != nil {
+		threshold, err := parseNumber(match[3])
+		if err != nil {
+			return Interpretation{}, false
+		}
+		value, err := r.ComputeAggregate()
+		if err != nil {
+			return Interpretation{}, false
+		}
+		operator := comparisonOperators[match[2]]
+		yes := compare(value, operator, threshold)
+		if match[1] != "" {
+			yes = !yes
+		}
+		return Interpretation{Yes: yes, Value: &value, Operator: operator, Threshold: &threshold}, true
+	}
+
+	interpretation, found := Interpretation{}, false
+	for _, cell := range r.Cells {
+		cell = strings.ToLower(strings.TrimSpace(cell))
+		if cell == "" {
+			continue
+		}
+		yes, ok := booleanCells[cell]
+		if !ok || (found && yes != interpretation.Yes) {
+			return Interpretation{}, false
+		}
+		interpretation.Yes, found = yes, true
+	}
+	return interpretation, found
+}
+
+func compare(value float64, operator string, threshold float64) bool {
+	switch operator {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	default:
+		return value == threshold
+	}
+}
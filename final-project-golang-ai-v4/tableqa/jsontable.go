@@ -0,0 +1,113 @@
+package tableqa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSONToTable converts a JSON array of flat objects into the column map
+// produced by CsvToSlice, alongside the column names in order. Columns are
+// ordered as the keys of the first object, followed by keys first seen in
+// later objects; a key missing from an object is an empty cell. Strings are
+// used as-is, numbers keep their JSON spelling, booleans become "true" or
+// "false" and null becomes an empty cell. Nested objects and arrays are
+// rejected.
+func JSONToTable(data []byte) (map[string][]string, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('[') {
+		return nil, nil, errors.New("JSON table must be an array of objects")
+	}
+
+	var (
+		headers []string
+		rows    []map[string]string
+		seen    = map[string]bool{}
+	)
+	for dec.More() {
+		row, keys, err := readFlatObject(dec, len(rows)+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				headers = append(headers, key)
+			}
+		}
+		rows = append(rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, errors.New("unexpected data after JSON table")
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, errors.New("JSON table must contain at least one object")
+	}
+
+	result := make(map[string][]string, len(headers))
+	for _, header := range headers {
+		column := make([]string, len(rows))
+		for i, row := range rows {
+			column[i] = row[header]
+		}
+		result[header] = column
+	}
+	return result, headers, nil
+}
+
+// readFlatObject reads the n-th object of a JSON table from dec, returning
+// its cells and its keys in order.
+func readFlatObject(dec *json.Decoder, n int) (map[string]string, []string, error) {
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("element %d of JSON table is not an object", n)
+	}
+
+	row := map[string]string{}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value string
+		switch v := tok.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = fmt.Sprint(v)
+		case nil:
+			value = ""
+		default:
+			return nil, nil, fmt.Errorf("field %q of object %d is not a flat value", key, n)
+		}
+
+		if _, ok := row[key]; !ok {
+			keys = append(keys, key)
+		}
+		row[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return row, keys, nil
+}
@@ -0,0 +1,58 @@
+package tableqa
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+)
+
+// MockModel is an offline TableQAModel for local development. It never
+// touches the network: the answer is a single cell picked deterministically
+// from the query and table, so the same request always gets the same
+// response.
+type MockModel struct{}
+
+// Answer returns a cell from the first column named in the query, or from
+// the first column when none is, in a row chosen by hashing the query.
+func (MockModel) Answer(ctx context.Context, inputs Inputs) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	columns := inputs.ColumnOrder()
+	rows := TableRows(inputs.Table)
+	if len(columns) == 0 || rows == 0 {
+		return Response{Answer: "", Aggregator: "NONE"}, nil
+	}
+
+	column := 0
+	query := strings.ToLower(inputs.Query)
+	for i, name := range columns {
+		name = strings.ToLower(strings.ReplaceAll(name, "_", " "))
+		if name != "" && strings.Contains(query, name) {
+			column = i
+			break
+		}
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(inputs.Query))
+	row := int(hash.Sum32() % uint32(rows))
+
+	values := inputs.Table[columns[column]]
+	var value string
+	if row < len(values) {
+		value = values[row]
+	}
+	return Response{
+		Answer:      value,
+		Coordinates: [][]int{{row, column}},
+		Cells:       []string{value},
+		Aggregator:  "NONE",
+	}, nil
+}
+
+// Name labels mock answers in logs and metrics.
+func (MockModel) Name() string {
+	return "mock"
+}
@@ -0,0 +1,34 @@
+package tableqa
+
+import "context"
+
+// TableQAModel answers a natural-language query about a table. Code that
+// depends only on this interface can use backends other than the Hugging Face
+// inference API, such as MockModel.
+type TableQAModel interface {
+	Answer(ctx context.Context, inputs Inputs) (Response, error)
+}
+
+// HuggingFaceModel is the TableQAModel backed by a TAPAS-style model on the
+// Hugging Face inference API.
+type HuggingFaceModel struct {
+	Connector AIModelConnector
+	Token     string
+	// Retry controls retries of failed calls; the zero value makes one
+	// attempt.
+	Retry RetryPolicy
+}
+
+// Answer sends inputs to the model, retrying according to m.Retry.
+func (m *HuggingFaceModel) Answer(ctx context.Context, inputs Inputs) (Response, error) {
+	response, attempts, err := m.Connector.ConnectAIModelWithRetry(ctx, inputs, m.Token, m.Retry)
+	if attempts > 1 {
+		LoggerFromContext(ctx).Info("retried model call", "model", m.Name(), "attempts", attempts, "ok", err == nil)
+	}
+	return response, err
+}
+
+// Name returns the Hugging Face model ID, used to label logs and metrics.
+func (m *HuggingFaceModel) Name() string {
+	return m.Connector.ModelName()
+}
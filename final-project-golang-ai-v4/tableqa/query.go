@@ -0,0 +1,42 @@
+package tableqa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxQueryLength is the recommended bound on queries, in characters,
+// for ValidateQuery. TAPAS fits the query and the table into 512
+// tokens, so a longer query leaves no room for the table.
+const DefaultMaxQueryLength = 512
+
+// ErrEmptyQuery is returned by ValidateQuery for a query with no visible
+// characters.
+var ErrEmptyQuery = errors.New("query must not be empty")
+
+// ValidateQuery returns query with control characters removed and
+// surrounding whitespace trimmed. It fails if nothing is left or if the
+// result is longer than maxLength characters; a maxLength of zero or less
+// disables the length check.
+func ValidateQuery(query string, maxLength int) (string, error) {
+	query = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, query))
+
+	if query == "" {
+		return "", ErrEmptyQuery
+	}
+	if n := utf8.RuneCountInString(query); maxLength > 0 && n > maxLength {
+		return "", fmt.Errorf("query has %d characters, the limit is %d", n, maxLength)
+	}
+	return query, nil
+}
@@ -0,0 +1,283 @@
+package tableqa
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ResolvedCell is a cell selected by the model together with its position
+// and column header.
+type ResolvedCell struct {
+	Row    int    `json:"row"`
+	Column int    `json:"column"`
+	Header string `json:"header"`
+	Value  string `json:"value"`
+	// Index is the row's value in the index column, if one was given.
+	Index string `json:"index,omitempty"`
+	// Score is the model's confidence in the cell, if it reported one.
+	Score *float64 `json:"score,omitempty"`
+}
+
+// EnrichedResponse is a Response with its coordinates resolved against the
+// source table, for clients that highlight the selected cells.
+type EnrichedResponse struct {
+	Response
+	ResolvedCells []ResolvedCell `json:"resolved_cells"`
+	// IndexColumn names the column the cells' Index values come from.
+	IndexColumn string `json:"index_column,omitempty"`
+}
+
+// WriteCSV writes e as CSV with the header answer, aggregator, row, column,
+// header, value and one record per resolved cell, repeating the answer and
+// aggregator on each. An answer without cells is a single record with the
+// cell fields empty. With an index column, each record ends with an index
+// field.
+func (e EnrichedResponse) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	write := func(record ...string) {
+		if e.IndexColumn == "" {
+			record = record[:len(record)-1]
+		}
+		out.Write(record)
+	}
+	write("answer", "aggregator", "row", "column", "header", "value", "index")
+	if len(e.ResolvedCells) == 0 {
+		write(e.Answer, e.Aggregator, "", "", "", "", "")
+	}
+	for _, cell := range e.ResolvedCells {
+		write(e.Answer, e.Aggregator, strconv.Itoa(cell.Row), strconv.Itoa(cell.Column), cell.Header, cell.Value, cell.Index)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ResolveCells returns the table values at each [row, column] pair in
+// r.Coordinates. headers gives the column order the coordinates refer to.
+func (r Response) ResolveCells(table map[string][]string, headers []string) ([]string, error) {
+	cells, err := r.resolve(table, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(cells))
+	for i, cell := range cells {
+		values[i] = cell.Value
+	}
+	return values, nil
+}
+
+// Enrich resolves r.Coordinates against table like ResolveCells.
+func (r Response) Enrich(table map[string][]string, headers []string) (EnrichedResponse, error) {
+	return r.EnrichWithIndex(table, headers, "")
+}
+
+// EnrichWithIndex is like Enrich but also labels each cell with its row's
+// value in indexColumn, e.g. an ID column, so clients can show which logical
+// row was selected. An empty indexColumn adds no labels; any other must be a
+// column of table.
+func (r Response) EnrichWithIndex(table map[string][]string, headers []string, indexColumn string) (EnrichedResponse, error) {
+	var index []string
+	if indexColumn != "" {
+		var ok bool
+		if index, ok = table[indexColumn]; !ok {
+			return EnrichedResponse{}, fmt.Errorf("index column %q not in table", indexColumn)
+		}
+	}
+
+	cells, err := r.resolve(table, headers)
+	if err != nil {
+		return EnrichedResponse{}, err
+	}
+	for i := range cells {
+		if row := cells[i].Row; row < len(index) {
+			cells[i].Index = index[row]
+		}
+	}
+	return EnrichedResponse{Response: r, ResolvedCells: cells, IndexColumn: indexColumn}, nil
+}
+
+func (r Response) resolve(table map[string][]string, headers []string) ([]ResolvedCell, error) {
+	cells := make([]ResolvedCell, 0, len(r.Coordinates))
+	for _, coordinate := range r.Coordinates {
+		if len(coordinate) != 2 {
+			return nil, fmt.Errorf("invalid coordinate %v", coordinate)
+		}
+		row, col := coordinate[0], coordinate[1]
+
+		if col < 0 || col >= len(headers) {
+			return nil, fmt.Errorf("coordinate %v: column %d out of range", coordinate, col)
+		}
+		column, ok := table[headers[col]]
+		if !ok {
+			return nil, fmt.Errorf("coordinate %v: column %q not in table", coordinate, headers[col])
+		}
+		if row < 0 || row >= len(column) {
+			return nil, fmt.Errorf("coordinate %v: row %d out of range", coordinate, row)
+		}
+		cells = append(cells, ResolvedCell{Row: row, Column: col, Header: headers[col], Value: column[row]})
+	}
+	// Scores that do not line up with the coordinates cannot be attributed
+	// to cells.
+	if len(r.CellScores) == len(cells) {
+		for i := range cells {
+			score := r.CellScores[i]
+			cells[i].Score = &score
+		}
+	}
+	return cells, nil
+}
+
+// aggregatorPrefix matches the "SUM > " that TAPAS puts in front of the
+// cells of an aggregated answer.
+var aggregatorPrefix = regexp.MustCompile(`(?i)^(SUM|AVERAGE|COUNT|NONE)\s*>\s*`)
+
+// CleanAnswer returns r.Answer normalized for display. It:
+//   - removes a leading aggregator such as "SUM > " or "AVERAGE >", which
+//     r.Aggregator already carries;
+//   - rebuilds the answer from r.Cells when the model selected any, trimming
+//     each cell, dropping empty ones and joining the rest with ", ", since
+//     the raw answer joins them inconsistently and a cell may itself contain
+//     a comma, as in "1,200";
+//   - collapses runs of whitespace to a single space and trims the ends.
+//
+// An answer that is already clean, like "TV", is returned unchanged.
+func CleanAnswer(r Response) string {
+	if len(r.Cells) == 0 {
+		return aggregatorPrefix.ReplaceAllString(collapseSpace(r.Answer), "")
+	}
+
+	parts := make([]string, 0, len(r.Cells))
+	for _, cell := range r.Cells {
+		if cell = collapseSpace(cell); cell != "" {
+			parts = append(parts, cell)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// collapseSpace trims s and replaces each run of whitespace in it with a
+// single space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ComputeAggregate applies r.Aggregator to r.Cells. SUM and AVERAGE need every
+// cell to be numeric, COUNT counts the cells, and NONE (or an empty
+// aggregator) needs exactly one numeric cell, which is returned as is.
+func (r Response) ComputeAggregate() (float64, error) {
+	aggregator := strings.ToUpper(strings.TrimSpace(r.Aggregator))
+	if aggregator == "COUNT" {
+		return float64(len(r.Cells)), nil
+	}
+
+	numbers := make([]float64, len(r.Cells))
+	for i, cell := range r.Cells {
+		n, err := parseNumber(cell)
+		if err != nil {
+			return 0, fmt.Errorf("%s: cell %q is not a number", aggregator, cell)
+		}
+		numbers[i] = n
+	}
+
+	switch aggregator {
+	case "SUM", "AVERAGE":
+		if len(numbers) == 0 {
+			return 0, fmt.Errorf("%s: no cells selected", aggregator)
+		}
+		sum := 0.0
+		for _, n := range numbers {
+			sum += n
+		}
+		if aggregator == "AVERAGE" {
+			return sum / float64(len(numbers)), nil
+		}
+		return sum, nil
+	case "NONE", "":
+		if len(numbers) != 1 {
+			return 0, fmt.Errorf("NONE: expected one cell, got %d", len(numbers))
+		}
+		return numbers[0], nil
+	default:
+		return 0, fmt.Errorf("unsupported aggregator %q", r.Aggregator)
+	}
+}
+
+// aggregateTolerance is the relative difference between a numeric answer and
+// the aggregate of its cells that ConsistencyWarning lets pass as rounding.
+const aggregateTolerance = 0.01
+
+// ConsistencyWarning compares r.Answer with what r.Cells and r.Aggregator
+// give, returning a description of any significant discrepancy or "" when
+// they agree or there are no cells to compare with. A numeric answer must be
+// within 1% of ComputeAggregate; any other answer must list the non-empty
+// cells, as TAPAS does after its "SUM > " prefix, ignoring whitespace. An
+// answer filled in by FillAnswer always agrees.
+func (r Response) ConsistencyWarning() string {
+	if len(r.Cells) == 0 || r.AnswerFromCells {
+		return ""
+	}
+	answer := aggregatorPrefix.ReplaceAllString(collapseSpace(r.Answer), "")
+	if n, err := parseNumber(answer); err == nil {
+		if value, err := r.ComputeAggregate(); err == nil {
+			if math.Abs(n-value) > aggregateTolerance*math.Max(math.Abs(n), math.Abs(value)) {
+				return fmt.Sprintf("answer %q does not match %s of the cells, %s", r.Answer, aggregatorName(r.Aggregator), strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			return ""
+		}
+	}
+	if stripSpace(answer) != stripSpace(CleanAnswer(r)) {
+		return fmt.Sprintf("answer %q does not list the selected cells %q", r.Answer, r.Cells)
+	}
+	return ""
+}
+
+// aggregatorName is aggregator as ComputeAggregate reads it, with an empty
+// aggregator as NONE.
+func aggregatorName(aggregator string) string {
+	if aggregator = strings.ToUpper(strings.TrimSpace(aggregator)); aggregator == "" {
+		return "NONE"
+	}
+	return aggregator
+}
+
+// stripSpace removes all whitespace from s.
+func stripSpace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// FillAnswer returns r with an empty Answer rebuilt from r.Cells, which TAPAS
+// sometimes selects without putting them in the answer. For SUM, AVERAGE and
+// COUNT the answer is the result of ComputeAggregate; otherwise, or when the
+// cells cannot be aggregated, it is the non-empty cells joined with ", ". The
+// answer is left empty, and AnswerFromCells unset, when there are no
+// non-empty cells or the answer is not empty.
+func (r Response) FillAnswer() Response {
+	if strings.TrimSpace(r.Answer) != "" {
+		return r
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(r.Aggregator)) {
+	case "SUM", "AVERAGE", "COUNT":
+		if value, err := r.ComputeAggregate(); err == nil && len(r.Cells) > 0 {
+			r.Answer = strconv.FormatFloat(value, 'f', -1, 64)
+			r.AnswerFromCells = true
+			return r
+		}
+	}
+	if answer := CleanAnswer(r); answer != "" {
+		r.Answer = answer
+		r.AnswerFromCells = true
+	}
+	return r
+}
+
+// parseNumber parses a cell as a float, ignoring surrounding whitespace and
+// thousands separators.
+func parseNumber(cell string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(cell), ",", ""), 64)
+}
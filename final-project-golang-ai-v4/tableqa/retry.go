@@ -0,0 +1,135 @@
+package tableqa
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls how ConnectAIModelWithRetry retries failed calls.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of calls made, including the first.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles on each retry.
+	BaseDelay time.Duration
+	// Jitter randomizes each delay between half and all of its value.
+	Jitter bool
+	// MaxDelay caps each wait, including one the API asks for with
+	// Retry-After or an estimated loading time, so a far-off hint cannot
+	// hold a request for its whole length. Zero means DefaultMaxRetryDelay.
+	MaxDelay time.Duration
+}
+
+// DefaultMaxRetryDelay is the longest wait between attempts of a policy
+// without a MaxDelay.
+const DefaultMaxRetryDelay = 10 * time.Second
+
+// DefaultRetryPolicy suits interactive callers: up to three attempts, backing
+// off from half a second unless the API asks for a longer wait, and never
+// waiting more than DefaultMaxRetryDelay.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	Jitter:      true,
+	MaxDelay:    DefaultMaxRetryDelay,
+}
+
+// ConnectAIModelWithRetry calls ConnectAIModelWithContext, retrying on 429,
+// 503 and connection errors according to policy. It returns the number of
+// attempts made alongside the result of the last one. Waiting between
+// attempts stops early when ctx is done.
+func (c *AIModelConnector) ConnectAIModelWithRetry(ctx context.Context, payload interface{}, token string, policy RetryPolicy) (Response, int, error) {
+	var response Response
+	attempts, err := policy.do(ctx, func() (err error) {
+		response, err = c.ConnectAIModelWithContext(ctx, payload, token)
+		return err
+	})
+	return response, attempts, err
+}
+
+// do calls attempt until it succeeds, fails with an error that is not worth
+// retrying, or policy runs out of attempts, and returns the number of calls
+// made with the error of the last one.
+func (p RetryPolicy) do(ctx context.Context, attempt func() error) (int, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= maxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return n, err
+		}
+
+		timer := time.NewTimer(p.delay(n, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return n, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns how long to wait after the given failed attempt, at most
+// MaxDelay. A delay requested by the upstream takes precedence over the
+// backoff.
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+
+	var hinted interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hinted) {
+		if d := hinted.RetryAfter(); d > 0 {
+			return min(d, maxDelay)
+		}
+	}
+
+	d := p.BaseDelay << (attempt - 1)
+	if d < 0 || d > maxDelay {
+		d = maxDelay
+	}
+	if p.Jitter && d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d
+}
+
+func isRetryable(err error) bool {
+	var loading *ModelLoadingError
+	if errors.As(err, &loading) {
+		return true
+	}
+
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return upstream.StatusCode == http.StatusTooManyRequests ||
+			upstream.StatusCode == http.StatusServiceUnavailable
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// isUnavailable reports whether err means the model could not answer at all,
+// so that another model may be tried.
+func isUnavailable(err error) bool {
+	var loading *ModelLoadingError
+	if errors.As(err, &loading) {
+		return true
+	}
+
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return upstream.StatusCode >= 500
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,123 @@
+package tableqa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SequentialInputs is a conversation about one table: each query may refer
+// to the answers of the ones before it, as in "Which rooms use the most
+// energy?" followed by "Which of them is on the ground floor?". TAPAS models
+// trained on SQA answer such queries in sequential mode, which feeds each
+// answer's selected cells into the next query.
+type SequentialInputs struct {
+	Table   map[string][]string
+	Queries []string
+	// Columns fixes the column order like Inputs.Columns.
+	Columns []string
+}
+
+// MarshalJSON writes the table like Inputs, the queries as a list under
+// "query" and the parameter asking for sequential mode.
+func (in SequentialInputs) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"table":`)
+	if err := (Inputs{Table: in.Table, Columns: in.Columns}).writeTable(&buf); err != nil {
+		return nil, err
+	}
+
+	queries, err := json.Marshal(in.Queries)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(`,"query":`)
+	buf.Write(queries)
+	buf.WriteString(`,"parameters":{"sequential":true}}`)
+	return buf.Bytes(), nil
+}
+
+// SequentialModel is implemented by TableQAModels that can answer
+// SequentialInputs. Unlike answering each query with Answer, the queries are
+// answered in one pass, in order, so later queries see the earlier answers.
+type SequentialModel interface {
+	AnswerSequence(ctx context.Context, inputs SequentialInputs) ([]Response, error)
+}
+
+// ConnectAIModelSequence asks the model to answer inputs in sequential mode
+// and returns one Response per query, in order. It falls back to
+// c.Fallbacks like ConnectAIModelWithContext.
+func (c *AIModelConnector) ConnectAIModelSequence(ctx context.Context, inputs SequentialInputs, token string) ([]Response, error) {
+	if name := c.TaskName(); name != TaskTableQA {
+		return nil, fmt.Errorf("%s models do not answer sequential queries", name)
+	}
+	var responses []Response
+	model, err := c.call(ctx, inputs, token, func(body []byte) (err error) {
+		responses, err = decodeSequence(body, len(inputs.Queries))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Fallbacks) > 0 {
+		for i := range responses {
+			responses[i].Model = model
+		}
+	}
+	return responses, nil
+}
+
+// decodeSequence decodes the 200 body of a sequential call, which must be a
+// list of n answers, each valid for decodeResponse.
+func decodeSequence(body []byte, n int) ([]Response, error) {
+	var answers []json.RawMessage
+	if err := json.Unmarshal(body, &answers); err != nil {
+		// a single answer or an error object is decoded for its message
+		if _, err := decodeResponse(body); err != nil {
+			return nil, err
+		}
+		return nil, &InvalidResponseError{Reason: "expected a list of answers", Body: truncateBody(body)}
+	}
+	if len(answers) != n {
+		return nil, &InvalidResponseError{Reason: fmt.Sprintf("got %d answers for %d queries", len(answers), n), Body: truncateBody(body)}
+	}
+
+	responses := make([]Response, n)
+	for i, answer := range answers {
+		response, err := decodeResponse(answer)
+		if err != nil {
+			return nil, fmt.Errorf("answer %d: %w", i+1, err)
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
+
+// AnswerSequence sends inputs to the model in sequential mode, retrying
+// according to m.Retry.
+func (m *HuggingFaceModel) AnswerSequence(ctx context.Context, inputs SequentialInputs) ([]Response, error) {
+	var responses []Response
+	attempts, err := m.Retry.do(ctx, func() (err error) {
+		responses, err = m.Connector.ConnectAIModelSequence(ctx, inputs, m.Token)
+		return err
+	})
+	if attempts > 1 {
+		LoggerFromContext(ctx).Info("retried model call", "model", m.Name(), "attempts", attempts, "ok", err == nil)
+	}
+	return responses, err
+}
+
+// AnswerSequence answers each query with Answer; the mock has no context to
+// carry between them.
+func (m MockModel) AnswerSequence(ctx context.Context, inputs SequentialInputs) ([]Response, error) {
+	responses := make([]Response, len(inputs.Queries))
+	for i, query := range inputs.Queries {
+		response, err := m.Answer(ctx, Inputs{Table: inputs.Table, Query: query, Columns: inputs.Columns})
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
@@ -0,0 +1,415 @@
+package tableqa
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TableLimits bounds the size of a table sent to the model. A zero field
+// disables that check.
+type TableLimits struct {
+	MaxRows    int
+	MaxColumns int
+	MaxCells   int
+}
+
+// DefaultTableLimits follows the constraints of tapas-base-finetuned-wtq: its
+// row and column position embeddings stop at 256, and the table shares a
+// 512-token window with the query, with every cell taking at least one token.
+var DefaultTableLimits = TableLimits{
+	MaxRows:    256,
+	MaxColumns: 256,
+	MaxCells:   512,
+}
+
+// TableTooLargeError is returned when a table exceeds its TableLimits.
+type TableTooLargeError struct {
+	Rows, Columns, Cells int
+	Limits               TableLimits
+}
+
+func (e *TableTooLargeError) Error() string {
+	return fmt.Sprintf("table has %d rows, %d columns and %d cells, limits are %d rows, %d columns and %d cells",
+		e.Rows, e.Columns, e.Cells, e.Limits.MaxRows, e.Limits.MaxColumns, e.Limits.MaxCells)
+}
+
+// ValidateTableSize checks table against DefaultTableLimits.
+func ValidateTableSize(table map[string][]string) error {
+	return DefaultTableLimits.Validate(table)
+}
+
+// Validate returns a *TableTooLargeError when table exceeds l.
+func (l TableLimits) Validate(table map[string][]string) error {
+	rows := TableRows(table)
+	columns := len(table)
+	cells := rows * columns
+
+	if (l.MaxRows > 0 && rows > l.MaxRows) ||
+		(l.MaxColumns > 0 && columns > l.MaxColumns) ||
+		(l.MaxCells > 0 && cells > l.MaxCells) {
+		return &TableTooLargeError{Rows: rows, Columns: columns, Cells: cells, Limits: l}
+	}
+	return nil
+}
+
+// TableRows returns the length of the longest column of table.
+func TableRows(table map[string][]string) int {
+	rows := 0
+	for _, values := range table {
+		if len(values) > rows {
+			rows = len(values)
+		}
+	}
+	return rows
+}
+
+// TruncateTable returns a copy of table holding only its first maxRows rows,
+// with every column cut at the same row. The input is not modified.
+func TruncateTable(table map[string][]string, maxRows int) map[string][]string {
+	if maxRows < 0 {
+		maxRows = 0
+	}
+	result := make(map[string][]string, len(table))
+	for name, values := range table {
+		n := len(values)
+		if n > maxRows {
+			n = maxRows
+		}
+		truncated := make([]string, n)
+		copy(truncated, values)
+		result[name] = truncated
+	}
+	return result
+}
+
+// ColumnLengthError is returned by ValidateTable when the columns of a table
+// are not all the same length.
+type ColumnLengthError struct {
+	// Expected is the length shared by most columns.
+	Expected int
+	// Columns maps every column of another length to its length.
+	Columns map[string]int
+}
+
+func (e *ColumnLengthError) Error() string {
+	names := make([]string, 0, len(e.Columns))
+	for name := range e.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	details := make([]string, len(names))
+	for i, name := range names {
+		details[i] = fmt.Sprintf("%s has %d", name, e.Columns[name])
+	}
+	return fmt.Sprintf("table columns have different lengths: expected %d rows but %s", e.Expected, strings.Join(details, ", "))
+}
+
+// ValidateTable checks that table has at least one column and that all its
+// columns have the same length, returning a *ColumnLengthError naming the
+// columns that differ. The model rejects misaligned tables with an error
+// that does not say which column is at fault.
+func ValidateTable(table map[string][]string) error {
+	if len(table) == 0 {
+		return errors.New("table must have at least one column")
+	}
+	return checkColumnLengths(table)
+}
+
+// checkColumnLengths is the part of ValidateTable that returns a
+// *ColumnLengthError.
+func checkColumnLengths(table map[string][]string) error {
+	// The expected length is the most common one, ties going to the longest,
+	// so a single truncated column is the one reported.
+	counts := map[int]int{}
+	for _, values := range table {
+		counts[len(values)]++
+	}
+	expected := -1
+	for length, count := range counts {
+		if expected < 0 || count > counts[expected] || (count == counts[expected] && length > expected) {
+			expected = length
+		}
+	}
+	if len(counts) <= 1 {
+		return nil
+	}
+
+	mismatched := map[string]int{}
+	for name, values := range table {
+		if len(values) != expected {
+			mismatched[name] = len(values)
+		}
+	}
+	return &ColumnLengthError{Expected: expected, Columns: mismatched}
+}
+
+// sortedColumns returns the column names of table in sorted order, the
+// column order of an Inputs without Columns.
+func sortedColumns(table map[string][]string) []string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NormalizeOptions controls NormalizeTable.
+type NormalizeOptions struct {
+	// EmptyPlaceholder, when set, replaces cells that are empty after
+	// trimming.
+	EmptyPlaceholder string
+}
+
+// NormalizeTable returns a copy of table with every cell trimmed and runs of
+// internal whitespace collapsed to a single space. The input is not modified.
+func NormalizeTable(table map[string][]string, opts NormalizeOptions) map[string][]string {
+	result := make(map[string][]string, len(table))
+	for name, values := range table {
+		normalized := make([]string, len(values))
+		for i, value := range values {
+			value = strings.Join(strings.Fields(value), " ")
+			if value == "" {
+				value = opts.EmptyPlaceholder
+			}
+			normalized[i] = value
+		}
+		result[name] = normalized
+	}
+	return result
+}
+
+// RoundOptions controls RoundNumericColumns.
+type RoundOptions struct {
+	// Precision is the number of digits kept after the decimal point.
+	Precision int
+}
+
+// RoundNumericColumns returns a copy of table with the decimals in its
+// numeric columns, as InferColumnTypes classifies them, rounded half away
+// from zero to opts.Precision digits, so long float representations do not
+// end up in coordinates and answers. Thousands separators are kept. Values
+// with no more digits than that or written another way, such as 1e-5, are
+// left as they are, and so are the other columns, which the copy shares with
+// table. The input is not modified.
+func RoundNumericColumns(table map[string][]string, opts RoundOptions) map[string][]string {
+	result := make(map[string][]string, len(table))
+	for name, values := range table {
+		if inferColumnType(values).Kind != KindNumeric {
+			result[name] = values
+			continue
+		}
+		rounded := make([]string, len(values))
+		for i, value := range values {
+			rounded[i] = roundDecimal(value, opts.Precision)
+		}
+		result[name] = rounded
+	}
+	return result
+}
+
+// roundDecimal rounds a decimal such as "-1,234.5678" to precision digits
+// after the point. It works on the digits rather than a float64, in which
+// 2.675 is slightly below 2.675 and would round down.
+func roundDecimal(value string, precision int) string {
+	number := strings.TrimSpace(value)
+	sign := ""
+	if strings.HasPrefix(number, "-") || strings.HasPrefix(number, "+") {
+		sign, number = number[:1], number[1:]
+	}
+	whole, fraction, ok := strings.Cut(number, ".")
+	if !ok || precision < 0 || len(fraction) <= precision || !isDigits(fraction, "") || !isDigits(whole, ",") {
+		return value
+	}
+	grouped := strings.Contains(whole, ",")
+	whole = strings.ReplaceAll(whole, ",", "")
+
+	digits := []byte(whole + fraction[:precision])
+	if fraction[precision] >= '5' {
+		i := len(digits) - 1
+		for ; i >= 0 && digits[i] == '9'; i-- {
+			digits[i] = '0'
+		}
+		if i < 0 {
+			digits = append([]byte{'1'}, digits...)
+		} else {
+			digits[i]++
+		}
+	}
+	whole, fraction = string(digits[:len(digits)-precision]), string(digits[len(digits)-precision:])
+
+	if whole == "" {
+		whole = "0"
+	} else if grouped {
+		whole = groupThousands(whole)
+	}
+	if strings.Trim(whole+fraction, "0,") == "" {
+		sign = ""
+	}
+	if precision == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// isDigits reports whether s consists of ASCII digits and the bytes in also.
+func isDigits(s string, also string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && !strings.ContainsRune(also, rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupThousands separates the digits of whole into groups of three with
+// commas.
+func groupThousands(whole string) string {
+	var b strings.Builder
+	for i := 0; i < len(whole); i++ {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(whole[i])
+	}
+	return b.String()
+}
+
+// FilterTable returns a copy of table holding only the rows whose value in
+// column satisfies predicate, with every column kept aligned. The input is not
+// modified.
+func FilterTable(table map[string][]string, column string, predicate func(string) bool) (map[string][]string, error) {
+	values, ok := table[column]
+	if !ok {
+		return nil, fmt.Errorf("filter column %q does not exist", column)
+	}
+
+	var keep []int
+	for i, value := range values {
+		if predicate(value) {
+			keep = append(keep, i)
+		}
+	}
+
+	result := make(map[string][]string, len(table))
+	for name, values := range table {
+		filtered := make([]string, 0, len(keep))
+		for _, i := range keep {
+			if i < len(values) {
+				filtered = append(filtered, values[i])
+			}
+		}
+		result[name] = filtered
+	}
+	return result, nil
+}
+
+// ProjectTable returns the columns of table named in columns, for asking
+// about only some of them. Every name must be a column of table and appear
+// once. The result shares its column slices with table, which is not
+// modified.
+func ProjectTable(table map[string][]string, columns []string) (map[string][]string, error) {
+	result := make(map[string][]string, len(columns))
+	for _, name := range columns {
+		values, ok := table[name]
+		if !ok {
+			return nil, fmt.Errorf("column %q does not exist", name)
+		}
+		if _, ok := result[name]; ok {
+			return nil, fmt.Errorf("column %q is listed twice", name)
+		}
+		result[name] = values
+	}
+	return result, nil
+}
+
+// RowFilter keeps the rows whose value in Column is exactly Equals, or
+// contains Contains ignoring case. Exactly one of the two must be set.
+type RowFilter struct {
+	Column   string  `json:"column"`
+	Equals   *string `json:"equals,omitempty"`
+	Contains *string `json:"contains,omitempty"`
+}
+
+// Apply filters table with FilterTable.
+func (f RowFilter) Apply(table map[string][]string) (map[string][]string, error) {
+	switch {
+	case (f.Equals == nil) == (f.Contains == nil):
+		return nil, fmt.Errorf("filter on %q must set exactly one of equals or contains", f.Column)
+	case f.Equals != nil:
+		want := *f.Equals
+		return FilterTable(table, f.Column, func(value string) bool { return value == want })
+	default:
+		want := strings.ToLower(*f.Contains)
+		return FilterTable(table, f.Column, func(value string) bool { return strings.Contains(strings.ToLower(value), want) })
+	}
+}
+
+// JoinTables returns the left outer join of left and right on their key
+// column, for asking about data split across normalized tables. Every row
+// of left appears once for each row of right with the same key, and once
+// with empty right cells when there is none; rows of right matching no row
+// of left are dropped. The key column appears once. Other columns of right
+// whose names are taken by left are renamed like repeated CSV headers, e.g.
+// "Name_2". The inputs are not modified.
+func JoinTables(left, right map[string][]string, key string) (map[string][]string, error) {
+	for _, side := range []struct {
+		name  string
+		table map[string][]string
+	}{{"left", left}, {"right", right}} {
+		if _, ok := side.table[key]; !ok {
+			return nil, fmt.Errorf("join key %q does not exist in the %s table", key, side.name)
+		}
+		if err := ValidateTable(side.table); err != nil {
+			return nil, fmt.Errorf("%s table: %w", side.name, err)
+		}
+	}
+
+	rightRows := make(map[string][]int)
+	for i, value := range right[key] {
+		rightRows[value] = append(rightRows[value], i)
+	}
+
+	// pairs are the joined rows as a row index into each table, with -1 for
+	// a missing right row.
+	var pairs [][2]int
+	for i, value := range left[key] {
+		matches := rightRows[value]
+		if len(matches) == 0 {
+			pairs = append(pairs, [2]int{i, -1})
+		}
+		for _, j := range matches {
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+
+	result := make(map[string][]string, len(left)+len(right)-1)
+	for name, values := range left {
+		joined := make([]string, len(pairs))
+		for row, pair := range pairs {
+			joined[row] = values[pair[0]]
+		}
+		result[name] = joined
+	}
+	for _, name := range sortedColumns(right) {
+		if name == key {
+			continue
+		}
+		renamed := name
+		for n := 2; result[renamed] != nil; n++ {
+			renamed = fmt.Sprintf("%s_%d", name, n)
+		}
+		values := right[name]
+		joined := make([]string, len(pairs))
+		for row, pair := range pairs {
+			if pair[1] >= 0 {
+				joined[row] = values[pair[1]]
+			}
+		}
+		result[renamed] = joined
+	}
+	return result, nil
+}
@@ -0,0 +1,155 @@
+package tableqa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Hugging Face pipeline tags of the model kinds TaskByName knows.
+const (
+	TaskTableQA            = "table-question-answering"
+	TaskTextGeneration     = "text-generation"
+	TaskTextClassification = "text-classification"
+)
+
+// ModelTask adapts AIModelConnector to one kind of model, since models of
+// each kind take and return differently shaped JSON. TableQATask, the
+// default, sends the table as is and decodes TAPAS answers; the text tasks
+// let the connector call general-purpose models too.
+type ModelTask interface {
+	// Name is the task's Hugging Face pipeline tag.
+	Name() string
+	// Payload returns the request body for inputs, to be encoded as JSON.
+	Payload(inputs Inputs) (interface{}, error)
+	// Decode turns a 200 response body into a Response, returning an
+	// *InvalidResponseError when the body is not a valid answer.
+	Decode(body []byte) (Response, error)
+}
+
+var (
+	// TableQATask calls TAPAS-style table question answering models.
+	TableQATask ModelTask = tableQATask{}
+	// TextGenerationTask calls text generation models, prompting them with
+	// the table as CSV followed by the query, and answers with the
+	// generated text.
+	TextGenerationTask ModelTask = textGenerationTask{}
+	// TextClassificationTask calls text classification models on the query
+	// alone and answers with the most likely label.
+	TextClassificationTask ModelTask = textClassificationTask{}
+)
+
+// TaskByName returns the task with the given pipeline tag; "" is
+// TableQATask.
+func TaskByName(name string) (ModelTask, error) {
+	switch name {
+	case "", TaskTableQA:
+		return TableQATask, nil
+	case TaskTextGeneration:
+		return TextGenerationTask, nil
+	case TaskTextClassification:
+		return TextClassificationTask, nil
+	}
+	return nil, fmt.Errorf("unknown model task %q, expected %s, %s or %s", name, TaskTableQA, TaskTextGeneration, TaskTextClassification)
+}
+
+type tableQATask struct{}
+
+func (tableQATask) Name() string { return TaskTableQA }
+
+func (tableQATask) Payload(inputs Inputs) (interface{}, error) { return inputs, nil }
+
+func (tableQATask) Decode(body []byte) (Response, error) { return decodeResponse(body) }
+
+// textInputs is the request body of the text tasks.
+type textInputs struct {
+	Inputs     string                 `json:"inputs"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type textGenerationTask struct{}
+
+func (textGenerationTask) Name() string { return TaskTextGeneration }
+
+// Payload asks for the generated text alone, without the prompt in front.
+func (textGenerationTask) Payload(inputs Inputs) (interface{}, error) {
+	table, err := SliceToCsv(inputs.Table, inputs.ColumnOrder())
+	if err != nil {
+		return nil, err
+	}
+	prompt := "Answer the question about this table.\n\n" + table + "\nQuestion: " + inputs.Query + "\nAnswer:"
+	return textInputs{Inputs: prompt, Parameters: map[string]interface{}{"return_full_text": false}}, nil
+}
+
+// Decode reads [{"generated_text": "..."}], or the same object on its own.
+func (textGenerationTask) Decode(body []byte) (Response, error) {
+	var generations []struct {
+		Text *string `json:"generated_text"`
+	}
+	if err := decodeTextAnswer(body, &generations); err != nil {
+		return Response{}, err
+	}
+	if len(generations) == 0 || generations[0].Text == nil {
+		return Response{}, &InvalidResponseError{Reason: `missing "generated_text" field`, Body: truncateBody(body)}
+	}
+	return textResponse(strings.TrimSpace(*generations[0].Text)), nil
+}
+
+type textClassificationTask struct{}
+
+func (textClassificationTask) Name() string { return TaskTextClassification }
+
+func (textClassificationTask) Payload(inputs Inputs) (interface{}, error) {
+	return textInputs{Inputs: inputs.Query}, nil
+}
+
+// Decode reads [[{"label": "...", "score": 0.9}, ...]], the labels of one
+// input, or the inner list on its own.
+func (textClassificationTask) Decode(body []byte) (Response, error) {
+	type label struct {
+		Label *string `json:"label"`
+		Score float64 `json:"score"`
+	}
+	var labels []label
+	var nested [][]label
+	if err := json.Unmarshal(body, &nested); err == nil {
+		if len(nested) > 0 {
+			labels = nested[0]
+		}
+	} else if err := decodeTextAnswer(body, &labels); err != nil {
+		return Response{}, err
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].Score > labels[j].Score })
+	if len(labels) == 0 || labels[0].Label == nil {
+		return Response{}, &InvalidResponseError{Reason: `missing "label" field`, Body: truncateBody(body)}
+	}
+	return textResponse(*labels[0].Label), nil
+}
+
+// decodeTextAnswer decodes body, a list of answers or a single answer
+// object, into list. An object with an "error" field is reported as such.
+func decodeTextAnswer(body []byte, list interface{}) error {
+	answers := body
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err == nil {
+		if message, ok := fields["error"]; ok {
+			return &InvalidResponseError{Reason: "model returned an error: " + string(message), Body: truncateBody(body)}
+		}
+		answers = append(append([]byte("["), body...), ']')
+	}
+	if err := json.Unmarshal(answers, list); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return &InvalidResponseError{Reason: "body is not JSON", Body: truncateBody(body)}
+		}
+		return &InvalidResponseError{Reason: err.Error(), Body: truncateBody(body)}
+	}
+	return nil
+}
+
+// textResponse is the Response of a text task, which selects no cells.
+func textResponse(answer string) Response {
+	return Response{Answer: answer, Coordinates: [][]int{}, Cells: []string{}, Aggregator: "NONE"}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TableRegistry holds named tables loaded from a directory of CSV files. Each
+// table is a TableCache, so edited files are picked up without a restart.
+type TableRegistry struct {
+	tables map[string]*TableCache
+}
+
+// LoadTableDir loads every *.csv and gzipped *.csv.gz file in dir into a
+// registry keyed by file name without the extension, so "energy.csv" and
+// "energy.csv.gz" are queried as "energy". It fails if any of the files
+// cannot be parsed, or if two files have the same name.
+func LoadTableDir(dir string) (*TableRegistry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(filepath.Join(dir, "*.csv.gz"))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, compressed...)
+
+	registry := &TableRegistry{tables: make(map[string]*TableCache, len(paths))}
+	for _, path := range paths {
+		cache, err := NewTableCache(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", path, err)
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ".csv")
+		if _, ok := registry.tables[name]; ok {
+			return nil, fmt.Errorf("loading %s: table %q is already loaded from another file", path, name)
+		}
+		registry.tables[name] = cache
+	}
+	return registry, nil
+}
+
+// Get returns the table called name.
+func (r *TableRegistry) Get(name string) (*TableCache, bool) {
+	if r == nil {
+		return nil, false
+	}
+	cache, ok := r.tables[name]
+	return cache, ok
+}
+
+// Names returns the names of the loaded tables in sorted order.
+func (r *TableRegistry) Names() []string {
+	names := []string{}
+	if r == nil {
+		return names
+	}
+	for name := range r.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
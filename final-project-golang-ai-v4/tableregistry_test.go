@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TableRegistry", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "energy.csv"), []byte("Appliance,Room\nLamp,Bedroom\n"), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "rooms.csv"), []byte("Room,Floor\nBedroom,1\n"), 0o600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a table"), 0o600)).Should(Succeed())
+	})
+
+	It("loads every CSV in the directory by name", func() {
+		tables, err := main.LoadTableDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tables.Names()).Should(Equal([]string{"energy", "rooms"}))
+
+		rooms, ok := tables.Get("rooms")
+		Expect(ok).Should(BeTrue())
+		_, headers, err := rooms.Get()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(headers).Should(Equal([]string{"Room", "Floor"}))
+	})
+
+	It("fails on a malformed CSV", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "broken.csv"), []byte("a,b\n1\n"), 0o600)).Should(Succeed())
+		_, err := main.LoadTableDir(dir)
+		Expect(err).Should(MatchError(ContainSubstring("broken.csv")))
+	})
+
+	Describe("POST /ask with a table name", func() {
+		var (
+			model  *fakeModel
+			server *main.Server
+		)
+
+		BeforeEach(func() {
+			tables, err := main.LoadTableDir(dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			model = &fakeModel{response: main.Response{Answer: "Bedroom"}}
+			server = &main.Server{Model: model, Tables: tables}
+		})
+
+		ask := func(body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(body)))
+			return rec
+		}
+
+		It("queries the named table", func() {
+			Expect(ask(`{"table": "energy", "query": "Where is the lamp?"}`).Code).Should(Equal(http.StatusOK))
+			Expect(ask(`{"table": "rooms", "query": "Which floor is the bedroom on?"}`).Code).Should(Equal(http.StatusOK))
+
+			Expect(model.received).Should(HaveLen(2))
+			Expect(model.received[0].Table).Should(Equal(map[string][]string{"Appliance": {"Lamp"}, "Room": {"Bedroom"}}))
+			Expect(model.received[1].Table).Should(Equal(map[string][]string{"Room": {"Bedroom"}, "Floor": {"1"}}))
+		})
+
+		It("returns 404 listing the available tables", func() {
+			rec := ask(`{"table": "missing", "query": "q"}`)
+			Expect(rec.Code).Should(Equal(http.StatusNotFound))
+			var body main.ErrorResponse
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(Succeed())
+			Expect(body.Error.Code).Should(Equal(main.CodeTableNotFound))
+			Expect(body.Error.Message).Should(Equal(`Table "missing" not found`))
+			Expect(body.Error.Details["tables"]).Should(Equal([]interface{}{"energy", "rooms"}))
+			Expect(model.received).Should(BeEmpty())
+		})
+	})
+})
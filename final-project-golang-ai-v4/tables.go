@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ColumnSchema is one column of a TableSchema.
+type ColumnSchema struct {
+	Name string     `json:"name"`
+	Type ColumnType `json:"type"`
+}
+
+// TableSchema describes a table that can be queried, as listed by GET
+// /tables.
+type TableSchema struct {
+	Name    string         `json:"name,omitempty"`
+	Columns []ColumnSchema `json:"columns"`
+	Rows    int            `json:"rows"`
+}
+
+// handleTables lists the schema of the server's CSV, which has no name, as
+// "default" and of each named table under "tables", in name order.
+func (s *Server) handleTables(c *gin.Context) {
+	response := gin.H{}
+
+	if s.Data != nil {
+		schema, err := tableSchema("", s.Data)
+		if err != nil {
+			writeDataError(c, "CSV file", err)
+			return
+		}
+		response["default"] = schema
+	}
+
+	tables := []TableSchema{}
+	for _, name := range s.Tables.Names() {
+		cache, _ := s.Tables.Get(name)
+		schema, err := tableSchema(name, cache)
+		if err != nil {
+			writeDataError(c, fmt.Sprintf("table %q", name), err)
+			return
+		}
+		tables = append(tables, schema)
+	}
+	response["tables"] = tables
+
+	c.JSON(http.StatusOK, response)
+}
+
+func tableSchema(name string, cache *TableCache) (TableSchema, error) {
+	columns, rows, err := cache.Schema()
+	if err != nil {
+		return TableSchema{}, err
+	}
+	return TableSchema{Name: name, Columns: columns, Rows: rows}, nil
+}
@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GET /tables", func() {
+	It("lists the schema of every table", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "rooms.csv"), []byte("Room,Floor\nBedroom,1\nKitchen,0\n"), 0o600)).Should(Succeed())
+		tables, err := main.LoadTableDir(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		path := filepath.Join(GinkgoT().TempDir(), "energy.csv")
+		Expect(os.WriteFile(path, []byte("Date,Energy_Consumption\n2022-01-01,1.5\n"), 0o600)).Should(Succeed())
+		data, err := main.NewTableCache(path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		server := &main.Server{Data: data, Tables: tables}
+		rec := httptest.NewRecorder()
+		server.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tables", nil))
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{
+			"default": {
+				"columns": [
+					{"name": "Date", "type": {"kind": "date", "confidence": 1}},
+					{"name": "Energy_Consumption", "type": {"kind": "numeric", "confidence": 1}}
+				],
+				"rows": 1
+			},
+			"tables": [{
+				"name": "rooms",
+				"columns": [
+					{"name": "Room", "type": {"kind": "text", "confidence": 1}},
+					{"name": "Floor", "type": {"kind": "integer", "confidence": 1}}
+				],
+				"rows": 2
+			}]
+		}`))
+	})
+
+	It("returns an empty list without named tables", func() {
+		rec := httptest.NewRecorder()
+		(&main.Server{}).Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tables", nil))
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{"tables": []}`))
+	})
+})
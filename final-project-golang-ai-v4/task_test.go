@@ -0,0 +1,121 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ModelTask", func() {
+	inputs := main.Inputs{
+		Table:   map[string][]string{"Appliance": {"TV", "Lamp"}, "Room": {"Living Room", "Bedroom, upstairs"}},
+		Query:   "Where is the lamp?",
+		Columns: []string{"Appliance", "Room"},
+	}
+
+	answer := func(task main.ModelTask, body string) (main.Response, error) {
+		connector := newStaticConnector(http.StatusOK, body)
+		connector.Task = task
+		return connector.ConnectAIModelWithContext(context.Background(), inputs, "hf_test")
+	}
+
+	It("finds tasks by their pipeline tag", func() {
+		for name, task := range map[string]main.ModelTask{
+			"":                          main.TableQATask,
+			main.TaskTableQA:            main.TableQATask,
+			main.TaskTextGeneration:     main.TextGenerationTask,
+			main.TaskTextClassification: main.TextClassificationTask,
+		} {
+			found, err := main.TaskByName(name)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(found).Should(Equal(task))
+		}
+		_, err := main.TaskByName("summarization")
+		Expect(err).Should(MatchError(ContainSubstring(`unknown model task "summarization"`)))
+	})
+
+	Describe("table question answering", func() {
+		It("is the default", func() {
+			response, err := answer(nil, `{"answer": "Bedroom, upstairs", "coordinates": [[1, 1]], "cells": ["Bedroom, upstairs"], "aggregator": "NONE"}`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Answer).Should(Equal("Bedroom, upstairs"))
+			Expect(response.Coordinates).Should(Equal([][]int{{1, 1}}))
+
+			payload, err := (&main.AIModelConnector{}).BuildPayload(inputs)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(payload).Should(MatchJSON(`{"table": {"Appliance": ["TV", "Lamp"], "Room": ["Living Room", "Bedroom, upstairs"]}, "query": "Where is the lamp?"}`))
+		})
+
+		It("rejects text generation answers", func() {
+			_, err := answer(main.TableQATask, `[{"generated_text": "Bedroom"}]`)
+			var invalid *main.InvalidResponseError
+			Expect(errors.As(err, &invalid)).Should(BeTrue())
+		})
+	})
+
+	Describe("text generation", func() {
+		It("prompts with the table as CSV", func() {
+			payload, err := (&main.AIModelConnector{Task: main.TextGenerationTask}).BuildPayload(inputs)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(payload).Should(MatchJSON(`{
+				"inputs": "Answer the question about this table.\n\nAppliance,Room\nTV,Living Room\nLamp,\"Bedroom, upstairs\"\n\nQuestion: Where is the lamp?\nAnswer:",
+				"parameters": {"return_full_text": false}
+			}`))
+		})
+
+		It("decodes the generated text", func() {
+			response, err := answer(main.TextGenerationTask, `[{"generated_text": " The bedroom, upstairs.\n"}]`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response).Should(Equal(main.Response{Answer: "The bedroom, upstairs.", Coordinates: [][]int{}, Cells: []string{}, Aggregator: "NONE"}))
+
+			response, err = answer(main.TextGenerationTask, `{"generated_text": "Bedroom"}`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Answer).Should(Equal("Bedroom"))
+		})
+
+		It("rejects other shapes", func() {
+			for _, body := range []string{
+				`{"answer": "Bedroom", "coordinates": [[1, 1]], "cells": ["Bedroom"], "aggregator": "NONE"}`,
+				`[]`,
+				`{"error": "Input is too long"}`,
+				`not json`,
+			} {
+				_, err := answer(main.TextGenerationTask, body)
+				var invalid *main.InvalidResponseError
+				Expect(errors.As(err, &invalid)).Should(BeTrue(), body)
+			}
+		})
+	})
+
+	Describe("text classification", func() {
+		It("sends the query and answers with the most likely label", func() {
+			payload, err := (&main.AIModelConnector{Task: main.TextClassificationTask}).BuildPayload(inputs)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(payload).Should(MatchJSON(`{"inputs": "Where is the lamp?"}`))
+
+			for _, body := range []string{
+				`[[{"label": "location", "score": 0.9}, {"label": "usage", "score": 0.1}]]`,
+				`[{"label": "usage", "score": 0.1}, {"label": "location", "score": 0.9}]`,
+			} {
+				response, err := answer(main.TextClassificationTask, body)
+				Expect(err).ShouldNot(HaveOccurred(), body)
+				Expect(response.Answer).Should(Equal("location"), body)
+			}
+
+			_, err = answer(main.TextClassificationTask, `[[]]`)
+			Expect(err).Should(MatchError(ContainSubstring(`missing "label" field`)))
+		})
+	})
+
+	It("refuses sequential queries to text models", func() {
+		connector := newStaticConnector(http.StatusOK, `[]`)
+		connector.Task = main.TextGenerationTask
+		_, err := connector.ConnectAIModelSequence(context.Background(), main.SequentialInputs{Table: inputs.Table, Queries: []string{"q"}}, "hf_test")
+		Expect(err).Should(MatchError("text-generation models do not answer sequential queries"))
+	})
+})
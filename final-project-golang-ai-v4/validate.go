@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleValidateCSV parses a CSV without querying it and responds with its
+// TableSchema, so users can check a file before asking about it. The CSV is
+// the request body, or the "file" field of a multipart form as for
+// /ask-upload, and may be gzipped. A CSV that /ask-upload would reject is
+// answered with 400; when the error is on a line, the response also has
+// "line", "column" (0 if unknown) and the offending "snippet".
+func (s *Server) handleValidateCSV(c *gin.Context) {
+	maxSize := s.maxUploadSize()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			writeUploadError(c, err, maxSize)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err == nil {
+		data, err = Decompress(data, maxSize)
+	}
+	if err != nil {
+		writeUploadError(c, err, maxSize)
+		return
+	}
+	if !LooksLikeText(data) {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeUnsupportedMedia, "Uploaded file is not a text CSV; export spreadsheets as CSV before uploading"))
+		return
+	}
+
+	table, headers, err := CsvToSliceOrdered(string(data))
+	if err != nil {
+		s.Metrics.csvParseFailed()
+		c.JSON(http.StatusBadRequest, csvErrorJSON(c, err))
+		return
+	}
+
+	types := InferColumnTypes(table)
+	columns := make([]ColumnSchema, len(headers))
+	for i, name := range headers {
+		columns[i] = ColumnSchema{Name: name, Type: types[name]}
+	}
+	c.JSON(http.StatusOK, TableSchema{Columns: columns, Rows: len(table[headers[0]])})
+}
+
+// csvErrorJSON is the csv_parse_error body for a CSV that cannot be parsed,
+// locating the error when possible.
+func csvErrorJSON(c *gin.Context, err error) *ErrorResponse {
+	if isEmptyCSV(err) {
+		return errorJSON(c, CodeEmptyCSV, fmt.Sprintf("Invalid CSV: %v", err))
+	}
+	response := errorJSON(c, CodeCSVParse, fmt.Sprintf("Invalid CSV: %v", err))
+	var lineErr *CsvLineError
+	if errors.As(err, &lineErr) {
+		response.Error.Details = gin.H{"line": lineErr.Line, "column": lineErr.Column, "snippet": lineErr.Snippet}
+	}
+	return response
+}
+
+// isEmptyCSV reports whether err is about a CSV without data.
+func isEmptyCSV(err error) bool {
+	return errors.Is(err, ErrEmptyCSV) || errors.Is(err, ErrNoDataRows)
+}
+
+// writeDataError responds to a configured CSV, described by what, that
+// could not be read. A file without data is a problem with the data rather
+// than the server, answered with 400 and empty_csv; other errors are 500.
+func writeDataError(c *gin.Context, what string, err error) {
+	if isEmptyCSV(err) {
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeEmptyCSV, fmt.Sprintf("Error reading %s: %v", what, err)))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, errorJSON(c, CodeDataUnavailable, fmt.Sprintf("Error reading %s: %v", what, err)))
+}
+
+// writeUploadError responds to an upload that could not be read, with 413
+// when it is larger than maxSize bytes, compressed or not.
+func writeUploadError(c *gin.Context, err error, maxSize int64) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, errorJSON(c, CodeTooLarge, fmt.Sprintf("Upload exceeds %d bytes", maxSize)))
+	case errors.Is(err, ErrDecompressedTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, errorJSON(c, CodeTooLarge, fmt.Sprintf("Decompressed upload exceeds %d bytes", maxSize)))
+	default:
+		c.JSON(http.StatusBadRequest, errorJSON(c, CodeInvalidRequest, fmt.Sprintf("Error reading uploaded file: %v", err)))
+	}
+}
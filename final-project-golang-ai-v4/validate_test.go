@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("POST /validate-csv", func() {
+	var (
+		model  *fakeModel
+		router http.Handler
+	)
+
+	BeforeEach(func() {
+		model = &fakeModel{}
+		router = (&main.Server{Model: model}).Router()
+	})
+
+	validate := func(csv string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/validate-csv", strings.NewReader(csv))
+		req.Header.Set("Content-Type", "text/csv")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("returns the schema of a valid CSV without calling the model", func() {
+		rec := validate("Date,Appliance,Energy_Consumption\n2022-01-01,TV,1.5\n2022-01-02,Fridge,2\n")
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{
+			"columns": [
+				{"name": "Date", "type": {"kind": "date", "confidence": 1}},
+				{"name": "Appliance", "type": {"kind": "text", "confidence": 1}},
+				{"name": "Energy_Consumption", "type": {"kind": "numeric", "confidence": 1}}
+			],
+			"rows": 2
+		}`))
+		Expect(model.received).Should(BeEmpty())
+	})
+
+	It("accepts the CSV as a multipart upload", func() {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, newMultipartRequest("/validate-csv", "text/csv", "Room,Floor\nKitchen,0\n", nil))
+
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"rows":1`))
+	})
+
+	It("reports the line of a row with the wrong number of fields", func() {
+		rec := validate("Room,Floor\nKitchen,0\nBedroom,1,extra\n")
+
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring("row 3 has 3 fields, expected 2"))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"line":3`))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"snippet":"Bedroom,1,extra"`))
+	})
+
+	It("reports the line and column of a malformed quote", func() {
+		rec := validate("Room,Floor\n\"Kitchen,0\nBedroom,1\n")
+
+		Expect(rec.Code).Should(Equal(http.StatusBadRequest))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"line":`))
+		Expect(rec.Body.String()).Should(ContainSubstring(`"column":`))
+	})
+
+	It("rejects duplicate headers, files without rows and binary data", func() {
+		for csv, message := range map[string]string{
+			"Room,Room\nKitchen,0\n": `duplicate column \"Room\"`,
+			"Room,Floor\n":           "at least one row of data",
+			"PK\x03\x04\x00\x00":     "not a text CSV",
+		} {
+			rec := validate(csv)
+			Expect(rec.Code).Should(Equal(http.StatusBadRequest), csv)
+			Expect(rec.Body.String()).Should(ContainSubstring(message), csv)
+		}
+	})
+})
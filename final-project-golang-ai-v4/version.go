@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.Version=v1.2.0 -X main.Commit=$(git rev-parse --short HEAD) -X main.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildTime = "dev"
+)
+
+// handleVersion reports which build is running.
+func (s *Server) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"version":    Version,
+		"commit":     Commit,
+		"build_time": BuildTime,
+		"go_version": runtime.Version(),
+	})
+}
@@ -0,0 +1,37 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GET /version", func() {
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		(&main.Server{}).Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+		return rec
+	}
+
+	It("defaults to dev builds", func() {
+		rec := get()
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{"version": "dev", "commit": "dev", "build_time": "dev", "go_version": "` + runtime.Version() + `"}`))
+	})
+
+	It("reports the values injected at build time", func() {
+		DeferCleanup(func(version, commit, buildTime string) {
+			main.Version, main.Commit, main.BuildTime = version, commit, buildTime
+		}, main.Version, main.Commit, main.BuildTime)
+		main.Version, main.Commit, main.BuildTime = "v1.2.0", "abc1234", "2024-05-01T10:00:00Z"
+
+		rec := get()
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+		Expect(rec.Body.String()).Should(MatchJSON(`{"version": "v1.2.0", "commit": "abc1234", "build_time": "2024-05-01T10:00:00Z", "go_version": "` + runtime.Version() + `"}`))
+	})
+})
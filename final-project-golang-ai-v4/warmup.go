@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultWarmupTimeout bounds the startup warmup, which waits out a cold
+// model loading.
+const DefaultWarmupTimeout = 2 * time.Minute
+
+// WarmupRetryPolicy is how the warmup retries while the model loads: more
+// patient than DefaultRetryPolicy, since no user is waiting, and still
+// deferring to the estimated loading time the inference API reports, up to
+// half a minute at a time.
+var WarmupRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   2 * time.Second,
+	Jitter:      true,
+	MaxDelay:    30 * time.Second,
+}
+
+// warmupInputs is the dummy query sent by Warmup.
+var warmupInputs = Inputs{
+	Table:   map[string][]string{"Appliance": {"TV"}},
+	Query:   "Which appliance?",
+	Columns: []string{"Appliance"},
+}
+
+// Warmup sends a tiny query to the configured model, so that a model which
+// went cold is loaded before the first real request instead of during it. It
+// skips the inference API's cache, which would answer without loading the
+// model, and bypasses the answer cache, circuit breaker and metrics, which
+// are for real traffic.
+func (s *Server) Warmup(ctx context.Context) error {
+	model := s.modelFor(askOptions{disableCache: true})
+	if hf, ok := model.(*HuggingFaceModel); ok {
+		hf.Retry = WarmupRetryPolicy
+	}
+	_, err := model.Answer(ctx, warmupInputs)
+	return err
+}
@@ -0,0 +1,68 @@
+package main_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	main "a21hc3NpZ25tZW50"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server.Warmup", func() {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   []string
+		loading  int
+		hf       *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests, bodies, loading = nil, nil, 0
+		hf = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, r)
+			bodies = append(bodies, string(body))
+			if len(requests) <= loading {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error": "Model google/tapas-base-finetuned-wtq is currently loading", "estimated_time": 0.01}`))
+				return
+			}
+			w.Write([]byte(`{"answer": "TV", "coordinates": [[0, 0]], "cells": ["TV"], "aggregator": "NONE"}`))
+		}))
+		DeferCleanup(hf.Close)
+	})
+
+	It("sends a dummy query to the model, bypassing the inference API's cache", func() {
+		server := &main.Server{Connector: newServerConnector(hf), Token: "token"}
+		Expect(server.Warmup(context.Background())).Should(Succeed())
+
+		Expect(requests).Should(HaveLen(1))
+		Expect(requests[0].Header.Get("Authorization")).Should(Equal("Bearer token"))
+		Expect(requests[0].Header.Get("x-use-cache")).Should(Equal("false"))
+		Expect(bodies[0]).Should(MatchJSON(`{"table": {"Appliance": ["TV"]}, "query": "Which appliance?"}`))
+	})
+
+	It("waits for a loading model longer than requests do", func() {
+		loading = 3
+		server := &main.Server{Connector: newServerConnector(hf), Token: "token", Retry: &main.RetryPolicy{MaxAttempts: 1}}
+		Expect(server.Warmup(context.Background())).Should(Succeed())
+		Expect(requests).Should(HaveLen(4))
+	})
+
+	It("reports a model that does not load in time", func() {
+		loading = 100
+		server := &main.Server{Connector: newServerConnector(hf), Token: "token"}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(server.Warmup(ctx)).ShouldNot(Succeed())
+	})
+})